@@ -0,0 +1,133 @@
+package context
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidChunk 表示分块传输编码（Transfer-Encoding: chunked）的报文主体格式错误
+var ErrInvalidChunk = errors.New("invalid chunked encoding")
+
+// maxChunkLineBytes 是块大小行和尾部字段每一行的最大长度
+const maxChunkLineBytes = 4096
+
+// Chunked 方法返回报文主体是否使用分块传输编码，即 Transfer-Encoding 头部字段的最后一个编码是 chunked：
+func (m *Context) Chunked() bool {
+	te := m.Header("Transfer-Encoding")
+	if i := strings.LastIndexByte(te, ','); i >= 0 {
+		te = te[i+1:]
+	}
+	return strings.EqualFold(strings.TrimSpace(te), "chunked")
+}
+
+// NewChunkedReader 函数返回一个从 r 中解码分块传输编码的报文主体的读取器，读到大小为0的最后一个块时返回 io.EOF：
+// 最后一个块之后的尾部字段会被读取并存储到 trailers 中，这样它们不会留在 r 中破坏下一个报文的读取
+func NewChunkedReader(r *bufio.Reader, trailers map[string]string) io.Reader {
+	return &chunkedReader{r: r, trailers: trailers}
+}
+
+// chunkedReader 逐块读取分块传输编码的报文主体
+type chunkedReader struct {
+	r        *bufio.Reader
+	trailers map[string]string
+	n        int64 // 当前块中还没有读取的字节数
+	err      error
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.n == 0 {
+		size, err := cr.readChunkSize()
+		if err != nil {
+			cr.err = err
+			return 0, err
+		}
+		if size == 0 { // 最后一个块，读取尾部字段
+			cr.err = cr.readTrailers()
+			if cr.err == nil {
+				cr.err = io.EOF
+			}
+			return 0, cr.err
+		}
+		cr.n = size
+	}
+
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+	n, err := cr.r.Read(p)
+	cr.n -= int64(n)
+	if err == io.EOF { // 块还没有结束连接就关闭了
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && cr.n == 0 { // 每个块的数据之后是一个换行
+		line, lineErr := cr.readLine()
+		if lineErr == nil && len(line) != 0 {
+			lineErr = ErrInvalidChunk
+		}
+		err = lineErr
+	}
+	if err != nil {
+		cr.err = err
+	}
+	return n, err
+}
+
+// readChunkSize 读取一个块大小行，忽略块扩展，例如 "1a;name=value"
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	line, err := cr.readLine()
+	if err != nil {
+		return 0, err
+	}
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 64)
+	if err != nil || size < 0 {
+		return 0, ErrInvalidChunk
+	}
+	return size, nil
+}
+
+// readTrailers 读取最后一个块之后的尾部字段，直到遇到空行
+func (cr *chunkedReader) readTrailers() error {
+	for {
+		line, err := cr.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		parts := bytes.SplitN(line, []byte{':'}, 2)
+		if len(parts) != 2 {
+			return ErrInvalidChunk
+		}
+		if cr.trailers != nil {
+			cr.trailers[string(parts[0])] = string(bytes.TrimSpace(parts[1]))
+		}
+	}
+}
+
+// readLine 读取一行并去掉末尾的换行符，一行过长时返回 ErrInvalidChunk
+func (cr *chunkedReader) readLine() ([]byte, error) {
+	remaining := math.MaxInt
+	line, err := readLine(cr.r, &remaining, maxChunkLineBytes)
+	if err == ErrHeaderTooLarge {
+		return nil, ErrInvalidChunk
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return trimEOL(line), nil
+}
@@ -4,66 +4,540 @@ package context
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"net/textproto"
+	"net/url"
 	"strconv"
 	"strings"
 )
 
 type Context struct {
-	StartLine string            // 起始行
-	Headers   map[string]string // 头部字段
-	Body      []byte            // 报文主体
+	StartLine  string            // 起始行
+	Headers    map[string]string // 头部字段，同名字段出现多次时是第一个值，所有的值由 HeaderValues 返回
+	Body       []byte            // 报文主体
+	BodyReader io.Reader         // 报文主体的读取器，流式读取时由服务器设置，Body 为空时从这里读取
+
+	Uncompressed bool // 报文主体是否已经由 Decompress 从 Content-Encoding 中解码
+
+	bodyDiscarded bool // 报文主体是否已经被 DiscardBody 丢弃
+
+	values map[string][]string // 出现多次的头部字段的所有值，键与 Headers 中的相同
+
+	multipartForm *MultipartForm // ParseMultipartForm 解析出的表单，其中的临时文件由 RemoveTempFiles 删除
 }
 
+var (
+	// ErrBodyPartiallyRead 表示报文主体已经通过 BodyReader 读取了一部分，不能再完整读取或切换协议
+	ErrBodyPartiallyRead = errors.New("body partially read through BodyReader")
+
+	// ErrBodyDiscarded 表示报文主体已经被 DiscardBody 丢弃
+	ErrBodyDiscarded = errors.New("body already discarded")
+)
+
+// ErrDecompressedTooLarge 表示解码后的报文主体超过了 Decompress 的长度限制
+var ErrDecompressedTooLarge = errors.New("decompressed body exceeds limit")
+
 // NewContext 函数用于从 Req 变量中创建一个 Context 实例，并返回它：
+// Req 需要包含完整的请求；服务器不使用它，而是用 ReadContextLimit 从连接的缓冲读取器中读取完整的起始行和头部，再按长度流式读取主体
 func NewContext(Req []byte) (*Context, error) {
-	m := &Context{}                            // 创建一个空的 Context 实例
 	r := bufio.NewReader(bytes.NewReader(Req)) // 创建一个 Reader 对象，用于从 Req 变量中读取数据
 
+	m, err := ReadContext(r) // 读取起始行和头部字段
+	if err != nil {
+		return nil, err
+	}
+
+	// 读取报文主体
+	if m.Chunked() { // 分块传输编码，解码所有的块，尾部字段存储到头部字段中
+		m.Body, err = io.ReadAll(NewChunkedReader(r, m.Headers))
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	length, err := m.ContentLength() // 从头部字段中获取内容长度（Content-Length）
+	if err != nil {
+		return nil, err // 如果转换失败，返回错误
+	}
+	if length < 0 { // 如果没有内容长度，说明没有报文主体
+		return m, nil // 返回 Context 实例
+	}
+	m.Body = make([]byte, length)   // 创建一个指定长度的字节切片，用于存储报文主体
+	_, err = io.ReadFull(r, m.Body) // 从 Reader 对象中读取指定长度的数据，存储到报文主体中
+	if err != nil {
+		return nil, err // 如果读取失败，返回错误
+	}
+
+	return m, nil // 返回 Context 实例
+}
+
+// ReadContext 函数用于从 r 中读取起始行和头部字段，创建一个 Context 实例并返回它，报文主体留在 r 中由调用者读取：
+func ReadContext(r *bufio.Reader) (*Context, error) {
+	return ReadContextLimit(r, 0, 0)
+}
+
+// ReadContextLimit 函数与 ReadContext 相同，但起始行和头部字段的总长度不能超过 maxBytes，其中每一行不能超过 maxLineBytes，超过时返回 ErrHeaderTooLarge：
+// 一行比 r 的缓冲区还长时会被读入一个可以增长的缓冲区，所以很大但合法的头部（例如很多 Cookie）也能被读取；限制为0或负数时不限制
+func ReadContextLimit(r *bufio.Reader, maxBytes, maxLineBytes int) (*Context, error) {
+	m := &Context{} // 创建一个空的 Context 实例
+	remaining := maxBytes
+	if remaining <= 0 {
+		remaining = math.MaxInt
+	}
+	if maxLineBytes <= 0 {
+		maxLineBytes = math.MaxInt
+	}
+
 	// 读取起始行
-	startLine, err := r.ReadBytes('\n') // 读取直到遇到换行符（\n）为止
+	startLine, err := readLine(r, &remaining, maxLineBytes) // 读取直到遇到换行符（\n）为止
 	if err != nil {
 		return nil, err // 如果读取失败，返回错误
 	}
-	m.StartLine = string(startLine[:len(startLine)-2]) // 将起始行转换为字符串，并去掉最后的回车换行符（CRLF）
+	m.StartLine = string(trimEOL(startLine)) // 将起始行转换为字符串，并去掉最后的回车换行符（CRLF 或 LF）
+	if m.StartLine == "" {
+		return nil, errors.New("invalid start line")
+	}
 
 	// 读取头部字段
 	m.Headers = make(map[string]string) // 创建一个空的 map，用于存储头部字段
+	keys := make(map[string]string)     // 小写的名称到 Headers 中的键，用于合并不同大小写的同名字段
 	for {
-		line, err := r.ReadBytes('\n') // 读取直到遇到换行符（\n）为止
+		line, err := readLine(r, &remaining, maxLineBytes) // 读取直到遇到换行符（\n）为止
 		if err != nil {
 			return nil, err // 如果读取失败，返回错误
 		}
-		if len(line) == 2 { // 如果只有两个字节，说明是空行，表示头部字段结束
+		line = trimEOL(line) // 有些客户端只用换行符（LF）结束一行，只在存在时去掉回车符（CR）
+		if len(line) == 0 {  // 如果是空行，表示头部字段结束
 			break // 跳出循环
 		}
 		parts := bytes.SplitN(line, []byte{':'}, 2) // 将每一行按照冒号（:）分割成两个部分
 		if len(parts) != 2 {                        // 如果不是两个部分，说明格式错误
 			return nil, errors.New("invalid header format") // 返回错误
 		}
-		name := string(parts[0])                                     // 第一个部分是头部字段的名称
-		value := string(bytes.TrimSpace(parts[1][:len(parts[1])-2])) // 第二个部分是头部字段的值，需要去掉前后的空白字符和最后的回车换行符（CRLF）
-		m.Headers[name] = value                                      // 将头部字段的名称和值存储在 map 中
+		name := string(parts[0])                   // 第一个部分是头部字段的名称
+		value := string(bytes.TrimSpace(parts[1])) // 第二个部分是头部字段的值，需要去掉前后的空白字符
+		lower := strings.ToLower(name)
+		key, seen := keys[lower]
+		if !seen { // 将头部字段的名称和值存储在 map 中
+			keys[lower] = name
+			m.Headers[name] = value
+			continue
+		}
+		// 同名字段出现多次（例如多个 Cookie 或 X-Forwarded-For），Headers 中保留第一个值，所有的值按顺序记录下来
+		if m.values == nil {
+			m.values = make(map[string][]string)
+		}
+		if m.values[key] == nil {
+			m.values[key] = []string{m.Headers[key]}
+		}
+		m.values[key] = append(m.values[key], value)
 	}
 
-	// 读取报文主体
-	contentLength, ok := m.Headers["Content-Length"] // 从头部字段中获取内容长度（Content-Length）
-	if !ok {                                         // 如果没有内容长度，说明没有报文主体
-		return m, nil // 返回 Context 实例
+	return m, nil // 返回 Context 实例
+}
+
+// ErrHeaderTooLarge 表示起始行和头部字段的总长度或者其中一行的长度超过了 ReadContextLimit 的限制
+var ErrHeaderTooLarge = errors.New("header fields too large")
+
+// readLine 读取一行，包括末尾的换行符；remaining 是还允许读取的字节数，maxLineBytes 是这一行的最大长度，超过时返回 ErrHeaderTooLarge
+// 一行比缓冲区长时，已经读到的部分会被复制到一个可以增长的缓冲区中，然后继续读取
+func readLine(r *bufio.Reader, remaining *int, maxLineBytes int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		*remaining -= len(chunk)
+		if *remaining < 0 || len(line)+len(chunk) > maxLineBytes {
+			return nil, ErrHeaderTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			line = append(line, chunk...)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			return append([]byte(nil), chunk...), nil
+		}
+		return append(line, chunk...), nil
+	}
+}
+
+// trimEOL 去掉一行末尾的换行符（LF），以及它前面的回车符（CR），如果有的话
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	return bytes.TrimSuffix(line, []byte{'\r'})
+}
+
+// Header 方法返回名称为 name 的头部字段的值，名称不区分大小写，例如 "upgrade" 和 "Upgrade" 是同一个头部字段：
+// Headers 中保留了客户端发送的原始名称，直接用下标访问时区分大小写
+func (m *Context) Header(name string) string {
+	value, _ := m.LookupHeader(name)
+	return value
+}
+
+// LookupHeader 方法与 Header 相同，同时返回这个头部字段是否存在：
+func (m *Context) LookupHeader(name string) (string, bool) {
+	if key, ok := m.headerKey(name); ok {
+		return m.Headers[key], true
+	}
+	return "", false
+}
+
+// HeaderValues 方法按照出现的顺序返回名称为 name 的头部字段的所有值，名称不区分大小写，没有这个头部字段时返回 nil：
+// 同名字段出现多次时 Header 只返回第一个值
+func (m *Context) HeaderValues(name string) []string {
+	key, ok := m.headerKey(name)
+	if !ok {
+		return nil
+	}
+	if values, ok := m.values[key]; ok {
+		return append([]string(nil), values...)
+	}
+	return []string{m.Headers[key]}
+}
+
+// SetHeader 方法设置名称为 name 的头部字段的值，替换已经存在的不同大小写的同名字段和它的所有值：
+func (m *Context) SetHeader(name, value string) {
+	m.DelHeader(name)
+	m.Headers[name] = value
+}
+
+// DelHeader 方法删除名称为 name 的头部字段，名称不区分大小写：
+func (m *Context) DelHeader(name string) {
+	for key, ok := m.headerKey(name); ok; key, ok = m.headerKey(name) {
+		delete(m.Headers, key)
+		delete(m.values, key)
+	}
+}
+
+// CanonicalizeHeaders 方法将 Headers 中的名称改写为规范的形式，例如 "content-type" 改为 "Content-Type"。
+// 默认情况下 Headers 保留客户端发送的原始名称，只有需要规范名称时（见 server.Server.CanonicalHeaderKeys）才调用它
+func (m *Context) CanonicalizeHeaders() {
+	for key, value := range m.Headers {
+		canonical := textproto.CanonicalMIMEHeaderKey(key)
+		if canonical == key {
+			continue
+		}
+		delete(m.Headers, key)
+		m.Headers[canonical] = value
+		if values, ok := m.values[key]; ok {
+			delete(m.values, key)
+			m.values[canonical] = values
+		}
+	}
+}
+
+// headerKey 返回 Headers 中与 name 不区分大小写地相同的键，先尝试精确匹配
+func (m *Context) headerKey(name string) (string, bool) {
+	if _, ok := m.Headers[name]; ok {
+		return name, true
+	}
+	for key := range m.Headers {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Method 方法返回起始行中的请求方法，例如 "GET"：
+func (m *Context) Method() string {
+	if i := strings.IndexByte(m.StartLine, ' '); i >= 0 {
+		return m.StartLine[:i]
+	}
+	return m.StartLine
+}
+
+// Path 方法返回起始行中请求目标的路径部分，不包含查询字符串，例如 "/users"：
+func (m *Context) Path() string {
+	parts := strings.Split(m.StartLine, " ")
+	if len(parts) < 2 {
+		return ""
+	}
+	path := parts[1]
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
+// Query 方法返回起始行中请求目标的查询字符串解析出的参数，键和值都经过URL解码，重复的键保留所有的值：
+// "?a=" 得到空字符串值，没有 "=" 的 "?flag" 同样得到空字符串值，无法解码的参数被忽略；没有查询字符串时返回空的 map
+func (m *Context) Query() map[string][]string {
+	values, _ := url.ParseQuery(m.RawQuery()) // 出错时 values 中仍然包含可以解码的参数
+	return values
+}
+
+// RawQuery 方法返回起始行中请求目标的查询字符串，不包含 "?"，没有经过解码，例如 "a=1&b=%20"：
+func (m *Context) RawQuery() string {
+	parts := strings.Split(m.StartLine, " ")
+	if len(parts) < 2 {
+		return ""
+	}
+	_, rawQuery, _ := strings.Cut(parts[1], "?")
+	return rawQuery
+}
+
+// SetPath 方法替换起始行中请求目标的路径部分，保留请求方法、查询字符串和协议版本：
+func (m *Context) SetPath(path string) {
+	parts := strings.Split(m.StartLine, " ")
+	if len(parts) < 2 {
+		return
+	}
+	target := parts[1]
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		path += target[i:]
+	}
+	parts[1] = path
+	m.StartLine = strings.Join(parts, " ")
+}
+
+// ContentType 方法返回 Content-Type 头部字段中的媒体类型，去掉参数并转为小写，例如 "application/json"：
+func (m *Context) ContentType() string {
+	contentType := m.Header("Content-Type")
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Authorization 方法返回 Authorization 头部字段中的认证方案和凭据，例如 "Bearer" 和令牌，没有这个头部字段时都返回空字符串：
+func (m *Context) Authorization() (scheme, credentials string) {
+	auth := strings.TrimSpace(m.Header("Authorization"))
+	if i := strings.IndexByte(auth, ' '); i >= 0 {
+		return auth[:i], strings.TrimSpace(auth[i+1:])
+	}
+	return auth, ""
+}
+
+// Accepts 方法根据 Accept 头部字段的质量值从 offers 中选出客户端最想要的媒体类型，质量值相同时按 offers 的顺序选择，
+// 支持 "*/*" 和 "text/*" 这样的通配符；没有这个头部字段时返回第一个类型，都不能接受时返回空字符串：
+func (m *Context) Accepts(offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	accept := strings.TrimSpace(m.Header("Accept"))
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, strings.ToLower(offer)); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality 返回 Accept 头部字段中与 offer 匹配的最具体的媒体范围的质量值，没有匹配时返回0
+func acceptQuality(accept, offer string) float64 {
+	q, specificity := 0.0, -1
+	for _, item := range strings.Split(accept, ",") {
+		params := strings.Split(item, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		s := -1
+		switch {
+		case mediaRange == offer:
+			s = 2
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, mediaRange[:len(mediaRange)-1]):
+			s = 1
+		case mediaRange == "*/*" || mediaRange == "*":
+			s = 0
+		}
+		if s <= specificity { // 更具体的媒体范围优先，例如 "text/html;q=0" 覆盖 "*/*"
+			continue
+		}
+
+		itemQ := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					itemQ = v
+				}
+			}
+		}
+		q, specificity = itemQ, s
 	}
-	length, err := strconv.Atoi(contentLength) // 将内容长度转换为整数
+	return q
+}
+
+// ContentLength 方法返回 Content-Length 头部字段的值，没有这个头部字段时返回 -1：
+// 多个 Content-Length 字段的值不同时返回错误，它们可能被用于请求走私
+func (m *Context) ContentLength() (int64, error) {
+	contentLength, ok := m.LookupHeader("Content-Length")
+	if !ok {
+		return -1, nil
+	}
+	for _, value := range m.HeaderValues("Content-Length") {
+		if value != contentLength {
+			return 0, errors.New("conflicting content lengths")
+		}
+	}
+	length, err := strconv.ParseInt(contentLength, 10, 64)
 	if err != nil {
-		return nil, err // 如果转换失败，返回错误
+		return 0, err
 	}
-	m.Body = make([]byte, length)   // 创建一个指定长度的字节切片，用于存储报文主体
-	_, err = io.ReadFull(r, m.Body) // 从 Reader 对象中读取指定长度的数据，存储到报文主体中
+	if length < 0 {
+		return 0, errors.New("invalid content length")
+	}
+	return length, nil
+}
+
+// SetBodyReader 方法将 r 设置为报文主体的读取器，并记录通过它读取了多少数据：
+func (m *Context) SetBodyReader(r io.Reader) {
+	m.BodyReader = &bodyReader{r: r}
+}
+
+// BodyPartiallyRead 方法返回 BodyReader 是否已经被读取了一部分但还没有读完：
+func (m *Context) BodyPartiallyRead() bool {
+	br, ok := m.BodyReader.(*bodyReader)
+	return ok && br.n > 0 && !br.eof
+}
+
+// ReadBody 方法用于从 BodyReader 中读取完整的报文主体，存储到 Body 中并返回它：
+// 如果 BodyReader 已经被读取了一部分，返回 ErrBodyPartiallyRead；如果主体已经被丢弃，返回 ErrBodyDiscarded
+func (m *Context) ReadBody() ([]byte, error) {
+	if m.bodyDiscarded {
+		return nil, ErrBodyDiscarded
+	}
+	if m.Body != nil || m.BodyReader == nil { // 已经读取过，或者没有可读取的报文主体
+		return m.Body, nil
+	}
+	if m.BodyPartiallyRead() {
+		return nil, ErrBodyPartiallyRead
+	}
+	body, err := io.ReadAll(m.BodyReader)
 	if err != nil {
-		return nil, err // 如果读取失败，返回错误
+		return nil, err
 	}
+	m.Body = body
+	m.BodyReader = nil
+	return m.Body, nil
+}
 
-	return m, nil // 返回 Context 实例
+// TeeBody 方法使之后从 BodyReader 读取的报文主体同时被写入 w，例如用于审计日志，主体不需要被读取两次或者完整缓冲：
+// 必须在读取主体之前调用，主体已经被读取了一部分时返回 ErrBodyPartiallyRead；主体已经被完整读取到 Body 中时直接将它写入 w。
+// 写入 w 失败时读取也会返回这个错误。服务器最后丢弃的剩余主体同样会被写入 w，写入的总长度不会超过服务器的主体长度限制
+func (m *Context) TeeBody(w io.Writer) error {
+	if m.bodyDiscarded {
+		return ErrBodyDiscarded
+	}
+	if m.Body != nil {
+		_, err := w.Write(m.Body)
+		return err
+	}
+	if m.BodyReader == nil {
+		return nil
+	}
+	if m.BodyPartiallyRead() {
+		return ErrBodyPartiallyRead
+	}
+	if br, ok := m.BodyReader.(*bodyReader); ok {
+		br.r = io.TeeReader(br.r, w)
+		return nil
+	}
+	m.SetBodyReader(io.TeeReader(m.BodyReader, w))
+	return nil
+}
+
+// DiscardBody 方法读取并丢弃 BodyReader 中剩余的报文主体，使底层连接停在下一段数据的开头：
+func (m *Context) DiscardBody() error {
+	if m.BodyReader == nil {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, m.BodyReader); err != nil {
+		return err
+	}
+	m.BodyReader = nil
+	m.bodyDiscarded = m.Body == nil
+	return nil
+}
+
+// bodyReader 包装报文主体的读取器，记录已经读取的字节数和是否读到了结尾
+type bodyReader struct {
+	r   io.Reader
+	n   int64
+	eof bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Decompress 方法根据 Content-Encoding 头部字段透明地解码 gzip 或 deflate 压缩的报文主体：
+// 解码成功后 Body 为解码后的内容，删除 Content-Encoding 并更新 Content-Length，将 Uncompressed 设为 true
+// maxSize 限制解码后的长度，防止恶意的压缩炸弹，为0或负数时不限制；没有压缩或编码不支持时不做任何事
+func (m *Context) Decompress(maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(m.Header("Content-Encoding")))
+	if encoding != "gzip" && encoding != "deflate" {
+		return nil
+	}
+
+	body, err := m.ReadBody()
+	if err != nil {
+		return err
+	}
+
+	var zr io.ReadCloser
+	if encoding == "gzip" {
+		zr, err = gzip.NewReader(bytes.NewReader(body))
+	} else { // HTTP 的 deflate 编码是 zlib 格式（RFC 9110 8.4.1.2），不是裸的 DEFLATE 数据
+		zr, err = zlib.NewReader(bytes.NewReader(body))
+	}
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var r io.Reader = zr
+	if maxSize > 0 { // 一旦解码的长度超过限制就停止，不会先完整解码
+		r = &maxBytesReader{r: zr, remaining: maxSize}
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.Body = decoded
+	m.DelHeader("Content-Encoding")
+	m.SetHeader("Content-Length", strconv.Itoa(len(decoded)))
+	m.Uncompressed = true
+	return nil
+}
+
+// maxBytesReader 计算已经读取的字节数，超过限制时立即返回 ErrDecompressedTooLarge
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > m.remaining+1 { // 最多多读一个字节，用于判断是否超过限制
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	if int64(n) > m.remaining {
+		n = int(m.remaining)
+		m.remaining = 0
+		return n, ErrDecompressedTooLarge
+	}
+	m.remaining -= int64(n)
+	return n, err
 }
 
 // Print 函数用于打印 Context 实例的各个部分，方便调试：
@@ -77,69 +551,178 @@ func (m *Context) Print() {
 }
 
 // ReadFormData 函数用于从报文主体 Body 中读取 form-data，并返回一个 map 类型的结果。它接受一个 Context 类型的参数：
+// 任何一个部分格式错误都会返回错误，需要尽量读取其他部分时使用 ReadFormDataLenient。
+// 同名字段只保留最后一个，文件字段的值是文件名加上 CRLF 和原样的文件内容；需要分开文件名和内容或者同名字段时使用 ReadMultipart。
+// HTML 表单默认使用的 application/x-www-form-urlencoded 主体同样被解析到这个 map 中，名称和值经过 URL 解码（"+" 表示空格）
 func (m *Context) ReadFormData() (map[string]string, error) {
+	result, partErrs, err := m.readFormData(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(partErrs) > 0 {
+		return nil, partErrs[0].Err
+	}
+	return result, nil // 返回结果
+}
+
+// PartError 描述 form-data 中一个无法解析的部分
+type PartError struct {
+	Index int    // 部分在报文主体中的序号，从0开始
+	Name  string // 部分的名称，无法解析出名称时为空
+	Err   error  // 解析失败的原因
+}
+
+// Error 实现 error 接口
+func (e PartError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("form-data part %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("form-data part %d (%s): %v", e.Index, e.Name, e.Err)
+}
+
+// ReadFormDataLenient 函数与 ReadFormData 相同，但会跳过格式错误的部分，返回成功解析的字段和每个失败部分的错误：
+// 只有在报文主体本身无法读取或内容类型不是 form-data 时，fields 才为 nil
+func (m *Context) ReadFormDataLenient() (fields map[string]string, errs []PartError) {
+	fields, errs, err := m.readFormData(true)
+	if err != nil {
+		return nil, []PartError{{Index: -1, Err: err}}
+	}
+	return fields, errs
+}
+
+// readFormData 解析 form-data，lenient 为 false 时遇到第一个错误的部分就停止
+func (m *Context) readFormData(lenient bool) (map[string]string, []PartError, error) {
 	result := make(map[string]string) // 创建一个空的 map，用于存储结果
+	var partErrs []PartError
 
 	// 获取内容类型（Content-Type）
-	contentType, ok := m.Headers["Content-Type"]
+	contentType, ok := m.LookupHeader("Content-Type")
 	if !ok {
-		return nil, errors.New("no content type")
+		return nil, nil, errors.New("no content type")
 	}
 
-	// 解析出边界（boundary）的值
-	parts := strings.Split(contentType, "boundary=")
-	if len(parts) != 2 {
-		return nil, errors.New("invalid content type")
+	// 解析出边界（boundary）的值，boundary 可以带引号，后面也可以有其他参数
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == "application/x-www-form-urlencoded" {
+		body, err := m.ReadBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		return parseURLEncoded(body, lenient)
 	}
-	boundary := parts[1]
+	if err != nil || params["boundary"] == "" {
+		return nil, nil, errors.New("invalid content type")
+	}
+	boundary := params["boundary"]
 
-	// 将报文主体 Body 转换为一个字节切片
-	body := m.Body
+	// 读取报文主体 Body
+	body, err := m.ReadBody()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// 使用边界（boundary）作为分隔符，将报文主体 Body 分割成多个字节切片
 	// 在原boundary前加“--”即为分界线
 	// 原boundary前后各加“--”即为结尾分界线
 	form := bytes.Split(body, []byte("--"+boundary))
 
-	// fmt.Printf("form: %s\n", form)
-
 	// 遍历每个字节切片
-	for key, part := range form {
+	for index, part := range form {
 		// 遇到结尾分界线就不读了
-		if key >= len(form)-1 {
+		if index >= len(form)-1 {
 			break
 		}
-		// 去掉前后的回车换行符（CRLF）
-		part = bytes.TrimSpace(part)
-
-		// 如果是空字节切片，跳过
-		if len(part) == 0 {
+		// 如果只有空白，跳过
+		if len(bytes.TrimSpace(part)) == 0 {
 			continue
 		}
 
-		// 使用回车换行符（CRLF）作为分隔符，将字节切片分割成两个字节切片
-		subparts := bytes.SplitN(part, []byte("\r\n"), 2)
-		if len(subparts) != 2 {
-			return nil, errors.New("invalid part format")
-		}
-
-		// 第一个字节切片是头部字段（header），第二个字节切片是值
-		// 第二关切片的开头是"\r\n"，用[2:]将其切掉
-		header := subparts[0]
-		val := subparts[1][2:]
+		// 只去掉分界线之后和下一个分界线之前的换行符，值本身的空白和二进制内容原样保留
+		part = trimLineBreak(part)
 
-		// 解析头部字段（header），获取名称和值
-		key, value, err := parseHeader(header, val)
+		key, value, err := parsePart(part)
 		if err != nil {
-			return nil, err
+			partErrs = append(partErrs, PartError{Index: index - 1, Name: key, Err: err})
+			if !lenient {
+				break
+			}
+			continue
 		}
 
 		// 将名称和值存储在 map 中
 		result[key] = value
 	}
 
-	return result, nil // 返回结果
+	return result, partErrs, nil
+}
+
+// parsePart 函数用于解析 form-data 中的一个部分，获取名称和值：
+func parsePart(part []byte) (string, string, error) {
+	// 去掉头部之前的空白，头部和值之间以空行分隔，优先使用 CRLF 形式，也接受只用换行符（LF）的形式
+	part = bytes.TrimLeft(part, " \t\r\n")
+	headerBlock, val, ok := bytes.Cut(part, []byte("\r\n\r\n"))
+	if lfHeader, lfVal, lfOK := bytes.Cut(part, []byte("\n\n")); lfOK && (!ok || len(lfHeader) < len(headerBlock)) {
+		headerBlock, val, ok = lfHeader, lfVal, true
+	}
+	if !ok {
+		return "", "", errors.New("invalid part format")
+	}
+
+	// 一个部分可以有多个头部，例如 Content-Type，名称在 Content-Disposition 头部中
+	var header []byte
+	for _, line := range bytes.Split(headerBlock, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if header == nil || bytes.HasPrefix(bytes.ToLower(line), []byte("content-disposition:")) {
+			header = line
+		}
+	}
+
+	// 解析头部字段（header），获取名称和值
+	return parseHeader(header, val)
+}
+
+// parseURLEncoded 函数用于解析 key=value&key2=value2 形式的主体，名称和值中的 "+" 解码为空格，"%XX" 解码为对应的字节：
+// 同名字段只保留最后一个，无法解码的字段作为 PartError 返回，Index 是字段的序号，lenient 为 false 时遇到第一个错误就停止
+func parseURLEncoded(body []byte, lenient bool) (map[string]string, []PartError, error) {
+	result := make(map[string]string)
+	var partErrs []PartError
+	for index, pair := range strings.Split(string(body), "&") {
+		if pair == "" { // 连续或者结尾的 "&"
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err == nil {
+			var value string
+			if value, err = url.QueryUnescape(rawValue); err == nil {
+				result[key] = value
+				continue
+			}
+		}
+		partErrs = append(partErrs, PartError{Index: index, Name: key, Err: err})
+		if !lenient {
+			break
+		}
+	}
+	return result, partErrs, nil
+}
 
+// trimLineBreak 去掉 part 开头的一个换行符和结尾的一个换行符（CRLF 或 LF）
+func trimLineBreak(part []byte) []byte {
+	if bytes.HasPrefix(part, []byte("\r\n")) {
+		part = part[2:]
+	} else if bytes.HasPrefix(part, []byte("\n")) {
+		part = part[1:]
+	}
+	if bytes.HasSuffix(part, []byte("\r\n")) {
+		part = part[:len(part)-2]
+	} else if bytes.HasSuffix(part, []byte("\n")) {
+		part = part[:len(part)-1]
+	}
+	return part
 }
 
 // parseHeader 函数用于解析头部字段（header），获取名称和值：
@@ -181,10 +764,9 @@ func parseHeader(header []byte, value []byte) (string, string, error) {
 		return "", "", errors.New("no name found")
 	}
 
-	// 如果有文件名，将文件名作为值的一部分，并去掉前后的回车换行符（CRLF）
+	// 如果有文件名，将文件名作为值的一部分，文件内容原样保留
 	if filename != "" {
 		value = append([]byte(filename+"\r\n"), value...)
-		value = bytes.TrimSpace(value)
 	}
 
 	return name, string(value), nil // 返回名称和值
@@ -0,0 +1,484 @@
+package context
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newStreamingContext 解析 request 的起始行和头部，主体和服务器一样通过 BodyReader 流式读取
+func newStreamingContext(t *testing.T, request string) *Context {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(request))
+	m, err := ReadContext(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, err := m.ContentLength()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetBodyReader(io.LimitReader(r, length))
+	return m
+}
+
+func TestReadBodyAfterPartialRead(t *testing.T) {
+	m := newStreamingContext(t, "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(m.BodyReader, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !m.BodyPartiallyRead() {
+		t.Fatal("BodyPartiallyRead() = false after reading 4 of 10 bytes")
+	}
+	if _, err := m.ReadBody(); err != ErrBodyPartiallyRead {
+		t.Fatalf("ReadBody() err = %v, want ErrBodyPartiallyRead", err)
+	}
+
+	// 读完之后主体不再是读取了一部分的状态
+	if _, err := io.ReadAll(m.BodyReader); err != nil {
+		t.Fatal(err)
+	}
+	if m.BodyPartiallyRead() {
+		t.Fatal("BodyPartiallyRead() = true after reading to EOF")
+	}
+}
+
+func TestReadBodyAfterDiscard(t *testing.T) {
+	m := newStreamingContext(t, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	if err := m.DiscardBody(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadBody(); err != ErrBodyDiscarded {
+		t.Fatalf("ReadBody() err = %v, want ErrBodyDiscarded", err)
+	}
+	if _, err := m.ParseMultipartForm(0, ""); err != ErrBodyDiscarded && err != ErrNotMultipart {
+		t.Fatalf("ParseMultipartForm() err = %v", err)
+	}
+}
+
+func TestReadBodyTwice(t *testing.T) {
+	m := newStreamingContext(t, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	for i := 0; i < 2; i++ {
+		body, err := m.ReadBody()
+		if err != nil || string(body) != "hello" {
+			t.Fatalf("ReadBody() #%d = %q, %v", i+1, body, err)
+		}
+	}
+	// 已经完整读取的主体被保留，DiscardBody 之后仍然可以读取
+	if err := m.DiscardBody(); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := m.ReadBody(); err != nil || string(body) != "hello" {
+		t.Fatalf("ReadBody() after DiscardBody = %q, %v", body, err)
+	}
+}
+
+// dripReader 每次 Read 最多返回 n 个字节，模拟请求被拆成很多个TCP段到达
+type dripReader struct {
+	r io.Reader
+	n int
+}
+
+func (d *dripReader) Read(p []byte) (int, error) {
+	if len(p) > d.n {
+		p = p[:d.n]
+	}
+	return d.r.Read(p)
+}
+
+func TestReadContextFromSplitReads(t *testing.T) {
+	cookie := strings.Repeat("c", 10000) // 比 bufio.Reader 默认的缓冲区还长
+	body := strings.Repeat("b", 3000)
+	request := "POST /upload?x=1 HTTP/1.1\r\nHost: example.com\r\nCookie: " + cookie + "\r\nContent-Length: 3000\r\n\r\n" + body +
+		"GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n" // 紧跟在后面的下一个请求
+
+	for _, n := range []int{1, 7, 4096} {
+		r := bufio.NewReader(&dripReader{strings.NewReader(request), n})
+		m, err := ReadContext(r)
+		if err != nil {
+			t.Fatalf("%d-byte reads: %v", n, err)
+		}
+		if m.Method() != "POST" || m.Path() != "/upload" || m.Header("Cookie") != cookie {
+			t.Fatalf("%d-byte reads: parsed %q, Cookie of %d bytes", n, m.StartLine, len(m.Header("Cookie")))
+		}
+		length, err := m.ContentLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.SetBodyReader(io.LimitReader(r, length))
+		if got, err := m.ReadBody(); err != nil || string(got) != body {
+			t.Fatalf("%d-byte reads: body of %d bytes, %v", n, len(got), err)
+		}
+
+		// 主体之后缓冲的字节属于下一个请求，不能丢失
+		next, err := ReadContext(r)
+		if err != nil || next.Path() != "/next" {
+			t.Fatalf("%d-byte reads: next request = %v, %v", n, next, err)
+		}
+	}
+}
+
+// compress 用 encoding 对应的格式压缩 data
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser = gzip.NewWriter(&buf)
+	if encoding == "deflate" {
+		w = zlib.NewWriter(&buf)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressRoundTrip(t *testing.T) {
+	original := []byte(strings.Repeat("hello, compressed world! ", 100))
+	for _, encoding := range []string{"gzip", "deflate", "GZIP"} {
+		body := compress(t, strings.ToLower(encoding), original)
+		m := newStreamingContext(t, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s", encoding, len(body), body))
+		if err := m.Decompress(0); err != nil {
+			t.Fatalf("%s: Decompress() = %v", encoding, err)
+		}
+		if !bytes.Equal(m.Body, original) || !m.Uncompressed || m.Header("Content-Encoding") != "" || m.Header("Content-Length") != strconv.Itoa(len(original)) {
+			t.Fatalf("%s: body of %d bytes, Uncompressed=%v, headers %v", encoding, len(m.Body), m.Uncompressed, m.Headers)
+		}
+
+		m = newStreamingContext(t, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s", encoding, len(body), body))
+		if err := m.Decompress(100); err != ErrDecompressedTooLarge {
+			t.Fatalf("%s: Decompress(100) = %v, want ErrDecompressedTooLarge", encoding, err)
+		}
+	}
+}
+
+func TestReadContextBareLF(t *testing.T) {
+	for name, request := range map[string]string{
+		"LF":    "POST /submit?a=1 HTTP/1.1\nHost: example.com\nX-Value:  padded \nContent-Length: 4\n\nbody",
+		"mixed": "POST /submit?a=1 HTTP/1.1\r\nHost: example.com\nX-Value:  padded \r\nContent-Length: 4\n\r\nbody",
+	} {
+		r := bufio.NewReader(strings.NewReader(request))
+		m, err := ReadContext(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if m.StartLine != "POST /submit?a=1 HTTP/1.1" || m.Path() != "/submit" || m.Header("Host") != "example.com" || m.Header("X-Value") != "padded" {
+			t.Fatalf("%s: parsed %q with headers %q", name, m.StartLine, m.Headers)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "body" { // 空行之后就是主体，没有多读或少读
+			t.Fatalf("%s: body = %q", name, rest)
+		}
+	}
+
+	if _, err := ReadContext(bufio.NewReader(strings.NewReader("\nHost: x\n\n"))); err == nil {
+		t.Fatal("empty start line accepted")
+	}
+}
+
+func TestReadContextLimitTotalBoundary(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("v", 100) + "\r\n\r\n"
+	for _, tt := range []struct {
+		maxBytes int
+		err      error
+	}{
+		{len(request), nil}, // 限制包括每一行的换行符和结束的空行
+		{len(request) - 1, ErrHeaderTooLarge},
+		{len(request) + 1, nil},
+	} {
+		// 缓冲区比头部行短，行被读入可以增长的缓冲区
+		r := bufio.NewReaderSize(strings.NewReader(request), 16)
+		m, err := ReadContextLimit(r, tt.maxBytes, 0)
+		if err != tt.err {
+			t.Fatalf("maxBytes %d: err = %v, want %v", tt.maxBytes, err, tt.err)
+		}
+		if err == nil && m.Header("X-Long") != strings.Repeat("v", 100) {
+			t.Fatalf("maxBytes %d: X-Long of %d bytes", tt.maxBytes, len(m.Header("X-Long")))
+		}
+	}
+}
+
+func TestReadContextLimitLineBoundary(t *testing.T) {
+	line := "X-Long: " + strings.Repeat("v", 50) + "\r\n"
+	request := "GET / HTTP/1.1\r\n" + line + "\r\n"
+	for _, tt := range []struct {
+		maxLineBytes int
+		err          error
+	}{
+		{len(line), nil}, // 每一行的长度包括换行符
+		{len(line) - 1, ErrHeaderTooLarge},
+	} {
+		r := bufio.NewReaderSize(strings.NewReader(request), 16)
+		if _, err := ReadContextLimit(r, 0, tt.maxLineBytes); err != tt.err {
+			t.Fatalf("maxLineBytes %d: err = %v, want %v", tt.maxLineBytes, err, tt.err)
+		}
+	}
+
+	// 请求行同样受限制
+	if _, err := ReadContextLimit(bufio.NewReader(strings.NewReader("GET /"+strings.Repeat("a", 100)+" HTTP/1.1\r\n\r\n")), 0, 64); err != ErrHeaderTooLarge {
+		t.Fatalf("long request line: err = %v, want ErrHeaderTooLarge", err)
+	}
+}
+
+func TestParsePartTerminators(t *testing.T) {
+	for name, part := range map[string]string{
+		"CRLF":               "Content-Disposition: form-data; name=\"field\"\r\n\r\nvalue\nwith\r\n\r\nbreaks",
+		"LF":                 "Content-Disposition: form-data; name=\"field\"\n\nvalue\nwith\r\n\r\nbreaks",
+		"CRLF with LF value": "Content-Disposition: form-data; name=\"field\"\r\nContent-Type: text/plain\r\n\r\nvalue\nwith\r\n\r\nbreaks",
+		"LF extra header":    "Content-Type: text/plain\nContent-Disposition: form-data; name=\"field\"\n\nvalue\nwith\r\n\r\nbreaks",
+	} {
+		// 值中的空行不会被当作头部的结束
+		key, value, err := parsePart([]byte(part))
+		if err != nil || key != "field" || value != "value\nwith\r\n\r\nbreaks" {
+			t.Errorf("%s: parsePart() = %q, %q, %v", name, key, value, err)
+		}
+	}
+	if _, _, err := parsePart([]byte("Content-Disposition: form-data; name=\"field\"\r\nvalue")); err == nil {
+		t.Error("part without a blank line accepted")
+	}
+}
+
+func TestReadFormDataTerminators(t *testing.T) {
+	for name, eol := range map[string]string{"CRLF": "\r\n", "LF": "\n"} {
+		body := "--b" + eol +
+			"Content-Disposition: form-data; name=\"a\"" + eol + eol + "1" + eol +
+			"--b" + eol +
+			"Content-Disposition: form-data; name=\"b\"" + eol + eol + "two" + eol +
+			"--b--" + eol
+		m := newStreamingContext(t, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+		fields, err := m.ReadFormData()
+		if err != nil || fields["a"] != "1" || fields["b"] != "two" {
+			t.Errorf("%s: ReadFormData() = %q, %v", name, fields, err)
+		}
+	}
+}
+
+func TestReadContextHeaderTerminators(t *testing.T) {
+	for name, terminator := range map[string]string{"CRLF": "\r\n\r\n", "LF": "\n\n", "mixed": "\r\n\n"} {
+		r := bufio.NewReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 4" + terminator + "body"))
+		m, err := ReadContext(r)
+		if err != nil || m.Header("Content-Length") != "4" {
+			t.Fatalf("%s: ReadContext() = %v, %v", name, m, err)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "body" {
+			t.Fatalf("%s: body = %q", name, rest)
+		}
+	}
+}
+
+func TestTeeBody(t *testing.T) {
+	body := strings.Repeat("0123456789", 1000)
+	request := "POST / HTTP/1.1\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	// 处理器分多次读取，sink 收到的字节和处理器读到的完全相同
+	m := newStreamingContext(t, request)
+	var sink bytes.Buffer
+	if err := m.TeeBody(&sink); err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.ReadAll(&dripReader{m.BodyReader, 7})
+	if err != nil || string(read) != body || sink.String() != body {
+		t.Fatalf("handler read %d bytes (%v), sink got %d bytes", len(read), err, sink.Len())
+	}
+
+	// 已经完整读取到 Body 中的主体直接写入 sink
+	m = newStreamingContext(t, request)
+	if _, err := m.ReadBody(); err != nil {
+		t.Fatal(err)
+	}
+	sink.Reset()
+	if err := m.TeeBody(&sink); err != nil || sink.String() != body {
+		t.Fatalf("TeeBody() after ReadBody = %v, sink got %d bytes", err, sink.Len())
+	}
+
+	// 主体已经被读取了一部分时不能再复制
+	m = newStreamingContext(t, request)
+	m.BodyReader.Read(make([]byte, 10))
+	if err := m.TeeBody(&sink); err != ErrBodyPartiallyRead {
+		t.Fatalf("TeeBody() after partial read = %v, want %v", err, ErrBodyPartiallyRead)
+	}
+}
+
+// failingWriter 的每次写入都失败
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestTeeBodyWriteError(t *testing.T) {
+	m := newStreamingContext(t, "POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\nbody")
+	if err := m.TeeBody(failingWriter{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadBody(); err != io.ErrClosedPipe {
+		t.Fatalf("ReadBody() = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	// 手工构造的分块主体：带有块扩展、十六进制大小和尾部字段，之后紧跟着下一个报文
+	raw := "5;name=value\r\nhello\r\n19\r\n, this chunk is 25 bytes!\r\n0\r\nChecksum: abc\r\n\r\nGET /next HTTP/1.1\r\n"
+	r := bufio.NewReader(&dripReader{strings.NewReader(raw), 3})
+	trailers := make(map[string]string)
+	body, err := io.ReadAll(NewChunkedReader(r, trailers))
+	if err != nil || string(body) != "hello, this chunk is 25 bytes!" {
+		t.Fatalf("body = %q, %v", body, err)
+	}
+	if trailers["Checksum"] != "abc" {
+		t.Fatalf("trailers = %v", trailers)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "GET /next HTTP/1.1\r\n" {
+		t.Fatalf("left in the reader: %q", rest)
+	}
+
+	for _, bad := range []string{"zz\r\nhello\r\n0\r\n\r\n", "5\r\nhelloX\r\n0\r\n\r\n", "0\r\nno colon\r\n\r\n"} {
+		if _, err := io.ReadAll(NewChunkedReader(bufio.NewReader(strings.NewReader(bad)), nil)); err != ErrInvalidChunk {
+			t.Errorf("%q: err = %v, want %v", bad, err, ErrInvalidChunk)
+		}
+	}
+}
+
+// multipartRequest 返回一个包含 fields 中普通字段和 files 中文件（按照给定的顺序）的 multipart/form-data 请求
+func multipartRequest(t *testing.T, fields map[string]string, files [][2]string) *Context {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	for _, file := range files {
+		w, _ := mw.CreateFormFile("upload", file[0])
+		io.WriteString(w, file[1])
+	}
+	mw.Close()
+	return newStreamingContext(t, "POST / HTTP/1.1\r\nContent-Type: "+mw.FormDataContentType()+"\r\nContent-Length: "+strconv.Itoa(body.Len())+"\r\n\r\n"+body.String())
+}
+
+func TestParseMultipartFormSpill(t *testing.T) {
+	dir := t.TempDir()
+	small, large := "small file", strings.Repeat("large file ", 100)
+	// 内存额度在文件之间共享：第一个小文件保存在内存中，第二个小文件和大文件超出了剩余的额度
+	m := multipartRequest(t, map[string]string{"title": "report"}, [][2]string{{"a.txt", small}, {"b.txt", small}, {"c.txt", large}})
+	form, err := m.ParseMultipartForm(int64(len(small)+5), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Values["title"][0] != "report" {
+		t.Fatalf("Values = %v", form.Values)
+	}
+
+	files := form.Files["upload"]
+	if len(files) != 3 {
+		t.Fatalf("got %d files", len(files))
+	}
+	for i, tt := range []struct {
+		content string
+		spilled bool
+	}{{small, false}, {small, true}, {large, true}} {
+		fh := files[i]
+		if spilled := fh.tmpfile != ""; spilled != tt.spilled || fh.Size != int64(len(tt.content)) {
+			t.Errorf("%s: spilled = %v, size = %d, want %v, %d", fh.Filename, spilled, fh.Size, tt.spilled, len(tt.content))
+		}
+		if tt.spilled && filepath.Dir(fh.tmpfile) != dir {
+			t.Errorf("%s: temp file %s not in %s", fh.Filename, fh.tmpfile, dir)
+		}
+		r, err := fh.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		if string(content) != tt.content {
+			t.Errorf("%s: content = %q", fh.Filename, content)
+		}
+	}
+
+	// 请求结束时临时文件被删除
+	spilled := files[2].tmpfile
+	if err := m.RemoveTempFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spilled); !os.IsNotExist(err) {
+		t.Fatalf("temp file still exists: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("temp dir not empty: %v", entries)
+	}
+}
+
+func TestParseMultipartFormInMemory(t *testing.T) {
+	dir := t.TempDir()
+	m := multipartRequest(t, nil, [][2]string{{"a.txt", "one"}, {"b.txt", "two"}})
+	form, err := m.ParseMultipartForm(0, dir) // 使用 DefaultMaxMemory
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fh := range form.Files["upload"] {
+		if fh.tmpfile != "" {
+			t.Errorf("%s spilled to disk", fh.Filename)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("temp files written: %v", entries)
+	}
+	if again, _ := m.ParseMultipartForm(0, dir); again != form { // 结果被缓存
+		t.Fatal("second ParseMultipartForm() parsed the body again")
+	}
+}
+
+func TestParseMultipartFormValuesTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	// 普通字段不会被写入临时文件，超过 maxMemory+10MB 时返回错误
+	m := multipartRequest(t, map[string]string{"big": strings.Repeat("v", maxValueBytes+11)}, nil)
+	if _, err := m.ParseMultipartForm(10, dir); err != ErrMultipartValuesTooLarge {
+		t.Fatalf("ParseMultipartForm() = %v, want %v", err, ErrMultipartValuesTooLarge)
+	}
+}
+
+func TestWalkMultipartOrder(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("b", "1")
+	w, _ := mw.CreateFormFile("file", "first.txt")
+	io.WriteString(w, strings.Repeat("f", 5000))
+	mw.WriteField("a", "2")
+	mw.WriteField("b", "3") // 同名字段之间隔着其他字段，顺序仍然保留
+	w, _ = mw.CreateFormFile("file", "second.txt")
+	io.WriteString(w, "second")
+	mw.Close()
+	request := "POST / HTTP/1.1\r\nContent-Type: " + mw.FormDataContentType() + "\r\nContent-Length: " + strconv.Itoa(body.Len()) + "\r\n\r\n" + body.String()
+
+	var got []string
+	m := newStreamingContext(t, request)
+	err := m.WalkMultipart(func(part *Part) error {
+		if part.Filename == "first.txt" { // 只读一部分，剩下的在下一个部分之前被丢弃
+			buf := make([]byte, 3)
+			io.ReadFull(part, buf)
+			got = append(got, part.Name+"/"+part.Filename+"="+string(buf))
+			return nil
+		}
+		content, err := io.ReadAll(part)
+		got = append(got, part.Name+"/"+part.Filename+"="+string(content))
+		return err
+	})
+	want := "b/=1 file/first.txt=fff a/=2 b/=3 file/second.txt=second"
+	if err != nil || strings.Join(got, " ") != want {
+		t.Fatalf("WalkMultipart() = %q, %v, want %q", strings.Join(got, " "), err, want)
+	}
+
+	// fn 返回错误时停止遍历
+	stop := fmt.Errorf("stop")
+	calls := 0
+	m = newStreamingContext(t, request)
+	if err := m.WalkMultipart(func(part *Part) error { calls++; return stop }); err != stop || calls != 1 {
+		t.Fatalf("WalkMultipart() = %v after %d calls, want %v after 1", err, calls, stop)
+	}
+}
@@ -0,0 +1,239 @@
+package context
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+)
+
+// DefaultMaxMemory 是 ParseMultipartForm 的 maxMemory 为0或负数时使用的内存上限
+const DefaultMaxMemory = 32 << 20
+
+// maxValueBytes 是普通字段在 maxMemory 之外额外允许占用的内存，普通字段不会被写入临时文件
+const maxValueBytes = 10 << 20
+
+var (
+	// ErrNotMultipart 表示请求的内容类型不是 multipart/form-data 或者缺少 boundary
+	ErrNotMultipart = errors.New("content type is not multipart/form-data")
+
+	// ErrMultipartValuesTooLarge 表示 multipart/form-data 中普通字段的总长度超过了限制
+	ErrMultipartValuesTooLarge = errors.New("multipart form values too large")
+)
+
+// MultipartForm 是 ParseMultipartForm 解析出的表单，Values 是普通字段，Files 是文件字段，同名字段按照出现的顺序排列
+type MultipartForm struct {
+	Values map[string][]string
+	Files  map[string][]*FileHeader
+}
+
+// FileHeader 描述表单中的一个文件，小文件的内容保存在内存中，大文件被写入临时文件，两者都通过 Open 读取
+type FileHeader struct {
+	Filename string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	content []byte // 保存在内存中的内容
+	tmpfile string // 临时文件的路径，内容在内存中时为空
+}
+
+// Open 返回文件内容的读取器，使用之后需要关闭它
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll 删除表单中所有文件的临时文件
+func (f *MultipartForm) RemoveAll() error {
+	var err error
+	for _, files := range f.Files {
+		for _, fh := range files {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if e := os.Remove(fh.tmpfile); e != nil && !errors.Is(e, os.ErrNotExist) && err == nil {
+				err = e
+			}
+			fh.tmpfile = ""
+		}
+	}
+	return err
+}
+
+// ParseMultipartForm 方法以流的形式解析 multipart/form-data 报文主体，不会把整个主体读入内存：
+// 文件的内容一共最多占用 maxMemory 个字节的内存（为0或负数时使用 DefaultMaxMemory），超出的文件被写入 tempDir 中的临时文件
+// （为空时使用 os.TempDir()）；普通字段总是保存在内存中，总长度最多比 maxMemory 多 10MB，超过时返回 ErrMultipartValuesTooLarge。
+// 结果会被缓存，再次调用直接返回同一个表单。临时文件在请求处理结束时由服务器调用 RemoveTempFiles 删除
+func (m *Context) ParseMultipartForm(maxMemory int64, tempDir string) (*MultipartForm, error) {
+	if m.multipartForm != nil {
+		return m.multipartForm, nil
+	}
+	reader, err := m.multipartReader()
+	if err != nil {
+		return nil, err
+	}
+	if maxMemory <= 0 {
+		maxMemory = DefaultMaxMemory
+	}
+
+	form := &MultipartForm{Values: make(map[string][]string), Files: make(map[string][]*FileHeader)}
+	m.multipartForm = form // 出错时已经写入的临时文件同样会被 RemoveTempFiles 删除
+	fileMemory, valueMemory := maxMemory, maxMemory+maxValueBytes
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, m.abortMultipart(err)
+		}
+
+		name := part.FormName()
+		if name == "" { // 没有名称的部分不属于表单
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" { // 普通字段
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, part, valueMemory+1)
+			if err != nil && err != io.EOF {
+				return nil, m.abortMultipart(err)
+			}
+			if n > valueMemory {
+				return nil, m.abortMultipart(ErrMultipartValuesTooLarge)
+			}
+			valueMemory -= n
+			form.Values[name] = append(form.Values[name], buf.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: part.FileName(), Header: part.Header}
+		form.Files[name] = append(form.Files[name], fh)
+		if err := fh.read(part, &fileMemory, tempDir); err != nil {
+			return nil, m.abortMultipart(err)
+		}
+	}
+	return form, nil
+}
+
+// ReadMultipart 方法解析 multipart/form-data 报文主体，普通字段和文件字段分别保存在 Values 和 Files 中，
+// 文件的内容原样保留，可以通过 FileHeader.Open 读取。它等同于使用默认参数调用 ParseMultipartForm：
+// 文件一共最多占用 DefaultMaxMemory 个字节的内存，超出的部分写入系统临时目录，请求处理结束时被删除。
+// boundary 可以带引号，没有 Content-Type 头部的部分按照普通字段或文件处理
+func (m *Context) ReadMultipart() (*MultipartForm, error) {
+	return m.ParseMultipartForm(DefaultMaxMemory, "")
+}
+
+// Part 是 WalkMultipart 交给回调函数的一个部分，Read 方法流式地读取它的内容
+type Part struct {
+	Name     string               // Content-Disposition 中的字段名称，可能为空
+	Filename string               // 文件字段的文件名，普通字段为空
+	Header   textproto.MIMEHeader // 这个部分的头部
+
+	part *multipart.Part
+}
+
+// Read 读取这个部分的内容
+func (p *Part) Read(b []byte) (int, error) {
+	return p.part.Read(b)
+}
+
+// WalkMultipart 方法按照报文中的顺序对 multipart/form-data 主体的每一个部分调用 fn，不会缓存任何内容：
+// fn 可以自己决定把内容读入内存、写入文件或者跳过，没有读完的内容会在处理下一个部分之前被丢弃，
+// Part 只在 fn 返回之前有效。fn 返回错误时停止遍历并返回这个错误。
+// 和 ParseMultipartForm 不同，它保留了字段之间的顺序，也不会保存结果，主体只能被遍历一次
+func (m *Context) WalkMultipart(fn func(part *Part) error) error {
+	reader, err := m.multipartReader()
+	if err != nil {
+		return err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(&Part{Name: part.FormName(), Filename: part.FileName(), Header: part.Header, part: part})
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// multipartReader 检查内容类型并返回以流的形式读取 multipart/form-data 主体的读取器
+func (m *Context) multipartReader() (*multipart.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(m.Header("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, ErrNotMultipart
+	}
+	body, err := m.bodyStream()
+	if err != nil {
+		return nil, err
+	}
+	return multipart.NewReader(body, params["boundary"]), nil
+}
+
+// read 读取文件的内容，没有超过剩余的内存额度 memory 时保存在内存中，否则和之后的内容一起写入临时文件
+func (fh *FileHeader) read(part io.Reader, memory *int64, tempDir string) error {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, *memory+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n <= *memory {
+		*memory -= n
+		fh.content, fh.Size = buf.Bytes(), n
+		return nil
+	}
+
+	file, err := os.CreateTemp(tempDir, "multipart-")
+	if err != nil {
+		return err
+	}
+	fh.tmpfile = file.Name()
+	size, err := io.Copy(file, io.MultiReader(&buf, part))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	fh.Size = size
+	return err
+}
+
+// abortMultipart 在解析出错时删除已经写入的临时文件并丢弃不完整的表单，返回 err
+func (m *Context) abortMultipart(err error) error {
+	m.RemoveTempFiles()
+	m.multipartForm = nil
+	return err
+}
+
+// RemoveTempFiles 方法删除 ParseMultipartForm 写入的临时文件，服务器在每个请求处理结束时调用它
+func (m *Context) RemoveTempFiles() error {
+	if m.multipartForm == nil {
+		return nil
+	}
+	return m.multipartForm.RemoveAll()
+}
+
+// bodyStream 返回读取报文主体的读取器：主体已经被完整读取时从 Body 读取，否则直接从 BodyReader 流式读取
+func (m *Context) bodyStream() (io.Reader, error) {
+	if m.bodyDiscarded {
+		return nil, ErrBodyDiscarded
+	}
+	if m.Body != nil || m.BodyReader == nil {
+		return bytes.NewReader(m.Body), nil
+	}
+	if m.BodyPartiallyRead() {
+		return nil, ErrBodyPartiallyRead
+	}
+	return m.BodyReader, nil
+}
@@ -61,8 +61,8 @@ func helloMiddleware(next router.HandlerFunc) router.HandlerFunc {
 			name = "World"
 		}
 
-		c.WriteResponse(200, "OK", []byte(fmt.Sprintf("Hello, %s!", name)),
-			map[string]string{"set-cookie": fmt.Sprintf("name=%v; Max-Age=3600; Domain=localhost;Secure; Path=/; Version=1", name)})
+		c.SetCookie("name", fmt.Sprint(name), server.CookieOptions{Path: "/", Domain: "localhost", MaxAge: 3600, Secure: true, SameSite: server.SameSiteLax})
+		c.WriteResponse(200, "OK", []byte(fmt.Sprintf("Hello, %s!", name)))
 		next(c)
 	}
 }
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+)
+
+// AfterResponse 返回一个只在响应一侧工作的中间件：之后的处理器结束后调用 fn，参数是写入的状态码和主体长度（没有写入响应时都为0）。
+// fn 通过 defer 调用，所以无论之后的中间件正常返回、没有调用下一个处理器就结束了请求，还是发生了 panic，它都会运行；
+// panic 会在 fn 返回后继续向上传递。把它放在链的最前面可以观察到整个请求的结果，例如记录日志或统计。
+func AfterResponse(fn func(c *server.Conn, status int, size int)) router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			defer func() {
+				fn(&c, c.Status(), c.BytesWritten())
+			}()
+			next(c)
+		}
+	}
+}
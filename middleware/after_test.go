@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"testing"
+)
+
+func TestAfterResponse(t *testing.T) {
+	type result struct{ status, size int }
+	results := make(chan result, 1)
+	after := AfterResponse(func(c *server.Conn, status int, size int) {
+		results <- result{status, size}
+	})
+
+	r := router.NewRouter()
+	r.HandleFunc("GET", "/ok", after, func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			c.WriteResponse(200, "OK", []byte("hello"))
+		}
+	})
+	// 中间件结束了请求，之后的处理器不会被调用
+	r.HandleFunc("GET", "/aborted", after, func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			c.WriteResponse(403, "Forbidden", []byte("no"))
+			c.Abort()
+			next(c)
+		}
+	}, func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			t.Error("handler called after Abort")
+		}
+	})
+	// panic 在 fn 运行之后继续传递给 Recover
+	r.HandleFunc("GET", "/panic", Recover(), after, func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			panic("boom")
+		}
+	})
+	base := startRouter(t, r)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		want   result
+	}{
+		{"/ok", 200, result{200, 5}},
+		{"/aborted", 403, result{403, 2}},
+		{"/panic", 500, result{0, 0}}, // fn 运行时还没有写入响应
+	} {
+		if status, _ := get(t, base+tt.path); status != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.path, status, tt.status)
+		}
+		if got := <-results; got != tt.want {
+			t.Errorf("GET %s: fn got %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
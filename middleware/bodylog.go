@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// BodyLoggerConfig 是 BodyLogger 中间件的配置
+type BodyLoggerConfig struct {
+	// Enabled 必须显式设为 true 才会记录，否则中间件什么也不做，避免在生产环境中意外记录敏感数据
+	Enabled bool
+
+	// MaxBodySize 是记录的请求和响应主体的最大长度，为0时使用4096；更长的请求主体不会被读取，只记录长度
+	MaxBodySize int
+
+	// RedactHeaders 是需要隐藏值的请求头部名称，不区分大小写，为 nil 时隐藏 Authorization、Cookie 和 Proxy-Authorization
+	RedactHeaders []string
+
+	// RedactFields 是需要隐藏值的JSON字段路径，用点分隔嵌套的字段，例如 "password" 或 "user.token"，数组中的每个元素都会被处理
+	RedactFields []string
+
+	// Logger 是写入日志的记录器，为 nil 时使用 log 包的标准记录器
+	Logger *log.Logger
+}
+
+// redacted 是替换敏感值的文本
+const redacted = "***"
+
+// BodyLogger 返回一个记录请求头部、请求主体和响应主体的调试中间件，敏感的头部和JSON字段会被替换为 ***
+// 文本类型的主体按原样记录（超过长度限制时截断），二进制主体只记录前32个字节的十六进制形式
+func BodyLogger(config BodyLoggerConfig) router.Middleware {
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 4096
+	}
+	redactHeaders := make(map[string]bool)
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+	}
+	for _, name := range config.RedactHeaders {
+		redactHeaders[strings.ToLower(name)] = true
+	}
+	printf := log.Printf
+	if config.Logger != nil {
+		printf = config.Logger.Printf
+	}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		if !config.Enabled {
+			return next
+		}
+		return func(c server.Conn) {
+			names := make([]string, 0, len(c.Message.Headers))
+			for name := range c.Message.Headers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			headers := make([]string, 0, len(names))
+			for _, name := range names {
+				value := c.Message.Headers[name]
+				if redactHeaders[strings.ToLower(name)] {
+					value = redacted
+				}
+				headers = append(headers, name+": "+value)
+			}
+
+			var reqBody string
+			length, err := c.Message.ContentLength()
+			switch {
+			case err != nil || length > int64(config.MaxBodySize):
+				reqBody = "[body not read: " + c.Message.Header("Content-Length") + " bytes]"
+			default:
+				body, err := c.Message.ReadBody()
+				if err != nil {
+					reqBody = "[body read error: " + err.Error() + "]"
+				} else {
+					reqBody = formatBody(body, isTextContentType(c.Message.ContentType()), config)
+				}
+			}
+			printf("[DEBUG] request %s\n%s\n\n%s", c.Message.StartLine, strings.Join(headers, "\n"), reqBody)
+
+			c.CaptureResponseBody(config.MaxBodySize + 1)
+			next(c)
+
+			respBody := c.CapturedResponseBody()
+			printf("[DEBUG] response %d (%d bytes)\n%s", c.Status(), c.BytesWritten(), formatBody(respBody, utf8.Valid(respBody), config))
+		}
+	}
+}
+
+// isTextContentType 判断请求的内容类型是否是可以按文本记录的类型
+func isTextContentType(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml") || contentType == "application/x-www-form-urlencoded"
+}
+
+// formatBody 将主体格式化为日志中的文本，隐藏JSON中的敏感字段，截断过长的内容
+func formatBody(body []byte, text bool, config BodyLoggerConfig) string {
+	if len(body) == 0 {
+		return "[empty body]"
+	}
+	if !text {
+		n := len(body)
+		if n > 32 {
+			n = 32
+		}
+		return "[binary " + hex.EncodeToString(body[:n]) + "...]"
+	}
+
+	truncated := len(body) > config.MaxBodySize
+	if truncated {
+		body = body[:config.MaxBodySize]
+	} else if len(config.RedactFields) > 0 {
+		var doc interface{}
+		if json.Unmarshal(body, &doc) == nil {
+			for _, field := range config.RedactFields {
+				redactField(doc, strings.Split(field, "."))
+			}
+			if redactedBody, err := json.Marshal(doc); err == nil {
+				body = redactedBody
+			}
+		}
+	}
+
+	if truncated { // 截断的JSON无法解析，不能确定敏感字段的位置，不记录内容
+		if len(config.RedactFields) > 0 {
+			return "[body truncated and withheld]"
+		}
+		return string(body) + "...[truncated]"
+	}
+	return string(body)
+}
+
+// redactField 将 doc 中路径为 path 的字段替换为 ***
+func redactField(doc interface{}, path []string) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		child, ok := v[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			v[path[0]] = redacted
+			return
+		}
+		redactField(child, path[1:])
+	case []interface{}:
+		for _, item := range v {
+			redactField(item, path)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMethods 是 CORSConfig.AllowedMethods 为空时允许的跨域请求方法
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// CORSConfig 是 CORS 中间件的配置
+type CORSConfig struct {
+	// AllowedOrigins 是允许跨域访问的页面来源，例如 "https://app.example.com"，"*" 表示任意来源，不区分大小写
+	AllowedOrigins []string
+
+	// AllowedMethods 是预检请求允许的方法，为空时使用 DefaultCORSMethods
+	AllowedMethods []string
+
+	// AllowedHeaders 是预检请求允许客户端发送的头部，不区分大小写，"*" 表示任意头部；为空时只允许不需要预检的简单头部
+	AllowedHeaders []string
+
+	// ExposedHeaders 是允许页面中的脚本读取的响应头部，写入实际请求的 Access-Control-Expose-Headers
+	ExposedHeaders []string
+
+	// AllowCredentials 为 true 时允许跨域请求携带 Cookie 和认证信息，这时 Access-Control-Allow-Origin 总是回复具体的来源而不是 "*"
+	AllowCredentials bool
+
+	// MaxAge 是浏览器缓存预检结果的时间，写入 Access-Control-Max-Age（以秒为单位），为0时不写入，由浏览器使用默认值（通常是5秒）
+	MaxAge time.Duration
+}
+
+// CORS 返回一个处理跨域资源共享的中间件。带有 Access-Control-Request-Method 的 OPTIONS 请求是预检请求：
+// 来源、方法和头部都被允许时回复 204，并在 Access-Control-Allow-Methods 和 Access-Control-Allow-Headers 中回显请求的方法和头部，
+// 否则回复 403，两种情况都不会调用之后的处理器。其他带有允许的 Origin 的请求在调用之后的处理器之前设置 Access-Control-Allow-Origin 等头部。
+// 预检请求的方法是 OPTIONS，需要为同一个路径注册一个 OPTIONS 路由，例如
+//
+//	cors := middleware.CORS(middleware.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: 10 * time.Minute})
+//	r.HandleFunc("OPTIONS", "/api/items", cors)
+//	r.HandleFunc("PUT", "/api/items", cors, putItems)
+func CORS(config CORSConfig) router.Middleware {
+	if len(config.AllowedMethods) == 0 {
+		config.AllowedMethods = DefaultCORSMethods
+	}
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			origin := c.Message.Header("Origin")
+			if origin == "" { // 不是跨域请求
+				next(c)
+				return
+			}
+			allowed := config.allowsOrigin(origin)
+
+			method, preflight := c.Message.LookupHeader("Access-Control-Request-Method")
+			if c.Message.Method() == "OPTIONS" && preflight {
+				requested := c.Message.Header("Access-Control-Request-Headers")
+				headers := map[string]string{"Vary": "Origin, Access-Control-Request-Method, Access-Control-Request-Headers"}
+				if !allowed || !containsFold(config.AllowedMethods, method) || !config.allowsHeaders(requested) {
+					c.WriteResponse(403, "Forbidden", nil, headers)
+					return
+				}
+				config.originHeaders(headers, origin)
+				headers["Access-Control-Allow-Methods"] = method
+				if requested != "" {
+					headers["Access-Control-Allow-Headers"] = requested
+				}
+				if config.MaxAge > 0 {
+					headers["Access-Control-Max-Age"] = strconv.Itoa(int(config.MaxAge / time.Second))
+				}
+				c.WriteResponse(204, "No Content", nil, headers)
+				return
+			}
+
+			if allowed {
+				headers := make(map[string]string)
+				config.originHeaders(headers, origin)
+				if exposed != "" {
+					headers["Access-Control-Expose-Headers"] = exposed
+				}
+				for name, value := range headers {
+					c.SetResponseHeader(name, value)
+				}
+			}
+			c.SetResponseHeader("Vary", "Origin")
+			next(c)
+		}
+	}
+}
+
+// originHeaders 设置 Access-Control-Allow-Origin 和 Access-Control-Allow-Credentials
+func (config CORSConfig) originHeaders(headers map[string]string, origin string) {
+	if containsFold(config.AllowedOrigins, "*") && !config.AllowCredentials {
+		headers["Access-Control-Allow-Origin"] = "*"
+	} else {
+		headers["Access-Control-Allow-Origin"] = origin
+	}
+	if config.AllowCredentials {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+}
+
+// allowsOrigin 判断 origin 是否在 AllowedOrigins 中
+func (config CORSConfig) allowsOrigin(origin string) bool {
+	return containsFold(config.AllowedOrigins, "*") || containsFold(config.AllowedOrigins, origin)
+}
+
+// allowsHeaders 判断预检请求中逗号分隔的 Access-Control-Request-Headers 是否都在 AllowedHeaders 中
+func (config CORSConfig) allowsHeaders(requested string) bool {
+	if containsFold(config.AllowedHeaders, "*") {
+		return true
+	}
+	for _, name := range strings.Split(requested, ",") {
+		if name = strings.TrimSpace(name); name != "" && !containsFold(config.AllowedHeaders, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold 判断 list 中是否有与 s 不区分大小写地相同的元素
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// corsRequest 发送一个带有 headers 的请求，返回响应
+func corsRequest(t *testing.T, method, url string, headers map[string]string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORSPreflight(t *testing.T) {
+	cors := CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	r := router.NewRouter()
+	r.HandleFunc("OPTIONS", "/items", cors, func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) { t.Error("handler called for a preflight request") }
+	})
+	r.HandleFunc("PUT", "/items", cors, echoPath)
+	base := startRouter(t, r)
+
+	// 完整的预检请求：回显方法和头部，带有 Max-Age 和 Credentials，来源是具体的而不是 "*"
+	resp := corsRequest(t, "OPTIONS", base+"/items", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, x-token",
+	})
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "PUT",
+		"Access-Control-Allow-Headers":     "content-type, x-token",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	} {
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("preflight %s = %q, want %q", name, got, want)
+		}
+	}
+	if resp.StatusCode != 204 {
+		t.Errorf("preflight status = %d, want 204", resp.StatusCode)
+	}
+
+	// 来源、方法或头部不被允许的预检请求回复 403，不带有允许的头部
+	for name, headers := range map[string]map[string]string{
+		"origin": {"Origin": "https://evil.example", "Access-Control-Request-Method": "PUT"},
+		"method": {"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"},
+		"header": {"Origin": "https://app.example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Token, X-Other"},
+	} {
+		if resp := corsRequest(t, "OPTIONS", base+"/items", headers); resp.StatusCode != 403 || resp.Header.Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("disallowed %s: %d %v", name, resp.StatusCode, resp.Header)
+		}
+	}
+
+	// 实际请求带有来源、Credentials 和允许读取的响应头部
+	resp = corsRequest(t, "PUT", base+"/items", map[string]string{"Origin": "https://app.example.com"})
+	if resp.StatusCode != 200 || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		resp.Header.Get("Access-Control-Allow-Credentials") != "true" || resp.Header.Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Fatalf("actual request: %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestCORSWildcardOrigin(t *testing.T) {
+	r := router.NewRouter()
+	r.HandleFunc("GET", "/", CORS(CORSConfig{AllowedOrigins: []string{"*"}}), echoPath)
+	base := startRouter(t, r)
+	if resp := corsRequest(t, "GET", base+"/", map[string]string{"Origin": "https://any.example"}); resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want *", resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
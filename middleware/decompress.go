@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/context"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+)
+
+// Decompress 返回一个中间件，透明地解码 Content-Encoding 为 gzip 或 deflate 的请求主体，之后的处理器读到的是解码后的内容
+// maxSize 是解码后允许的最大长度，不同的路由可以使用不同的限制（例如上传接口和 webhook）；
+// 解码过程中一旦超过限制就停止并回复 413 Payload Too Large，防止压缩炸弹，为0或负数时不限制
+func Decompress(maxSize int64) router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			if err := c.Message.Decompress(maxSize); err != nil {
+				if err == context.ErrDecompressedTooLarge {
+					c.WriteResponse(413, "Payload Too Large", []byte("Payload Too Large"), map[string]string{"Connection": "close"})
+					return
+				}
+				c.WriteResponse(400, "Bad Request", []byte("Bad Request"))
+				return
+			}
+			next(c)
+		}
+	}
+}
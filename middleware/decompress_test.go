@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecompressRoundTrip(t *testing.T) {
+	r := router.NewRouter()
+	r.HandleFunc("POST", "/", Decompress(1<<20), func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			body, _ := c.Message.ReadBody()
+			c.WriteResponse(200, "OK", body)
+		}
+	})
+	base := startRouter(t, r)
+
+	original := strings.Repeat("round trip ", 200)
+	for _, encoding := range []string{"gzip", "deflate"} {
+		var buf bytes.Buffer
+		var w io.WriteCloser = gzip.NewWriter(&buf)
+		if encoding == "deflate" {
+			w = zlib.NewWriter(&buf) // HTTP 的 deflate 编码是 zlib 格式
+		}
+		io.WriteString(w, original)
+		w.Close()
+
+		req, _ := http.NewRequest("POST", base+"/", &buf)
+		req.Header.Set("Content-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != original {
+			t.Errorf("%s: response = %d with %d bytes, want the original %d bytes", encoding, resp.StatusCode, len(body), len(original))
+		}
+	}
+}
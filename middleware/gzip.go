@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"strings"
+	"sync"
+)
+
+// DefaultGzipMinSize 是 GzipConfig 没有设置 MinSize 时压缩的最小响应主体长度，更小的主体压缩后往往不会变小
+const DefaultGzipMinSize = 1024
+
+// DefaultGzipExcludedTypes 是 GzipConfig 没有设置 ExcludedTypes 时不压缩的内容类型，它们本身已经是压缩过的格式
+var DefaultGzipExcludedTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed", "application/x-rar-compressed",
+}
+
+// GzipConfig 是 Gzip 中间件的配置，零值字段使用默认值
+type GzipConfig struct {
+	// Level 是压缩级别，取值见 compress/gzip，为0时使用 gzip.DefaultCompression；级别越高压缩越慢
+	Level int
+
+	// MinSize 是压缩的最小响应主体长度，为0时使用 DefaultGzipMinSize
+	MinSize int
+
+	// ContentTypes 是允许压缩的内容类型，为空时允许所有不在 ExcludedTypes 中的类型；
+	// 以 "/" 结尾的项匹配一整类，例如 "text/"，其他项匹配去掉参数后的完整类型，例如 "application/json"
+	ContentTypes []string
+
+	// ExcludedTypes 是不压缩的内容类型，格式与 ContentTypes 相同，为 nil 时使用 DefaultGzipExcludedTypes
+	ExcludedTypes []string
+}
+
+// Gzip 返回一个压缩响应主体的中间件：客户端的 Accept-Encoding 接受 gzip，并且响应的内容类型和长度符合配置时，
+// 用 gzip 压缩主体并设置 Content-Encoding: gzip。可能被压缩的响应都会带有 Vary: Accept-Encoding。
+// 已经设置了 Content-Encoding 的响应（例如 WriteCached 已经压缩过的）、部分内容响应和流式响应不会被压缩。
+// gzip 写入器通过 sync.Pool 在请求之间复用
+func Gzip(config ...GzipConfig) router.Middleware {
+	var cfg GzipConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = DefaultGzipMinSize
+	}
+	if cfg.ExcludedTypes == nil {
+		cfg.ExcludedTypes = DefaultGzipExcludedTypes
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		zw, err := gzip.NewWriterLevel(nil, cfg.Level)
+		if err != nil { // 不合法的压缩级别
+			zw, _ = gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		}
+		return zw
+	}}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			accepted := server.AcceptsEncoding(c.Message.Header("Accept-Encoding"), "gzip")
+			c.SetResponseEncoder(func(statusCode int, header func(string) string, body []byte) ([]byte, map[string]string) {
+				if len(body) < cfg.MinSize || header("Content-Encoding") != "" || header("Content-Range") != "" || statusCode == 206 {
+					return body, nil
+				}
+				contentType := header("Content-Type")
+				if !cfg.compressible(contentType) {
+					return body, nil
+				}
+				headers := map[string]string{"Vary": "Accept-Encoding"}
+				if !accepted {
+					return body, headers
+				}
+
+				var buf bytes.Buffer
+				zw := pool.Get().(*gzip.Writer)
+				zw.Reset(&buf)
+				zw.Write(body)
+				err := zw.Close()
+				pool.Put(zw)
+				if err != nil || buf.Len() >= len(body) { // 压缩后没有变小
+					return body, headers
+				}
+				headers["Content-Encoding"] = "gzip"
+				headers["Content-Type"] = contentType // 压缩后的主体无法再检测内容类型
+				return buf.Bytes(), headers
+			})
+			next(c)
+		}
+	}
+}
+
+// compressible 判断内容类型是否应该被压缩
+func (cfg GzipConfig) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if len(cfg.ContentTypes) > 0 && !matchesType(cfg.ContentTypes, mediaType) {
+		return false
+	}
+	return !matchesType(cfg.ExcludedTypes, mediaType)
+}
+
+// matchesType 判断 mediaType 是否匹配 patterns 中的一项，以 "/" 结尾的项匹配一整类
+func matchesType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType || strings.HasSuffix(pattern, "/") && strings.HasPrefix(mediaType, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// respond 返回一个用给定内容类型回复 body 的处理器
+func respond(contentType, body string) router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			c.WriteResponse(200, "OK", []byte(body), map[string]string{"Content-Type": contentType})
+		}
+	}
+}
+
+func TestGzipPolicy(t *testing.T) {
+	large := strings.Repeat("compressible text ", 200)
+	r := router.NewRouter()
+	r.HandleFunc("GET", "/large", Gzip(), respond("text/plain; charset=utf-8", large))
+	r.HandleFunc("GET", "/small", Gzip(), respond("text/plain", strings.Repeat("s", DefaultGzipMinSize-1)))
+	r.HandleFunc("GET", "/png", Gzip(), respond("image/png", large))
+	r.HandleFunc("GET", "/json", Gzip(GzipConfig{ContentTypes: []string{"text/"}}), respond("application/json", large))
+	r.HandleFunc("GET", "/min", Gzip(GzipConfig{MinSize: 10}), respond("text/plain", strings.Repeat("m", 100)))
+	base := startRouter(t, r)
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}} // 不让客户端自动解压
+
+	for _, tt := range []struct {
+		path       string
+		compressed bool
+	}{
+		{"/large", true},
+		{"/small", false}, // 小于 MinSize
+		{"/png", false},   // 已经压缩过的类型
+		{"/json", false},  // 不在 ContentTypes 中
+		{"/min", true},    // 自定义的 MinSize
+	} {
+		req, _ := http.NewRequest("GET", base+tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if compressed := resp.Header.Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Errorf("GET %s: Content-Encoding = %q, want compressed %v", tt.path, resp.Header.Get("Content-Encoding"), tt.compressed)
+			continue
+		}
+		if !tt.compressed {
+			continue
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.path, err)
+		}
+		if plain, _ := io.ReadAll(zr); len(plain) == 0 || resp.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("GET %s: decompressed %d bytes, Vary = %q", tt.path, len(plain), resp.Header.Get("Vary"))
+		}
+	}
+
+	// 客户端不接受 gzip 时原样发送，但仍然带有 Vary
+	resp, err := client.Get(base + "/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "" || string(body) != large || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("identity response: %v, %d bytes", resp.Header, len(body))
+	}
+}
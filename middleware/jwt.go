@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JWTClaims 是令牌载荷中的声明
+type JWTClaims map[string]interface{}
+
+// JWTConfig 是 JWT 中间件的配置
+type JWTConfig struct {
+	// Key 是验证签名的密钥：HS256/HS384/HS512 使用 []byte，RS256/RS384/RS512 使用 *rsa.PublicKey，
+	// ES256/ES384/ES512 使用 *ecdsa.PublicKey
+	Key interface{}
+
+	// Algorithms 是允许的签名算法，必须和 Key 的类型一致，为空时只允许 Key 对应系列中的 256 位算法（例如 HS256）
+	// "none" 永远不被接受
+	Algorithms []string
+
+	Issuer   string        // 不为空时 iss 声明必须等于它
+	Audience string        // 不为空时 aud 声明必须包含它
+	Leeway   time.Duration // 检查 exp 和 nbf 时允许的时钟误差
+
+	// ContextKey 是解析出的 JWTClaims 在 Conn 数据中的键，默认为 "jwt"
+	ContextKey string
+}
+
+var (
+	ErrJWTMalformed       = errors.New("jwt: malformed token")
+	ErrJWTAlgorithm       = errors.New("jwt: unexpected signing algorithm")
+	ErrJWTSignature       = errors.New("jwt: invalid signature")
+	ErrJWTExpired         = errors.New("jwt: token is expired")
+	ErrJWTNotValidYet     = errors.New("jwt: token is not valid yet")
+	ErrJWTInvalidIssuer   = errors.New("jwt: invalid issuer")
+	ErrJWTInvalidAudience = errors.New("jwt: invalid audience")
+	errJWTKeyTypeMismatch = errors.New("jwt: key type does not match algorithm")
+	errJWTMissingToken    = errors.New("jwt: missing bearer token")
+)
+
+// jwtHashes 是每个支持的算法使用的哈希函数，算法名的前两个字母决定密钥的类型
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// JWT 返回一个验证 Authorization: Bearer 令牌的中间件，令牌有效时将它的 JWTClaims 存入 Conn 数据（键为 config.ContextKey），
+// 否则回复 401 Unauthorized 并带有 WWW-Authenticate: Bearer 头部
+func JWT(config JWTConfig) router.Middleware {
+	if config.ContextKey == "" {
+		config.ContextKey = "jwt"
+	}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			scheme, token := c.Message.Authorization()
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				unauthorized(&c, errJWTMissingToken)
+				return
+			}
+			claims, err := ParseJWT(token, config)
+			if err != nil {
+				unauthorized(&c, err)
+				return
+			}
+			c.Set(config.ContextKey, claims)
+			next(c)
+		}
+	}
+}
+
+// unauthorized 回复 401，WWW-Authenticate 头部中带有错误原因
+func unauthorized(c *server.Conn, err error) {
+	challenge := "Bearer"
+	if err != errJWTMissingToken {
+		challenge = `Bearer error="invalid_token"`
+	}
+	c.WriteResponse(401, "Unauthorized", []byte("Unauthorized"), map[string]string{"WWW-Authenticate": challenge})
+}
+
+// ParseJWT 验证一个紧凑格式的 JWT 的签名和 exp、nbf、iss、aud 声明，返回它的声明
+func ParseJWT(token string, config JWTConfig) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if !allowsAlgorithm(config, header.Alg) {
+		return nil, ErrJWTAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := verifySignature(header.Alg, config.Key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := validateClaims(claims, config); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// decodeSegment 解码一个 base64url 编码的 JSON 段
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrJWTMalformed
+	}
+	return nil
+}
+
+// allowsAlgorithm 判断令牌头部声明的算法是否被配置允许
+// 没有配置 Algorithms 时根据 Key 的类型选择，这样攻击者不能用 RSA 公钥作为 HMAC 密钥伪造令牌（算法混淆）
+func allowsAlgorithm(config JWTConfig, alg string) bool {
+	if _, ok := jwtHashes[alg]; !ok { // 包括 "none"
+		return false
+	}
+	if len(config.Algorithms) == 0 {
+		switch config.Key.(type) {
+		case []byte:
+			return alg == "HS256"
+		case *rsa.PublicKey:
+			return alg == "RS256"
+		case *ecdsa.PublicKey:
+			return alg == "ES256"
+		}
+		return false
+	}
+	for _, allowed := range config.Algorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature 使用 alg 对应的算法验证 signingInput 的签名，密钥的类型必须和算法的系列一致
+func verifySignature(alg string, key interface{}, signingInput string, signature []byte) error {
+	hash := jwtHashes[alg]
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errJWTKeyTypeMismatch
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrJWTSignature
+		}
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errJWTKeyTypeMismatch
+		}
+		if rsa.VerifyPKCS1v15(pub, hash, digest, signature) != nil {
+			return ErrJWTSignature
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errJWTKeyTypeMismatch
+		}
+		// 签名是定长的 R 和 S 拼接在一起，长度由曲线决定
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrJWTSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrJWTSignature
+		}
+	default:
+		return ErrJWTAlgorithm
+	}
+	return nil
+}
+
+// validateClaims 检查标准声明 exp、nbf、iss 和 aud，没有出现的 exp 和 nbf 不做检查
+func validateClaims(claims JWTClaims, config JWTConfig) error {
+	now := time.Now()
+
+	if v, ok := claims["exp"]; ok {
+		exp, ok := v.(float64)
+		if !ok {
+			return ErrJWTMalformed
+		}
+		if now.After(time.Unix(int64(exp), 0).Add(config.Leeway)) {
+			return ErrJWTExpired
+		}
+	}
+	if v, ok := claims["nbf"]; ok {
+		nbf, ok := v.(float64)
+		if !ok {
+			return ErrJWTMalformed
+		}
+		if now.Add(config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrJWTNotValidYet
+		}
+	}
+
+	if config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != config.Issuer {
+			return ErrJWTInvalidIssuer
+		}
+	}
+
+	if config.Audience != "" {
+		// aud 可以是一个字符串或者一个字符串数组
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud == config.Audience {
+				return nil
+			}
+		case []interface{}:
+			for _, a := range aud {
+				if s, _ := a.(string); s == config.Audience {
+					return nil
+				}
+			}
+		}
+		return ErrJWTInvalidAudience
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"log"
+	"time"
+)
+
+// LoggerConfig 是 Logger 中间件的配置
+type LoggerConfig struct {
+	// SlowThreshold 大于0时只记录耗时超过它的请求和出错（状态码为5xx）的请求，并使用更高的级别
+	SlowThreshold time.Duration
+
+	// Logger 是写入日志的记录器，为 nil 时使用 log 包的标准记录器
+	Logger *log.Logger
+}
+
+// Logger 返回一个记录请求方法、路径、状态码和耗时的中间件，每个请求的耗时都会被测量
+func Logger(config LoggerConfig) router.Middleware {
+	printf := log.Printf
+	if config.Logger != nil {
+		printf = config.Logger.Printf
+	}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			start := time.Now()
+			next(c)
+			duration := time.Since(start)
+
+			method, path, status := c.Message.Method(), c.Message.Path(), c.Status()
+			switch {
+			case status >= 500:
+				printf("[ERROR] %s %s %d %v", method, path, status, duration)
+			case config.SlowThreshold > 0 && duration > config.SlowThreshold:
+				printf("[WARN] slow request %s %s %d %v (threshold %v)", method, path, status, duration, config.SlowThreshold)
+			case config.SlowThreshold <= 0:
+				printf("[INFO] %s %s %d %v", method, path, status, duration)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Package middleware 这个中间件模块提供了一些常用的中间件，它们都是 router.Middleware 类型，可以直接传给 Router.HandleFunc。
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/context"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"strings"
+)
+
+// RequireContentType 返回一个中间件，只允许内容类型在 types 中的请求主体通过，否则回复 415 Unsupported Media Type
+// 只检查 POST、PUT、PATCH 请求和其他带有主体的请求，没有主体的 GET、HEAD、DELETE 请求直接放行
+func RequireContentType(types ...string) router.Middleware {
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		allowed[strings.ToLower(t)] = true
+	}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			if !hasBody(c.Message) {
+				next(c)
+				return
+			}
+			if !allowed[c.Message.ContentType()] {
+				c.WriteResponse(415, "Unsupported Media Type", []byte("Unsupported Media Type"))
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// hasBody 判断请求是否需要检查主体，POST、PUT、PATCH 请求总是需要检查
+func hasBody(m *context.Context) bool {
+	switch m.Method() {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+	if _, ok := m.LookupHeader("Transfer-Encoding"); ok {
+		return true
+	}
+	length, err := m.ContentLength()
+	return err != nil || length > 0
+}
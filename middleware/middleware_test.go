@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// startRouter 在本地的随机端口上用 r 运行一个服务器，返回它的地址，测试结束时关闭服务器
+func startRouter(t testing.TB, r *router.Router) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server.Server{Handler: r}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + ln.Addr().String()
+}
+
+// get 发送一个 GET 请求，返回响应的状态码和主体
+func get(t testing.TB, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// echoPath 是一个回复请求路径的处理器
+func echoPath(next router.HandlerFunc) router.HandlerFunc {
+	return func(c server.Conn) {
+		c.WriteResponse(200, "OK", []byte(c.Message.Path()))
+	}
+}
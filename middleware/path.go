@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"strings"
+)
+
+// StripPrefix 返回一个中间件，从请求路径中去掉 prefix 后再交给之后的中间件，路径不在 prefix 之下时回复 404
+// prefix 按照完整的路径段匹配，"/admin" 和 "/admin/" 都匹配 "/admin" 和 "/admin/users"，但不匹配 "/administrator"。
+// 与 Router.Dispatch 配合可以把子路由挂载在一个前缀下：
+//
+//	r.NotFound(middleware.StripPrefix("/admin"), admin.Dispatch)
+func StripPrefix(prefix string) router.Middleware {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			path := c.Message.Path()
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				c.WriteResponse(404, "404 Not Found", []byte("Not Found"))
+				return
+			}
+			path = strings.TrimPrefix(path, prefix)
+			if path == "" {
+				path = "/"
+			}
+			c.Message.SetPath(path)
+			next(c)
+		}
+	}
+}
+
+// RewritePath 返回一个中间件，用 fn 的返回值替换请求路径后再交给之后的中间件，查询字符串保持不变
+func RewritePath(fn func(path string) string) router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			c.Message.SetPath(fn(c.Message.Path()))
+			next(c)
+		}
+	}
+}
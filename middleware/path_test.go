@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"testing"
+)
+
+func TestStripPrefixSegmentBoundary(t *testing.T) {
+	for _, prefix := range []string{"/admin", "/admin/"} {
+		t.Run(prefix, func(t *testing.T) {
+			r := router.NewRouter()
+			r.NotFound(StripPrefix(prefix), echoPath)
+			base := startRouter(t, r)
+
+			for path, want := range map[string]struct {
+				status int
+				body   string
+			}{
+				"/admin":         {200, "/"},
+				"/admin/":        {200, "/"},
+				"/admin/users":   {200, "/users"},
+				"/admin/users/7": {200, "/users/7"},
+				"/administrator": {404, "Not Found"},
+				"/admin-panel/x": {404, "Not Found"},
+				"/other":         {404, "Not Found"},
+			} {
+				if status, body := get(t, base+path); status != want.status || body != want.body {
+					t.Errorf("GET %s = %d %q, want %d %q", path, status, body, want.status, want.body)
+				}
+			}
+		})
+	}
+}
+
+func TestStripPrefixRoot(t *testing.T) {
+	r := router.NewRouter()
+	r.NotFound(StripPrefix("/"), echoPath)
+	base := startRouter(t, r)
+	if status, body := get(t, base+"/a/b"); status != 200 || body != "/a/b" {
+		t.Fatalf("GET /a/b = %d %q", status, body)
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"strings"
+)
+
+const (
+	// DefaultMaxQueryParams 是 LimitQuery 的 maxParams 为0时允许的查询参数数量
+	DefaultMaxQueryParams = 100
+
+	// DefaultMaxQueryValueLen 是 LimitQuery 的 maxValueLen 为0时允许的参数名称和值的最大长度（解码之后的字节数）
+	DefaultMaxQueryValueLen = 4096
+)
+
+// LimitQuery 返回一个限制查询字符串的中间件：参数超过 maxParams 个，或者某个参数的名称或值超过 maxValueLen 个字节时回复 400 Bad Request，
+// 保护对每个参数做昂贵处理的处理器。限制为0时使用 DefaultMaxQueryParams 和 DefaultMaxQueryValueLen，为负数时不限制。
+// 参数的数量在解码之前就会被检查，参数过多的查询字符串不会被完整解析
+func LimitQuery(maxParams int, maxValueLen int) router.Middleware {
+	if maxParams == 0 {
+		maxParams = DefaultMaxQueryParams
+	}
+	if maxValueLen == 0 {
+		maxValueLen = DefaultMaxQueryValueLen
+	}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			if !queryWithinLimits(c.Message.RawQuery(), maxParams, maxValueLen) {
+				c.WriteResponse(400, "Bad Request", []byte("Bad Request"))
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// queryWithinLimits 检查查询字符串的参数数量和每个参数的名称与值的长度，重复的参数名每次出现都计数
+func queryWithinLimits(rawQuery string, maxParams, maxValueLen int) bool {
+	if rawQuery == "" {
+		return true
+	}
+	if maxParams > 0 && strings.Count(rawQuery, "&")+1 > maxParams { // 先粗略计数，空的参数（例如 "a=1&&b=2"）也被计入
+		params := 0
+		for _, pair := range strings.Split(rawQuery, "&") {
+			if pair != "" {
+				params++
+			}
+		}
+		if params > maxParams {
+			return false
+		}
+	}
+	if maxValueLen < 0 {
+		return true
+	}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if len(pair) <= maxValueLen { // 解码只会让长度变短，不需要解码
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		if decodedLen(key) > maxValueLen || decodedLen(value) > maxValueLen {
+			return false
+		}
+	}
+	return true
+}
+
+// decodedLen 返回查询字符串中一个经过URL编码的部分解码之后的字节数，每个 "%XX" 解码为一个字节
+func decodedLen(s string) int {
+	return len(s) - 2*strings.Count(s, "%")
+}
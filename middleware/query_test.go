@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// params 返回一个有 n 个参数的查询字符串
+func params(n int) string {
+	pairs := make([]string, n)
+	for i := range pairs {
+		pairs[i] = "p" + strconv.Itoa(i) + "=1"
+	}
+	return strings.Join(pairs, "&")
+}
+
+func TestQueryWithinLimits(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		rawQuery string
+		params   int
+		valueLen int
+		want     bool
+	}{
+		{"no query", "", 1, 1, true},
+		{"params at limit", params(3), 3, 10, true},
+		{"params over limit", params(4), 3, 10, false},
+		{"empty params not counted", "a=1&&b=2&&&c=3", 3, 10, true},
+		{"repeated names counted", "a=1&a=2&a=3&a=4", 3, 10, false},
+		{"value at limit", "a=" + strings.Repeat("v", 5), 10, 5, true},
+		{"value over limit", "a=" + strings.Repeat("v", 6), 10, 5, false},
+		{"name over limit", strings.Repeat("k", 6) + "=1", 10, 5, false},
+		{"encoded value at limit", "a=%41%42%43%44%45", 10, 5, true}, // 解码之后是5个字节
+		{"encoded value over limit", "a=%41%42%43%44%45%46", 10, 5, false},
+		{"no value limit", "a=" + strings.Repeat("v", 10000), 10, -1, true},
+		{"no param limit", params(1000), -1, 10, true},
+	} {
+		if got := queryWithinLimits(tt.rawQuery, tt.params, tt.valueLen); got != tt.want {
+			t.Errorf("%s: queryWithinLimits() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimitQueryDefaults(t *testing.T) {
+	r := router.NewRouter()
+	r.HandleFunc("GET", "/", LimitQuery(0, 0), echoPath)
+	base := startRouter(t, r)
+
+	for _, tt := range []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"default param limit", params(DefaultMaxQueryParams), 200},
+		{"one param over the default", params(DefaultMaxQueryParams + 1), 400},
+		{"default value length", "a=" + strings.Repeat("v", DefaultMaxQueryValueLen), 200},
+		{"one byte over the default", "a=" + strings.Repeat("v", DefaultMaxQueryValueLen+1), 400},
+	} {
+		if status, _ := get(t, base+"/?"+tt.query); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
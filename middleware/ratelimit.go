@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// GlobalRateLimit 返回一个限制整个服务器请求速率的中间件，不区分客户端：平均每秒最多通过 rps 个请求，最多允许 burst 个请求同时突发，
+// 超出的请求回复 429 Too Many Requests，Retry-After 头部给出下一个请求可以通过的秒数。
+// 所有请求共享一个令牌桶，它只使用原子操作，没有锁，高并发时不会成为瓶颈
+func GlobalRateLimit(rps int, burst int) router.Middleware {
+	if rps <= 0 {
+		rps = 1
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	limiter := &rateLimiter{
+		interval: int64(time.Second) / int64(rps),
+	}
+	limiter.capacity = limiter.interval * int64(burst)
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			if wait, ok := limiter.allow(time.Now().UnixNano()); !ok {
+				retryAfter := (wait + int64(time.Second) - 1) / int64(time.Second) // 向上取整到秒
+				c.WriteResponse(429, "Too Many Requests", []byte("Too Many Requests"),
+					map[string]string{"Retry-After": strconv.FormatInt(retryAfter, 10)})
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+// rateLimiter 是一个用 GCRA 算法实现的令牌桶，整个状态只有一个理论到达时间，可以用一次比较并交换更新
+type rateLimiter struct {
+	tat      atomic.Int64 // 理论到达时间（纳秒），桶中的令牌被取完的时刻
+	interval int64        // 产生一个令牌需要的时间
+	capacity int64        // 桶的容量对应的时间，即 burst 个令牌
+}
+
+// allow 在 now 时刻尝试取出一个令牌，取不到时返回还需要等待的纳秒数
+func (l *rateLimiter) allow(now int64) (wait int64, ok bool) {
+	for {
+		tat := l.tat.Load()
+		start := tat
+		if start < now { // 桶已经满了，从现在开始计算
+			start = now
+		}
+		next := start + l.interval
+		if over := next - now - l.capacity; over > 0 {
+			return over, false
+		}
+		if l.tat.CompareAndSwap(tat, next) {
+			return 0, true
+		}
+	}
+}
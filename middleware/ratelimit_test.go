@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	l := &rateLimiter{interval: int64(100 * time.Millisecond)}
+	l.capacity = l.interval * 3
+	now := time.Now().UnixNano()
+	for i := 0; i < 3; i++ {
+		if _, ok := l.allow(now); !ok {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	wait, ok := l.allow(now)
+	if ok || wait != int64(100*time.Millisecond) {
+		t.Fatalf("allow() after the burst = %v, %v, want a wait of one interval", time.Duration(wait), ok)
+	}
+	if _, ok := l.allow(now + wait); !ok { // 等待一个间隔之后产生了一个新的令牌
+		t.Fatal("request after waiting was rejected")
+	}
+}
+
+func BenchmarkRateLimiterAllow(b *testing.B) {
+	l := &rateLimiter{interval: 1}
+	l.capacity = 1 << 62 // 桶足够大，测量的是取令牌本身的开销
+	now := time.Now().UnixNano()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.allow(now)
+	}
+}
+
+func BenchmarkRateLimiterAllowParallel(b *testing.B) {
+	l := &rateLimiter{interval: 1}
+	l.capacity = 1 << 62
+	now := time.Now().UnixNano()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.allow(now)
+		}
+	})
+}
+
+func BenchmarkGlobalRateLimit(b *testing.B) {
+	// 每纳秒产生一个令牌，请求不会被拒绝，测量的是中间件本身的开销
+	handler := GlobalRateLimit(int(time.Second), 1<<30)(func(c server.Conn) {})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			handler(server.Conn{})
+		}
+	})
+}
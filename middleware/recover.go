@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"log"
+	"runtime/debug"
+)
+
+// Recover 返回一个捕获之后的中间件和处理器中 panic 的中间件，它应该放在链的最前面，例如
+// r.HandleFunc("GET", "/", middleware.Recover(), middleware.Logger(cfg), handler)。
+// 发生 panic 时记录 panic 的值和调用栈；还没有写入响应时回复 500 Internal Server Error 并关闭连接，
+// 已经写入了（部分）响应时无法再改变状态码，只能发送已经写入的内容并关闭连接，让客户端知道响应不完整。
+func Recover() router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				log.Printf("panic recovered: %v\n%s", p, debug.Stack())
+				if c.WriteFinalResponse(500, "Internal Server Error", []byte("Internal Server Error"), map[string]string{"Connection": "close"}) {
+					return
+				}
+				c.Flush()
+				c.Conn.Close()
+			}()
+			next(c)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"log"
+)
+
+// Sessions 返回一个会话中间件：在调用之后的处理器之前通过 manager 加载或创建会话，处理器通过 c.Session() 读写会话，
+// 登录等权限变化之后调用 c.RenewSession() 更换会话 ID；处理器返回后会话被保存到 manager.Store 中。
+// 创建会话失败时回复 500 Internal Server Error
+func Sessions(manager *server.SessionManager) router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			if _, err := manager.Start(&c); err != nil {
+				log.Println("start session err: ", err)
+				c.WriteResponse(500, "Internal Server Error", []byte("Internal Server Error"))
+				return
+			}
+			next(c)
+			if err := manager.Save(&c); err != nil {
+				log.Println("save session err: ", err)
+			}
+		}
+	}
+}
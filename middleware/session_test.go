@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+)
+
+func TestSessionRenewal(t *testing.T) {
+	store := server.NewMemoryStore()
+	r := router.NewRouter()
+	reply := func(handle func(c server.Conn) string) router.Middleware {
+		return func(next router.HandlerFunc) router.HandlerFunc {
+			return func(c server.Conn) {
+				c.WriteResponse(200, "OK", []byte(handle(c)))
+			}
+		}
+	}
+	manager := &server.SessionManager{Store: store}
+	r.HandleFunc("GET", "/set", Sessions(manager), reply(func(c server.Conn) string {
+		c.Session().Set("user", "alice")
+		return c.Session().ID()
+	}))
+	r.HandleFunc("GET", "/login", Sessions(manager), reply(func(c server.Conn) string {
+		if err := c.RenewSession(); err != nil {
+			return err.Error()
+		}
+		return c.Session().ID()
+	}))
+	r.HandleFunc("GET", "/whoami", Sessions(manager), reply(func(c server.Conn) string {
+		user, _ := c.Session().Get("user")
+		return fmt.Sprint(user)
+	}))
+	base := startRouter(t, r)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	fetch := func(path, cookie string) string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", base+path, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", "session_id="+cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	oldID := fetch("/set", "")
+	newID := fetch("/login", "")
+	if newID == oldID || newID == "" {
+		t.Fatalf("RenewSession() kept the ID %q", oldID)
+	}
+	// 数据迁移到新的 ID 下，客户端收到新的 Cookie
+	if user := fetch("/whoami", ""); user != "alice" {
+		t.Fatalf("user after renewal = %q, want %q", user, "alice")
+	}
+	if values, ok := store.Load(newID); !ok || values["user"] != "alice" {
+		t.Fatalf("store.Load(new ID) = %v, %v", values, ok)
+	}
+
+	// 旧的 ID 已经从 Store 中删除，带着它的请求得到一个新的空会话
+	if _, ok := store.Load(oldID); ok {
+		t.Fatal("old session ID still in the store")
+	}
+	client.Jar = nil
+	if user := fetch("/whoami", oldID); user != "<nil>" {
+		t.Fatalf("user with the old ID = %q, want no session data", user)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"time"
+)
+
+// TimeoutConfig 是 Timeout 中间件超时时回复的响应，零值字段使用默认值
+type TimeoutConfig struct {
+	Status      int    // 状态码，默认为 503
+	ContentType string // 内容类型，为空时根据 Body 自动检测
+	Body        []byte // 响应主体，为 nil 时使用状态码对应的原因短语
+}
+
+// Timeout 返回一个限制之后的处理器运行时间的中间件，超过 d 时回复 config 中配置的响应（例如 504 和 {"error":"timeout"}）并关闭连接。
+// 之后的处理器在另一个协程中运行，超时时它的 Conn.Context() 会被取消，处理器应该检查它并尽快停止，
+// 尤其不能再读取请求主体或者使用 c.Message：服务器会在超时响应之后关闭连接并释放请求的资源（例如表单的临时文件）。
+// 超时响应写入后这个请求的响应会被封存，处理器之后的写入会被丢弃并返回 server.ErrResponseSealed。
+func Timeout(d time.Duration, config ...TimeoutConfig) router.Middleware {
+	cfg := TimeoutConfig{Status: 503}
+	if len(config) > 0 {
+		cfg = config[0]
+		if cfg.Status == 0 {
+			cfg.Status = 503
+		}
+	}
+	if cfg.Body == nil {
+		cfg.Body = []byte(server.StatusText(cfg.Status))
+	}
+	headers := map[string]string{"Connection": "close"}
+	if cfg.ContentType != "" {
+		headers["Content-Type"] = cfg.ContentType
+	}
+
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			ctx, cancel := context.WithTimeout(c.Context(), d)
+			defer cancel()
+			c.SetContext(ctx)
+
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+					close(done)
+				}()
+				next(c)
+			}()
+
+			select {
+			case <-done:
+				select {
+				case p := <-panicked: // 在当前协程中重新抛出处理器的 panic
+					panic(p)
+				default:
+				}
+			case <-ctx.Done():
+				// 处理器还在运行，连接停在请求中的哪个位置已经无法确定，即使处理器已经写入了响应也不能再复用它
+				c.CloseAfterRequest()
+				c.WriteFinalResponse(cfg.Status, server.StatusText(cfg.Status), cfg.Body, headers)
+			}
+		}
+	}
+}
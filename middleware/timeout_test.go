@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"github.com/lvkeliang/httpws/router"
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutClosesConnection(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	r := router.NewRouter()
+	r.HandleFunc("GET", "/slow", Timeout(50*time.Millisecond), func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			c.WriteResponse(200, "OK", []byte("partial")) // 超时之前已经写入了响应，连接同样不能复用
+			<-release
+		}
+	})
+	r.HandleFunc("GET", "/hang", Timeout(50*time.Millisecond), func(next router.HandlerFunc) router.HandlerFunc {
+		return func(c server.Conn) {
+			<-release
+		}
+	})
+	base := startRouter(t, r)
+
+	for path, want := range map[string]int{"/hang": 503, "/slow": 200} {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// 第二个请求已经在流水线中，超时之后服务器也不能读取它
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\nGET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Errorf("GET %s status = %d, want %d", path, resp.StatusCode, want)
+		}
+		if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+			t.Errorf("GET %s: connection not closed after timeout: %q, %v", path, rest, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestTimeoutHandlerRespectsCancellation(t *testing.T) {
+	type result struct {
+		ctxErr, writeErr error
+	}
+	results := make(chan result, 1)
+	r := router.NewRouter()
+	r.HandleFunc("GET", "/work", Timeout(50*time.Millisecond, TimeoutConfig{Status: 504, ContentType: "application/json", Body: []byte(`{"error":"timeout"}`)}),
+		func(next router.HandlerFunc) router.HandlerFunc {
+			return func(c server.Conn) {
+				select {
+				case <-c.Context().Done(): // 处理器观察到取消后提前返回
+					err := c.WriteResponse(200, "OK", []byte("late"))
+					results <- result{c.Context().Err(), err}
+				case <-time.After(5 * time.Second):
+					results <- result{}
+				}
+			}
+		})
+	base := startRouter(t, r)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /work HTTP/1.1\r\nHost: x\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 504 || string(body) != `{"error":"timeout"}` || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("response = %d %q (%s)", resp.StatusCode, body, resp.Header.Get("Content-Type"))
+	}
+
+	res := <-results
+	if res.ctxErr != context.DeadlineExceeded {
+		t.Fatalf("handler saw ctx.Err() = %v, want DeadlineExceeded", res.ctxErr)
+	}
+	if res.writeErr != server.ErrResponseSealed {
+		t.Fatalf("late write err = %v, want ErrResponseSealed", res.writeErr)
+	}
+	// 超时响应只写入一次，处理器之后的写入没有到达客户端
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+		t.Fatalf("unexpected data after the timeout response: %q, %v", rest, err)
+	}
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnectConfig 是 ConnectProxy 的配置，限制代理可以连接的目标，避免服务器成为一个开放代理
+type ConnectConfig struct {
+	// AllowedHosts 是允许连接的目标主机，例如 "example.com"、"*.example.com" 或 "10.0.0.1"，"*" 表示任意主机；
+	// 为空时拒绝所有目标，开放代理必须显式配置
+	AllowedHosts []string
+
+	// AllowedPorts 是允许连接的目标端口，为空时只允许 443
+	AllowedPorts []int
+
+	// DialTimeout 是连接目标的超时时间，为0时使用10秒
+	DialTimeout time.Duration
+}
+
+// ConnectProxy 返回一个处理 CONNECT 请求的中间件，用于实现 HTTPS 正向代理：它连接请求目标 "host:port"，
+// 回复 200 Connection Established 后接管客户端的连接，在两端之间双向转发字节，直到任意一端关闭。
+// 不允许的目标回复 403，格式错误的目标回复 400，连接目标失败回复 502。
+// 例如 r.HandleFunc("CONNECT", "*", router.ConnectProxy(router.ConnectConfig{AllowedHosts: []string{"*.example.com"}}))
+func ConnectProxy(config ConnectConfig) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c server.Conn) {
+			target := c.Message.Path()
+			host, port, err := net.SplitHostPort(target)
+			if err != nil || host == "" {
+				c.WriteResponse(400, "Bad Request", []byte("Bad Request"))
+				return
+			}
+			if !config.allows(host, port) {
+				c.WriteResponse(403, "Forbidden", []byte("Forbidden"))
+				return
+			}
+
+			timeout := config.DialTimeout
+			if timeout <= 0 {
+				timeout = 10 * time.Second
+			}
+			upstream, err := net.DialTimeout("tcp", target, timeout)
+			if err != nil {
+				c.WriteResponse(502, "Bad Gateway", []byte("Bad Gateway"))
+				return
+			}
+			defer upstream.Close()
+
+			// 对 CONNECT 的 2xx 响应不能带有 Content-Length 等头部，所以直接写入状态行
+			conn, reader, err := c.Hijack()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+				return
+			}
+			tunnel(conn, reader, upstream)
+		}
+	}
+}
+
+// allows 判断目标主机和端口是否被允许，主机名忽略大小写和末尾的点
+func (config ConnectConfig) allows(host, port string) bool {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	ports := config.AllowedPorts
+	if len(ports) == 0 {
+		ports = []int{443}
+	}
+	portAllowed := false
+	for _, allowed := range ports {
+		if p == allowed {
+			portAllowed = true
+			break
+		}
+	}
+	if !portAllowed {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range config.AllowedHosts {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		switch {
+		case pattern == "*" || pattern == host:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// tunnel 在客户端和目标之间双向转发字节，一个方向结束后关闭另一个方向，等待两个方向都结束后返回
+// 客户端一侧从 reader 读取，其中可能已经缓冲了客户端提前发送的字节（例如 TLS 的 ClientHello）
+func tunnel(conn net.Conn, reader io.Reader, upstream net.Conn) {
+	var once sync.Once
+	stop := func() {
+		upstream.Close()
+		conn.SetDeadline(time.Now()) // 客户端的连接由服务器关闭，这里只让阻塞的读写返回
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(upstream, reader)
+		once.Do(stop)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, upstream)
+		once.Do(stop)
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startUpstream 启动一个把收到的字节转成大写后发回的目标服务器，返回它的端口
+func startUpstream(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 512)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					conn.Write(bytes.ToUpper(buf[:n]))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// connect 发送一个 CONNECT 请求（以及紧跟在它后面的 early），返回连接、读取器和响应的状态行
+func connect(t *testing.T, base, target, early string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"+early)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, strings.TrimSpace(status)
+}
+
+func TestConnectProxyTunnel(t *testing.T) {
+	port := startUpstream(t)
+	target := "127.0.0.1:" + strconv.Itoa(port)
+	r := NewRouter()
+	r.HandleFunc("CONNECT", "*", ConnectProxy(ConnectConfig{AllowedHosts: []string{"127.0.0.1"}, AllowedPorts: []int{port}}))
+	base := startRouter(t, r)
+
+	// 客户端在收到 200 之前就发送的字节同样被转发给目标
+	conn, reader, status := connect(t, base, target, "early ")
+	if status != "HTTP/1.1 200 Connection Established" {
+		t.Fatalf("status line = %q", status)
+	}
+	if line, _ := reader.ReadString('\n'); line != "\r\n" {
+		t.Fatalf("2xx response to CONNECT has headers: %q", line)
+	}
+	io.WriteString(conn, "ping")
+	got := make([]byte, len("EARLY PING"))
+	if _, err := io.ReadFull(reader, got); err != nil || string(got) != "EARLY PING" {
+		t.Fatalf("tunnel echoed %q, %v", got, err)
+	}
+}
+
+func TestConnectProxyTargetLimits(t *testing.T) {
+	port := startUpstream(t)
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close() // 这个端口上没有服务器
+
+	r := NewRouter()
+	r.HandleFunc("CONNECT", "*", ConnectProxy(ConnectConfig{AllowedHosts: []string{"127.0.0.1", "*.example.com"}, AllowedPorts: []int{port, closedPort}}))
+	base := startRouter(t, r)
+
+	for target, want := range map[string]string{
+		"10.0.0.1:" + strconv.Itoa(port):        "403", // 主机不在允许的列表中
+		"127.0.0.1:22":                          "403", // 端口不在允许的列表中
+		"no-port":                               "400",
+		"127.0.0.1:" + strconv.Itoa(closedPort): "502",
+	} {
+		if _, _, status := connect(t, base, target, ""); !strings.HasPrefix(status, "HTTP/1.1 "+want) {
+			t.Errorf("CONNECT %s: %q, want %s", target, status, want)
+		}
+	}
+}
+
+func TestConnectConfigAllows(t *testing.T) {
+	config := ConnectConfig{AllowedHosts: []string{"Example.com.", "*.internal.test"}}
+	for _, tt := range []struct {
+		host, port string
+		want       bool
+	}{
+		{"example.com", "443", true}, // 忽略大小写和末尾的点
+		{"EXAMPLE.COM.", "443", true},
+		{"api.internal.test", "443", true},
+		{"a.b.internal.test", "443", true},
+		{"internal.test", "443", false}, // *.x 不匹配 x 本身
+		{"evilinternal.test", "443", false},
+		{"example.com", "80", false}, // 没有配置端口时只允许 443
+		{"example.com", "https", false},
+	} {
+		if got := config.allows(tt.host, tt.port); got != tt.want {
+			t.Errorf("allows(%q, %q) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+	if (ConnectConfig{AllowedPorts: []int{443}}).allows("example.com", "443") {
+		t.Error("empty AllowedHosts allowed a target")
+	}
+}
@@ -2,51 +2,108 @@
 package router
 
 import (
-	"github.com/lvkeliang/httpws/context"
+	"context"
+	"crypto/tls"
 	"github.com/lvkeliang/httpws/server"
-	"io"
 	"log"
-	"net"
 	"strings"
+	"sync"
 )
 
 type HandlerFunc func(c server.Conn)
 
 type Router struct {
-	rules map[string]HandlerFunc
+	rules    map[string]HandlerFunc
+	params   map[string]*node // 每个请求方法的带参数路由规则，例如 "/users/:id"
+	notFound HandlerFunc      // 没有匹配的路由规则时调用的处理器，为 nil 时回复 404
+
+	mu       sync.Mutex       // 保护下面的服务器列表
+	servers  []*server.Server // ListenAndServe 系列方法启动的服务器，Shutdown 会关闭它们
+	shutdown bool             // 已经调用了 Shutdown，之后启动的服务器立即返回
 }
 
 func NewRouter() *Router {
 	return &Router{
-		rules: make(map[string]HandlerFunc),
+		rules:  make(map[string]HandlerFunc),
+		params: make(map[string]*node),
 	}
 }
 
 type Middleware func(HandlerFunc) HandlerFunc
 
+// ErrHandlerFunc 是返回错误的处理器，通过 HandleError 转换为中间件后注册
+type ErrHandlerFunc func(c *server.Conn) error
+
+// HandleError 函数将一个返回错误的处理器转换为中间件：处理器返回错误时调用 Conn.WriteError 写入错误响应，否则继续调用下一个中间件。
+// 例如 r.HandleFunc("GET", "/users", router.HandleError(getUsers))
+func HandleError(h ErrHandlerFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c server.Conn) {
+			if err := h(&c); err != nil {
+				c.WriteError(err)
+				return
+			}
+			next(c)
+		}
+	}
+}
+
 // HandleFunc 方法用于添加新的路由规则，它接受一个模式字符串和一个处理器函数作为参数。
+// 模式中以冒号开头的路径段是参数，例如 "/users/:id" 匹配 "/users/42"，处理器通过 c.Param("id") 取得 "42"；
+// 同一个请求同时匹配静态路由和带参数的路由时，静态路由优先。
+// 没有单独注册 HEAD 的路径上，HEAD 请求由 GET 的处理器处理，响应保留相同的头部和 Content-Length，但不发送主体。
+// CONNECT 请求的目标是 "host:port" 而不是路径，模式可以是一个具体的目标，也可以是匹配任意目标的 "*"，参见 ConnectProxy。
 func (r *Router) HandleFunc(method string, pattern string, middlewares ...Middleware) {
 	handler := Chain(middlewares)
 	switch method {
-	case "GET":
-		r.rules[method+" "+pattern] = handler
-	case "POST":
-		r.rules[method+" "+pattern] = handler
-	case "PUT":
-		r.rules[method+" "+pattern] = handler
-	case "PATCH":
-		r.rules[method+" "+pattern] = handler
-	case "DELETE":
-		r.rules[method+" "+pattern] = handler
-	case "HEAD":
-		r.rules[method+" "+pattern] = handler
-	case "OPTIONS":
+	case "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS":
+		r.add(method, pattern, handler)
+	case "TRACE": // TRACE 默认被禁用，只有显式注册时才会处理，参见 TraceEcho
+		r.add(method, pattern, handler)
+	case "CONNECT": // CONNECT 同样只有显式注册时才会处理，避免服务器意外地成为代理
 		r.rules[method+" "+pattern] = handler
 	default:
 		log.Printf("method err: unsolved method \"%v\"\n", method)
 	}
 }
 
+// add 方法将一个路由规则添加到静态路由表或者带参数路由的前缀树中。
+func (r *Router) add(method, pattern string, handler HandlerFunc) {
+	if !strings.Contains(pattern, "/:") {
+		r.rules[method+" "+pattern] = handler
+		return
+	}
+	root, ok := r.params[method]
+	if !ok {
+		root = &node{}
+		r.params[method] = root
+	}
+	root.insert(pattern, handler)
+}
+
+// NotFound 方法用于设置没有匹配的路由规则时调用的中间件，例如 SPAFallback。
+func (r *Router) NotFound(middlewares ...Middleware) {
+	r.notFound = Chain(middlewares)
+}
+
+// Dispatch 方法把路由作为一个中间件使用，按照请求当前的方法和路径在 r 中查找处理器并调用它。
+// 它通常放在 middleware.StripPrefix 之后，用于把一个子路由挂载在某个前缀下。
+func (r *Router) Dispatch(next HandlerFunc) HandlerFunc {
+	return func(c server.Conn) {
+		r.Serve(&c)
+	}
+}
+
+// skipAborted 返回一个在请求已经被 Abort 时什么也不做的 next 处理器。
+func skipAborted(next HandlerFunc) HandlerFunc {
+	return func(c server.Conn) {
+		if c.IsAborted() {
+			return
+		}
+		next(c)
+	}
+}
+
 // Chain 函数用于将多个中间件函数组合在一起，它接受一组中间件函数作为参数，并返回一个新的中间件函数。
 // 当调用这个新的中间件函数时，它会依次调用所有传入的中间件函数，并将最终的处理器传递给最后一个中间件函数。
 func Chain(middlewares []Middleware) HandlerFunc {
@@ -58,8 +115,8 @@ func Chain(middlewares []Middleware) HandlerFunc {
 
 		// 逆序遍历 middlewares 切片。
 		for i := len(middlewares) - 1; i >= 0; i-- {
-			// 通过将最后的处理器应用于当前的中间件函数来更新它。
-			last = middlewares[i](last)
+			// 通过将最后的处理器应用于当前的中间件函数来更新它，中间件调用 c.Abort() 之后不再调用之后的处理器。
+			last = middlewares[i](skipAborted(last))
 		}
 
 		// 使用服务器连接调用最后的处理器。
@@ -67,51 +124,197 @@ func Chain(middlewares []Middleware) HandlerFunc {
 	}
 }
 
-// ListenAndServe 方法使用 net.Listen 函数监听指定的地址上的 TCP 连接，当接收到新的连接时，它会调用处理器的 Serve 方法来处理这个连接。
+// ListenAndServe 方法使用 server.Server 监听指定的地址上的 TCP 连接，当接收到新的连接时，它会调用路由的 Serve 方法来处理这个连接。
+// 调用 Shutdown 之后它会立即返回，这时正在处理的请求可能还没有完成，程序应该等待 Shutdown 返回后再退出。
 func (r *Router) ListenAndServe(addr string) {
-	c := new(server.Conn)
+	srv := &server.Server{Addr: addr, Handler: r}
+	r.run(srv, srv.ListenAndServe)
+}
 
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
+// ListenAndServeTLS 方法与 ListenAndServe 相同，但使用从 certFile 和 keyFile 中加载的证书通过 TLS 提供 HTTPS 服务。
+func (r *Router) ListenAndServeTLS(addr, certFile, keyFile string) {
+	srv := &server.Server{Addr: addr, Handler: r}
+	r.run(srv, func() error { return srv.ListenAndServeTLS(certFile, keyFile) })
+}
+
+// ListenAndServeTLSConfig 方法与 ListenAndServeTLS 相同，但使用调用者提供的 TLS 配置，例如定制的密码套件，证书也在其中配置。
+func (r *Router) ListenAndServeTLSConfig(addr string, config *tls.Config) {
+	srv := &server.Server{Addr: addr, Handler: r, TLSConfig: config}
+	r.run(srv, func() error { return srv.ListenAndServeTLS("", "") })
+}
+
+// run 记录 srv 以便 Shutdown 关闭它，然后调用 serve；Shutdown 之后正常返回，其他错误仍然使程序退出
+func (r *Router) run(srv *server.Server, serve func() error) {
+	r.mu.Lock()
+	if r.shutdown {
+		r.mu.Unlock()
+		return
+	}
+	r.servers = append(r.servers, srv)
+	r.mu.Unlock()
+
+	if err := serve(); err != server.ErrServerClosed {
 		log.Fatal(err)
 	}
-	defer listener.Close()
+}
 
-	for {
-		c.Conn, err = listener.Accept()
-		if err != nil {
-			log.Println("listener err: ", err)
-			continue
+// Shutdown 方法优雅地关闭 ListenAndServe 系列方法启动的所有服务器：停止接受新的连接，等待正在处理的请求完成。
+// 所有请求完成时返回 nil，ctx 先结束时返回 ctx.Err()。调用之后再调用 ListenAndServe 会立即返回。
+func (r *Router) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.shutdown = true
+	servers := r.servers
+	r.servers = nil
+	r.mu.Unlock()
+
+	var err error
+	for _, srv := range servers {
+		if serr := srv.Shutdown(ctx); serr != nil && err == nil {
+			err = serr
 		}
-		go func() {
-			req := make([]byte, 1024)
-			n, err := c.Conn.Read(req)
-			if err != nil {
-				if err != io.EOF {
-					log.Println("conn read err: ", err)
-				}
-				return
-			}
-			c.Message, err = context.NewContext(req[:n])
-			if err != nil {
-				log.Println("create new context err: ", err)
-				return
-			}
-			r.Serve(c)
-			c.Conn.Close()
-		}()
 	}
+	return err
 }
 
 // Serve 方法用于处理客户端连接，它会根据请求的 URL 路径查找对应的处理器，并调用它来处理请求。
 func (r *Router) Serve(c *server.Conn) {
 
-	// 获取请求方法和路径，并按照请求的方法和路径调用中间件
-	lsatInd := strings.LastIndex(c.Message.StartLine, " ")
-	handler, ok := r.rules[c.Message.StartLine[:lsatInd]]
+	// 获取请求方法和路径（不包含查询字符串），并按照请求的方法和路径调用中间件
+	handler, pattern, ok := r.lookup(c, c.Message.Method())
+	if !ok && c.Message.Method() == "CONNECT" {
+		pattern = "*"
+		handler, ok = r.rules["CONNECT *"]
+	}
+	if !ok && c.Message.Method() == "HEAD" { // 没有单独注册 HEAD 时使用 GET 的处理器，服务器只发送它的响应头部
+		handler, pattern, ok = r.lookup(c, "GET")
+	}
 	if !ok {
+		switch c.Message.Method() {
+		// 没有注册 TRACE 处理器时拒绝，避免跨站追踪（XST）泄露 Cookie 等敏感头部；没有注册 CONNECT 处理器时同样拒绝，不交给 NotFound
+		case "TRACE", "CONNECT":
+			c.WriteResponse(405, "Method Not Allowed", []byte("Method Not Allowed"), map[string]string{"Allow": strings.Join(r.allowed(c.Message.Path()), ", ")})
+			return
+		}
+		if r.notFound != nil {
+			r.notFound(*c)
+			return
+		}
 		c.WriteResponse(404, "404 Not Found", []byte("Not Found"))
 		return
 	}
+	c.SetRoutePattern(pattern)
 	handler(*c)
 }
+
+// allowed 方法返回 path 上注册了处理器的请求方法，用于 405 响应的 Allow 头部；注册了 GET 时也包括由它处理的 HEAD。
+func (r *Router) allowed(path string) []string {
+	registered := func(method string) bool {
+		if _, ok := r.rules[method+" "+path]; ok {
+			return true
+		}
+		root, ok := r.params[method]
+		if !ok {
+			return false
+		}
+		n, _ := root.match(splitPath(path), nil)
+		return n != nil
+	}
+
+	var methods []string
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "TRACE"} {
+		if registered(method) || (method == "HEAD" && registered("GET")) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// lookup 方法按照 method 和请求的路径依次在静态路由和带参数的路由中查找处理器和它的路由模式。
+func (r *Router) lookup(c *server.Conn, method string) (HandlerFunc, string, bool) {
+	pattern := c.Message.Path()
+	if handler, ok := r.rules[method+" "+pattern]; ok {
+		return handler, pattern, true
+	}
+	return r.matchParams(c, method)
+}
+
+// matchParams 方法在带参数的路由规则中查找与 method 和请求的路径匹配的处理器和它的路由模式，找到时将捕获的参数保存到 c 中。
+func (r *Router) matchParams(c *server.Conn, method string) (HandlerFunc, string, bool) {
+	root, ok := r.params[method]
+	if !ok {
+		return nil, "", false
+	}
+	n, values := root.match(splitPath(c.Message.Path()), nil)
+	if n == nil {
+		return nil, "", false
+	}
+	params := make(map[string]string, len(values))
+	for i, name := range n.paramNames {
+		params[name] = values[i]
+	}
+	c.SetParams(params)
+	return n.handler, n.pattern, true
+}
+
+// node 是带参数路由的前缀树的一个节点，每一层对应路径中的一个段。
+// 同一层的参数段共用一个 param 节点，参数的名称属于各自的路由模式，例如 "/users/:id" 和 "/users/:uid/posts" 可以同时注册。
+type node struct {
+	static     map[string]*node // 静态的子路径段
+	param      *node            // 参数子路径段，例如 ":id"
+	handler    HandlerFunc      // 路径在这个节点结束时的处理器
+	pattern    string           // handler 对应的路由模式，例如 "/users/:id"
+	paramNames []string         // pattern 中参数的名称，按照在路径中出现的顺序
+}
+
+// insert 方法沿着 pattern 的路径段创建节点，并在最后一个节点上设置处理器和参数的名称。
+func (n *node) insert(pattern string, handler HandlerFunc) {
+	var names []string
+	for _, seg := range splitPath(pattern) {
+		if strings.HasPrefix(seg, ":") {
+			if n.param == nil {
+				n.param = &node{}
+			}
+			names = append(names, seg[1:])
+			n = n.param
+			continue
+		}
+		if n.static == nil {
+			n.static = make(map[string]*node)
+		}
+		child, ok := n.static[seg]
+		if !ok {
+			child = &node{}
+			n.static[seg] = child
+		}
+		n = child
+	}
+	n.handler, n.pattern, n.paramNames = handler, pattern, names
+}
+
+// match 方法查找与 segments 匹配的、带有处理器的节点，每一层先尝试静态路径段，匹配失败时再尝试参数，
+// 返回节点和按顺序捕获的参数值，它们和节点的 paramNames 一一对应。
+func (n *node) match(segments []string, values []string) (*node, []string) {
+	if len(segments) == 0 {
+		if n.handler == nil {
+			return nil, nil
+		}
+		return n, values
+	}
+	seg := segments[0]
+	if child, ok := n.static[seg]; ok {
+		if m, v := child.match(segments[1:], values); m != nil {
+			return m, v
+		}
+	}
+	if n.param != nil && seg != "" {
+		if m, v := n.param.match(segments[1:], append(values, seg)); m != nil {
+			return m, v
+		}
+	}
+	return nil, nil
+}
+
+// splitPath 函数将路径按照 "/" 分割成路径段，忽略开头的 "/"。
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
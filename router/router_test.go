@@ -0,0 +1,107 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// startRouter 在本地的随机端口上用 r 运行一个服务器，返回它的地址，测试结束时关闭服务器
+func startRouter(t *testing.T, r *Router) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server.Server{Handler: r}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + ln.Addr().String()
+}
+
+// get 发送一个 GET 请求，返回响应的主体
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// reply 返回一个用 body(c) 作为响应主体的处理器
+func reply(body func(c server.Conn) string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c server.Conn) {
+			c.WriteResponse(200, "OK", []byte(body(c)))
+		}
+	}
+}
+
+func TestStaticRouteBeatsParam(t *testing.T) {
+	r := NewRouter()
+	r.HandleFunc("GET", "/users/:id", reply(func(c server.Conn) string { return "param " + c.Param("id") }))
+	r.HandleFunc("GET", "/users/me", reply(func(c server.Conn) string { return "static" }))
+	r.HandleFunc("GET", "/files/new/edit", reply(func(c server.Conn) string { return "static edit" }))
+	r.HandleFunc("GET", "/files/:name/view", reply(func(c server.Conn) string { return "view " + c.Param("name") }))
+	base := startRouter(t, r)
+
+	for path, want := range map[string]string{
+		"/users/me":       "static",
+		"/users/42":       "param 42",
+		"/files/new/edit": "static edit",
+		"/files/new/view": "view new", // 静态段之后的路径不匹配时回到参数
+		"/users/":         "Not Found",
+		"/users/42/extra": "Not Found",
+	} {
+		if got := get(t, base+path); got != want {
+			t.Errorf("GET %s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParamNamesPerPattern(t *testing.T) {
+	r := NewRouter()
+	r.HandleFunc("GET", "/users/:id", reply(func(c server.Conn) string { return "id=" + c.Param("id") + " uid=" + c.Param("uid") }))
+	r.HandleFunc("GET", "/users/:uid/posts", reply(func(c server.Conn) string { return "uid=" + c.Param("uid") + " id=" + c.Param("id") }))
+	r.HandleFunc("GET", "/users/:uid/posts/:post", reply(func(c server.Conn) string { return c.Param("uid") + "/" + c.Param("post") }))
+	base := startRouter(t, r)
+
+	for path, want := range map[string]string{
+		"/users/42":         "id=42 uid=",
+		"/users/42/posts":   "uid=42 id=",
+		"/users/42/posts/7": "42/7",
+	} {
+		if got := get(t, base+path); got != want {
+			t.Errorf("GET %s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	pattern := reply(func(c server.Conn) string { return "pattern=" + c.RoutePattern() })
+	r := NewRouter()
+	r.HandleFunc("GET", "/users/:id", pattern)
+	r.HandleFunc("GET", "/users/:uid/posts/:post", pattern)
+	r.HandleFunc("GET", "/health", pattern)
+	r.NotFound(pattern)
+	base := startRouter(t, r)
+
+	for path, want := range map[string]string{
+		"/users/42":         "pattern=/users/:id",
+		"/users/42/posts/7": "pattern=/users/:uid/posts/:post",
+		"/health":           "pattern=/health",
+		"/missing":          "pattern=", // 没有匹配任何路由
+	} {
+		if got := get(t, base+path); got != want {
+			t.Errorf("GET %s = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SPAFallback 返回一个用于单页应用的中间件，通常通过 Router.NotFound 注册，这样已经注册的路由（例如 /api）总是优先匹配。
+// 请求的路径在 dir 中存在对应的文件时直接返回这个文件；路径看起来像静态资源（带有扩展名，例如 /missing.js）但文件不存在时回复 404；
+// 其他路径都返回 dir 中的 indexFile，交给前端路由处理。
+func SPAFallback(dir, indexFile string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c server.Conn) {
+			method := c.Message.Method()
+			if method != "GET" && method != "HEAD" {
+				c.WriteResponse(404, "404 Not Found", []byte("Not Found"))
+				return
+			}
+
+			// 先清理路径，防止通过 ".." 访问 dir 之外的文件
+			urlPath := path.Clean("/" + c.Message.Path())
+			name := filepath.Join(dir, filepath.FromSlash(urlPath))
+
+			if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+				serveFile(&c, name)
+				return
+			}
+
+			if path.Ext(urlPath) != "" { // 缺失的静态资源，不能返回 index.html
+				c.WriteResponse(404, "404 Not Found", []byte("Not Found"))
+				return
+			}
+
+			serveFile(&c, filepath.Join(dir, indexFile))
+		}
+	}
+}
+
+// serveFile 读取文件并将它作为响应写入
+func serveFile(c *server.Conn, name string) {
+	body, err := os.ReadFile(name)
+	if err != nil {
+		c.WriteResponse(404, "404 Not Found", []byte("Not Found"))
+		return
+	}
+
+	// 内容类型先根据扩展名确定（包括 server.RegisterMIMEType 注册的类型），找不到时由 WriteResponse 根据内容检测
+	// 文件响应总是支持范围请求，带有 Accept-Ranges: bytes 头部
+	contentType := server.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		c.WriteContent(body)
+		return
+	}
+	c.WriteContent(body, map[string]string{"Content-Type": contentType})
+}
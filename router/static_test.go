@@ -0,0 +1,86 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startStatic 在 dir 上运行一个用 SPAFallback 提供文件的服务器，返回它的地址
+func startStatic(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewRouter()
+	r.NotFound(SPAFallback(dir, "index.html"))
+	return startRouter(t, r)
+}
+
+// fetch 发送一个带有 headers 的 GET 请求，返回响应和它的主体
+func fetch(t *testing.T, url string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestServeFileRegisteredMIMEType(t *testing.T) {
+	server.RegisterMIMEType("HwsTest", "application/x-httpws-test")
+	base := startStatic(t, map[string]string{"index.html": "<html></html>", "data.hwstest": "payload"})
+
+	for path, want := range map[string]string{
+		"/data.hwstest": "application/x-httpws-test", // 注册时的扩展名不区分大小写，也可以不带点
+		"/index.html":   "text/html; charset=utf-8",
+	} {
+		if resp, _ := fetch(t, base+path, nil); resp.StatusCode != 200 || resp.Header.Get("Content-Type") != want {
+			t.Errorf("GET %s: %d Content-Type = %q, want %q", path, resp.StatusCode, resp.Header.Get("Content-Type"), want)
+		}
+	}
+}
+
+func TestServeFileAcceptRanges(t *testing.T) {
+	base := startStatic(t, map[string]string{"index.html": "<html></html>", "file.txt": "0123456789"})
+
+	for _, tt := range []struct {
+		rangeHeader, body, contentRange string
+		code                            int
+	}{
+		{"", "0123456789", "", 200},
+		{"bytes=2-5", "2345", "bytes 2-5/10", 206},
+		{"bytes=-3", "789", "bytes 7-9/10", 206},
+		{"bytes=20-", "", "bytes */10", 416},
+		{"items=0-1", "0123456789", "", 200}, // 无法识别的单位被忽略
+	} {
+		headers := map[string]string{}
+		if tt.rangeHeader != "" {
+			headers["Range"] = tt.rangeHeader
+		}
+		resp, body := fetch(t, base+"/file.txt", headers)
+		if resp.StatusCode != tt.code || body != tt.body || resp.Header.Get("Content-Range") != tt.contentRange {
+			t.Errorf("Range %q: %d %q (Content-Range %q), want %d %q (%q)", tt.rangeHeader, resp.StatusCode, body, resp.Header.Get("Content-Range"), tt.code, tt.body, tt.contentRange)
+		}
+		if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("Range %q: Accept-Ranges = %q, want bytes", tt.rangeHeader, got)
+		}
+	}
+
+	// SPA 回退返回的 index.html 同样是文件响应
+	if resp, _ := fetch(t, base+"/some/route", nil); resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("fallback Accept-Ranges = %q, want bytes", resp.Header.Get("Accept-Ranges"))
+	}
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"sort"
+	"strings"
+)
+
+// traceSensitiveHeaders 是 TraceEcho 不会回显的头部，它们可能携带凭证
+var traceSensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+}
+
+// TraceEcho 是一个回显 TRACE 请求的中间件，按照 RFC 9110 以 message/http 类型返回收到的请求行和头部。
+// TRACE 默认是禁用的：如果页面中的脚本能让浏览器发出 TRACE 请求，就能通过回显读到 HttpOnly 的 Cookie（跨站追踪，XST），
+// 所以只应在诊断需要时注册，例如 r.HandleFunc("TRACE", "/", router.TraceEcho)。即使注册了，Cookie 和认证头部也不会被回显。
+func TraceEcho(next HandlerFunc) HandlerFunc {
+	return func(c server.Conn) {
+		names := make([]string, 0, len(c.Message.Headers))
+		for name := range c.Message.Headers {
+			if !traceSensitiveHeaders[strings.ToLower(name)] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		var b strings.Builder
+		b.WriteString(c.Message.StartLine + "\r\n")
+		for _, name := range names {
+			for _, value := range c.Message.HeaderValues(name) {
+				b.WriteString(name + ": " + value + "\r\n")
+			}
+		}
+		b.WriteString("\r\n")
+
+		c.WriteResponse(200, "OK", []byte(b.String()), map[string]string{"Content-Type": "message/http"})
+		next(c)
+	}
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// trace 发送一个带有 Cookie 的 TRACE 请求，返回响应和它的主体
+func trace(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest("TRACE", url, nil)
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Probe", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestTraceDisabledByDefault(t *testing.T) {
+	r := NewRouter()
+	r.HandleFunc("GET", "/items/:id", reply(func(c server.Conn) string { return "item" }))
+	r.HandleFunc("POST", "/items/:id", reply(func(c server.Conn) string { return "saved" }))
+	r.NotFound(reply(func(c server.Conn) string { return "not found handler" }))
+	base := startRouter(t, r)
+
+	resp, body := trace(t, base+"/items/1")
+	if resp.StatusCode != 405 || strings.Contains(body, "secret") {
+		t.Fatalf("TRACE = %d %q, want 405 without an echo", resp.StatusCode, body)
+	}
+	if allow := resp.Header.Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Fatalf("Allow = %q, want %q", allow, "GET, HEAD, POST")
+	}
+
+	// 没有任何路由的路径同样回复 405，而不是交给 NotFound
+	if resp, _ := trace(t, base+"/missing"); resp.StatusCode != 405 {
+		t.Fatalf("TRACE /missing = %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestTraceOptIn(t *testing.T) {
+	r := NewRouter()
+	r.HandleFunc("TRACE", "/", TraceEcho)
+	base := startRouter(t, r)
+
+	resp, body := trace(t, base+"/")
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "message/http" {
+		t.Fatalf("TRACE = %d (%s)", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, "TRACE / HTTP/1.1\r\n") || !strings.Contains(body, "X-Probe: 1\r\n") {
+		t.Fatalf("echo = %q", body)
+	}
+	if strings.Contains(body, "secret") {
+		t.Fatalf("echo leaked the Cookie header: %q", body)
+	}
+}
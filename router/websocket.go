@@ -0,0 +1,52 @@
+package router
+
+import (
+	"github.com/lvkeliang/httpws/server"
+	"log"
+	"strings"
+)
+
+// WebSocketConfig 是 Router.WebSocket 的配置
+type WebSocketConfig struct {
+	// Protocols 是服务器支持的子协议，按照 UpgradeToWebSocketWithProtocols 的规则协商，为空时不协商
+	Protocols []string
+
+	// AllowedOrigins 是允许发起握手的页面来源，例如 "https://example.com"，"*" 表示任意来源，不区分大小写；
+	// 为空时不检查。浏览器总是发送 Origin 头部，设置它可以防止其他网站的页面借用用户的 Cookie 建立连接（跨站WebSocket劫持），
+	// 没有 Origin 头部的请求来自非浏览器客户端，不受限制。来源不允许时回复 403
+	AllowedOrigins []string
+}
+
+// WebSocket 方法注册一个WebSocket端点：GET 请求到达 pattern 时先检查来源并完成握手，握手成功后才调用 handler，
+// handler 可以直接读写WebSocket消息，返回时连接被关闭。握手失败时已经回复了对应的错误响应（400、403、426 或 503），不会调用 handler。
+// 例如 r.WebSocket("/ws", echo, router.WebSocketConfig{Protocols: []string{"chat"}, AllowedOrigins: []string{"https://example.com"}})
+func (r *Router) WebSocket(pattern string, handler func(c *server.Conn), config ...WebSocketConfig) {
+	var cfg WebSocketConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	r.add("GET", pattern, func(c server.Conn) {
+		if origin := c.Message.Header("Origin"); origin != "" && !cfg.allowsOrigin(origin) {
+			c.WriteResponse(403, "Forbidden", []byte("Forbidden"))
+			return
+		}
+		if err := c.UpgradeToWebSocketWithProtocols(cfg.Protocols...); err != nil {
+			log.Println("websocket upgrade err: ", err)
+			return
+		}
+		handler(&c)
+	})
+}
+
+// allowsOrigin 判断 origin 是否在 AllowedOrigins 中，AllowedOrigins 为空时允许任意来源
+func (cfg WebSocketConfig) allowsOrigin(origin string) bool {
+	if len(cfg.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"bufio"
+	"github.com/lvkeliang/httpws/server"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsHandshake 发送一个带有 extra 头部的握手请求，返回连接、读取器和响应
+func wsHandshake(t *testing.T, base, extra string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(base, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: x\r\n"+extra+"\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 101 {
+		io.ReadAll(resp.Body)
+	}
+	return conn, reader, resp
+}
+
+const validHandshake = "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+
+func TestWebSocketRoute(t *testing.T) {
+	called := make(chan struct{}, 4)
+	r := NewRouter()
+	r.WebSocket("/ws", func(c *server.Conn) {
+		called <- struct{}{}
+		op, payload, err := c.ReadWebSocketMessage()
+		if err == nil {
+			c.WriteWebSocketMessage(op, append([]byte("echo "), payload...))
+		}
+	}, WebSocketConfig{Protocols: []string{"chat"}, AllowedOrigins: []string{"https://example.com"}})
+	base := startRouter(t, r)
+
+	// 合法的握手：协商子协议后调用处理器
+	conn, reader, resp := wsHandshake(t, base, validHandshake+"Origin: https://EXAMPLE.com\r\nSec-WebSocket-Protocol: other, chat\r\n")
+	if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" || resp.Header.Get("Sec-WebSocket-Protocol") != "chat" {
+		t.Fatalf("handshake = %d %v", resp.StatusCode, resp.Header)
+	}
+	conn.Write(server.BuildFrame(true, server.WebSocketFrameOpCodeText, true, []byte("hi")))
+	header := make([]byte, 2)
+	io.ReadFull(reader, header)
+	payload := make([]byte, header[1]&0x7f)
+	io.ReadFull(reader, payload)
+	if string(payload) != "echo hi" {
+		t.Fatalf("echo = %q", payload)
+	}
+	<-called
+
+	// 不合法的握手不会调用处理器
+	for _, tt := range []struct {
+		name, extra string
+		status      int
+	}{
+		{"plain GET", "", 400},
+		{"missing key", "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n", 400},
+		{"old version", "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n", 426},
+		{"foreign origin", validHandshake + "Origin: https://evil.example\r\n", 403},
+	} {
+		if _, _, resp := wsHandshake(t, base, tt.extra); resp.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+	select {
+	case <-called:
+		t.Fatal("handler called for an invalid handshake")
+	default:
+	}
+}
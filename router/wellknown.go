@@ -0,0 +1,49 @@
+package router
+
+import (
+	"bytes"
+	"github.com/lvkeliang/httpws/server"
+)
+
+// wellKnownCacheControl 是 favicon 和 robots.txt 的缓存策略，它们很少变化，浏览器和爬虫可以缓存一天
+const wellKnownCacheControl = "public, max-age=86400"
+
+// Favicon 方法为 GET /favicon.ico 注册一个返回 data 的处理器，内容类型根据数据检测（PNG、SVG 或 ICO）；
+// data 为空时回复 204 No Content，告诉浏览器没有图标，同样带有缓存头部，避免浏览器反复请求产生 404 日志。
+func (r *Router) Favicon(data []byte) {
+	r.HandleFunc("GET", "/favicon.ico", func(next HandlerFunc) HandlerFunc {
+		return func(c server.Conn) {
+			if len(data) == 0 {
+				c.WriteResponse(204, "No Content", nil, map[string]string{"Cache-Control": wellKnownCacheControl})
+				return
+			}
+			c.WriteResponse(200, "OK", data, map[string]string{
+				"Content-Type":  faviconType(data),
+				"Cache-Control": wellKnownCacheControl,
+			})
+		}
+	})
+}
+
+// Robots 方法为 GET /robots.txt 注册一个返回 content 的处理器，例如 "User-agent: *\nDisallow: /admin/\n"。
+func (r *Router) Robots(content string) {
+	r.HandleFunc("GET", "/robots.txt", func(next HandlerFunc) HandlerFunc {
+		return func(c server.Conn) {
+			c.WriteResponse(200, "OK", []byte(content), map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Cache-Control": wellKnownCacheControl,
+			})
+		}
+	})
+}
+
+// faviconType 根据图标数据的开头判断它的内容类型
+func faviconType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")): // <svg 或者 <?xml
+		return "image/svg+xml"
+	}
+	return "image/x-icon"
+}
@@ -0,0 +1,40 @@
+package router
+
+import "testing"
+
+func TestFaviconAndRobots(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		favicon     string
+		status      int
+		contentType string
+	}{
+		{"png", "\x89PNG\r\n\x1a\n....", 200, "image/png"},
+		{"svg", "  <svg xmlns=\"http://www.w3.org/2000/svg\"></svg>", 200, "image/svg+xml"},
+		{"ico", "\x00\x00\x01\x00....", 200, "image/x-icon"},
+		{"empty", "", 204, ""}, // 没有图标时回复 204，同样可以被缓存
+	} {
+		r := NewRouter()
+		r.Favicon([]byte(tt.favicon))
+		resp, body := fetch(t, startRouter(t, r)+"/favicon.ico", nil)
+		if resp.StatusCode != tt.status || body != tt.favicon {
+			t.Errorf("%s: %d %q, want %d", tt.name, resp.StatusCode, body, tt.status)
+		}
+		if tt.contentType != "" && resp.Header.Get("Content-Type") != tt.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.name, resp.Header.Get("Content-Type"), tt.contentType)
+		}
+		if resp.Header.Get("Cache-Control") != wellKnownCacheControl {
+			t.Errorf("%s: Cache-Control = %q", tt.name, resp.Header.Get("Cache-Control"))
+		}
+	}
+
+	r := NewRouter()
+	r.Robots("User-agent: *\nDisallow: /admin/\n")
+	resp, body := fetch(t, startRouter(t, r)+"/robots.txt", nil)
+	if resp.StatusCode != 200 || body != "User-agent: *\nDisallow: /admin/\n" {
+		t.Fatalf("robots.txt = %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" || resp.Header.Get("Cache-Control") != wellKnownCacheControl {
+		t.Fatalf("robots.txt headers = %v", resp.Header)
+	}
+}
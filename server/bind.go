@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Validatable 由需要在 Bind 解码后校验自身的类型实现，返回 ValidationErrors 时可以携带多个字段错误
+type Validatable interface {
+	Validate() error
+}
+
+// FieldError 描述一个字段的校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors 是多个字段错误的集合
+type ValidationErrors []FieldError
+
+// Error 实现 error 接口，将所有字段错误连接成一个字符串
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		if fe.Field == "" {
+			msgs = append(msgs, fe.Message)
+			continue
+		}
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	// ErrNotJSON 表示请求的内容类型不是 JSON
+	ErrNotJSON = errors.New("content type is not JSON")
+
+	// ErrEmptyJSONBody 表示请求没有主体，无法解码 JSON
+	ErrEmptyJSONBody = errors.New("empty JSON body")
+)
+
+// WriteJSON 将 v 编码为 JSON，以 Content-Type: application/json 写入状态码为 statusCode 的响应，编码失败时不写入任何内容并返回错误
+func (c *Conn) WriteJSON(statusCode int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteResponse(statusCode, StatusText(statusCode), body, map[string]string{"Content-Type": "application/json"})
+}
+
+// BindJSON 将 JSON 请求主体解码到 v 中，不会写入响应，由处理器决定如何回复：
+// 设置了不是 JSON 的内容类型时返回 ErrNotJSON，没有主体时返回 ErrEmptyJSONBody，
+// 语法错误和类型不匹配时返回的错误中带有出错的位置或字段，例如 "invalid JSON at offset 12: ..."
+func (c *Conn) BindJSON(v interface{}) error {
+	if contentType := c.Message.Header("Content-Type"); contentType != "" && !strings.Contains(contentType, "json") {
+		return ErrNotJSON
+	}
+
+	body, err := c.Message.ReadBody()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ErrEmptyJSONBody
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return fmt.Errorf("invalid JSON at offset %d: %w", syntaxErr.Offset, err)
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return fmt.Errorf("invalid JSON value for field %q: %w", typeErr.Field, err)
+		}
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return nil
+}
+
+// Bind 将 JSON 请求主体解码到 v 中，如果 v 实现了 Validatable，解码后调用它的 Validate 方法
+// 失败时 Bind 会直接写入错误响应并返回错误，处理器只需要在出错时返回：
+// 内容类型不是 JSON 时写入 415 并返回 ErrNotJSON，主体无法解码时写入 400，校验失败时写入 422 和结构化的字段错误；
+// 解码失败时返回的错误和 BindJSON 相同，可以用 errors.Is 判断
+func (c *Conn) Bind(v interface{}) error {
+	if err := c.BindJSON(v); err != nil {
+		if err == ErrNotJSON {
+			c.WriteResponse(415, "Unsupported Media Type", []byte("Unsupported Media Type"))
+			return err
+		}
+		c.WriteResponse(400, "Bad Request", []byte("Bad Request"))
+		return err
+	}
+
+	validatable, ok := v.(Validatable)
+	if !ok {
+		return nil
+	}
+	if err := validatable.Validate(); err != nil {
+		fieldErrs, ok := err.(ValidationErrors)
+		if !ok { // 普通的错误作为一个没有字段名的错误返回
+			fieldErrs = ValidationErrors{{Message: err.Error()}}
+		}
+		resp, _ := json.Marshal(map[string]interface{}{"errors": fieldErrs})
+		c.WriteResponse(422, "Unprocessable Entity", resp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// post 发送一个带有 contentType 和 body 的 POST 请求，返回响应的状态码、Content-Type 和主体
+func post(t *testing.T, addr, contentType, body string) (int, string, string) {
+	t.Helper()
+	resp, err := http.Post("http://"+addr+"/", contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(data)
+}
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestBindJSON(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		var u user
+		if err := c.BindJSON(&u); err != nil {
+			c.WriteResponse(400, "Bad Request", []byte(err.Error()))
+			return
+		}
+		c.WriteJSON(201, u)
+	})})
+
+	for _, tt := range []struct {
+		name, contentType, body string
+		code                    int
+		want                    string // 期望的主体，或者错误信息的前缀
+	}{
+		{"valid", "application/json", `{"name":"ann","age":3}`, 201, `{"name":"ann","age":3}`},
+		{"no content type", "", `{"name":"bob"}`, 201, `{"name":"bob","age":0}`},
+		{"empty body", "application/json", "", 400, ErrEmptyJSONBody.Error()},
+		{"whitespace body", "application/json", " \r\n\t", 400, ErrEmptyJSONBody.Error()},
+		{"syntax error", "application/json", `{"name":"ann",}`, 400, "invalid JSON at offset 15"},
+		{"truncated", "application/json", `{"name":`, 400, "invalid JSON"},
+		{"wrong type", "application/json", `{"age":"three"}`, 400, `invalid JSON value for field "age"`},
+		{"not JSON", "text/plain", `{"name":"ann"}`, 400, ErrNotJSON.Error()},
+	} {
+		code, contentType, body := post(t, addr, tt.contentType, tt.body)
+		if code != tt.code || !strings.HasPrefix(body, tt.want) {
+			t.Errorf("%s: response = %d %q, want %d %q", tt.name, code, body, tt.code, tt.want)
+		}
+		if code == 201 && contentType != "application/json" {
+			t.Errorf("%s: WriteJSON Content-Type = %q", tt.name, contentType)
+		}
+	}
+}
+
+func TestBindStatus(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		var u user
+		if c.Bind(&u) == nil {
+			c.WriteJSON(200, u)
+		}
+	})})
+
+	for _, tt := range []struct {
+		name, contentType, body string
+		code                    int
+	}{
+		{"valid", "application/json", `{"name":"ann"}`, 200},
+		{"empty body", "application/json", "", 400},
+		{"syntax error", "application/json", `{"name"}`, 400},
+		{"not JSON", "application/x-www-form-urlencoded", "name=ann", 415},
+	} {
+		if code, _, body := post(t, addr, tt.contentType, tt.body); code != tt.code {
+			t.Errorf("%s: status = %d %q, want %d", tt.name, code, body, tt.code)
+		}
+	}
+}
+
+func TestWriteJSONMarshalError(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		err := c.WriteJSON(200, map[string]interface{}{"ch": make(chan int)})
+		errs <- err
+		c.WriteResponse(500, "Internal Server Error", []byte("fallback"))
+	})})
+
+	// 编码失败时 WriteJSON 不写入任何内容，处理器仍然可以写入自己的响应
+	if code, _, body := post(t, addr, "application/json", "{}"); code != 500 || body != "fallback" {
+		t.Fatalf("response = %d %q", code, body)
+	}
+	if err := <-errs; err == nil {
+		t.Fatal("WriteJSON() of a channel succeeded")
+	}
+}
+
+func TestBindNotJSONError(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		var u user
+		errs <- c.Bind(&u)
+	})})
+
+	if code, _, _ := post(t, addr, "text/plain", "name=ann"); code != 415 {
+		t.Fatalf("status = %d, want 415", code)
+	}
+	err := <-errs
+	if !errors.Is(err, ErrNotJSON) {
+		t.Fatalf("Bind() err = %v, want ErrNotJSON", err)
+	}
+	if code := errorStatus(err); code != 415 { // 交给 WriteError 时同样映射为 415
+		t.Fatalf("errorStatus(ErrNotJSON) = %d, want 415", code)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errWriterClosed 表示 BufferedWriter 已经关闭
+var errWriterClosed = errors.New("buffered writer already closed")
+
+// BufferedWriter 逐步写入一个响应主体，它先缓冲写入的内容：
+// 关闭时主体不超过阈值就作为一个带 Content-Length 的普通响应写入（对缓存和代理更友好），
+// 写入的内容超过阈值后立即写出响应头部，改用 Transfer-Encoding: chunked 流式写入之后的内容。
+// 在第一次超过阈值或者关闭之前可以修改 Status、StatusText 和 Headers。处理器必须调用 Close 结束响应。
+type BufferedWriter struct {
+	Status     int               // 状态码，默认为 200
+	StatusText string            // 原因短语，为空时使用状态码对应的原因短语
+	Headers    map[string]string // 响应的其他头部
+
+	c         *Conn
+	threshold int
+	buf       bytes.Buffer
+	chunked   bool // 已经超过阈值，切换到分块传输
+	closed    bool
+}
+
+// BufferedWriter 返回一个在 threshold 个字节以内缓冲响应主体、超过后切换到分块传输的写入器
+func (c *Conn) BufferedWriter(threshold int) *BufferedWriter {
+	return &BufferedWriter{Status: 200, c: c, threshold: threshold}
+}
+
+// Write 写入响应主体的一部分
+func (w *BufferedWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	if w.chunked {
+		return len(p), w.c.writeChunked(false, w.Status, w.statusText(), w.Headers, p, false, nil)
+	}
+	if w.buf.Len()+len(p) <= w.threshold || !bodyAllowed(w.Status) { // 不能有主体的响应在关闭时由 WriteResponse 丢弃主体
+		return w.buf.Write(p)
+	}
+
+	// 超过阈值，将头部和已经缓冲的内容作为第一个块一起写出；写出失败时仍然没有发送头部，之后的写入不能只发送块
+	buffered := w.buf.Len()
+	w.buf.Write(p)
+	if err := w.c.writeChunked(true, w.Status, w.statusText(), w.Headers, w.buf.Bytes(), false, nil); err != nil {
+		w.buf.Truncate(buffered)
+		return 0, err
+	}
+	w.chunked = true
+	w.buf.Reset()
+	return len(p), nil
+}
+
+// Close 结束响应：没有超过阈值时写入带 Content-Length 的完整响应，否则写入最后一个空块
+func (w *BufferedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.chunked {
+		return w.c.writeChunked(false, w.Status, w.statusText(), w.Headers, nil, true, nil)
+	}
+	return w.c.WriteResponse(w.Status, w.statusText(), w.buf.Bytes(), w.Headers)
+}
+
+// Chunked 返回写入器是否已经切换到分块传输
+func (w *BufferedWriter) Chunked() bool {
+	return w.chunked
+}
+
+func (w *BufferedWriter) statusText() string {
+	if w.StatusText == "" {
+		return StatusText(w.Status)
+	}
+	return w.StatusText
+}
+
+// WriteResponseStream 写入状态行和带有 Transfer-Encoding: chunked 的头部，返回一个写入响应主体的写入器，
+// 每次 Write 都作为一个块立即发送，Close 发送最后的空块结束响应，适合长度事先未知的响应（例如流式输出的日志）。
+// 没有在 headers 中指定 Content-Type 时使用 text/plain。每次写入都会持有连接的写锁，
+// 但返回的写入器本身不能被多个协程同时使用；1xx、204 和 304 响应不能有主体，不能用这个方法写入。
+// headers 中的 Trailer 头部声明了结束时发送的尾部字段，它们的值在写完主体之后通过 SetTrailer 设置
+func (c *Conn) WriteResponseStream(statusCode int, statusText string, headers map[string]string) (*ChunkedWriter, error) {
+	if !bodyAllowed(statusCode) {
+		return nil, errNoBodyAllowed
+	}
+	if err := c.writeChunked(true, statusCode, statusText, headers, nil, false, nil); err != nil {
+		return nil, err
+	}
+	w := &ChunkedWriter{c: c}
+	for _, name := range strings.Split(headerValue([]map[string]string{headers}, "Trailer"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			w.declared = append(w.declared, name)
+		}
+	}
+	return w, nil
+}
+
+// errNoBodyAllowed 表示这个状态码的响应不能有主体
+var errNoBodyAllowed = errors.New("status code does not allow a body")
+
+var (
+	// errTrailerNotDeclared 表示尾部字段没有在响应的 Trailer 头部中声明
+	errTrailerNotDeclared = errors.New("trailer not declared in the Trailer header")
+
+	// errInvalidTrailer 表示尾部字段的值中包含换行
+	errInvalidTrailer = errors.New("invalid trailer value")
+)
+
+// ChunkedWriter 是 WriteResponseStream 返回的写入器，将每次写入作为一个块发送
+type ChunkedWriter struct {
+	c        *Conn
+	closed   bool
+	declared []string // Trailer 头部声明的尾部字段名称，已经转为小写
+	trailers []string // SetTrailer 设置的尾部字段，每一项是 "Name: value"
+}
+
+// SetTrailer 设置一个在最后的空块之后发送的尾部字段，例如在写完主体后设置校验和。
+// name 必须已经在 WriteResponseStream 的 Trailer 头部中声明（不区分大小写），否则返回错误；
+// 再次设置同一个名称会替换之前的值。它必须在 Close 之前调用
+func (w *ChunkedWriter) SetTrailer(name, value string) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errInvalidTrailer
+	}
+	declared := false
+	for _, d := range w.declared {
+		if strings.EqualFold(d, name) {
+			declared = true
+			break
+		}
+	}
+	if !declared {
+		return errTrailerNotDeclared
+	}
+
+	field := w.c.headerName(name) + ": " + value
+	for i, t := range w.trailers {
+		if strings.EqualFold(t[:strings.IndexByte(t, ':')], name) {
+			w.trailers[i] = field
+			return nil
+		}
+	}
+	w.trailers = append(w.trailers, field)
+	return nil
+}
+
+func (w *ChunkedWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	if err := w.c.writeChunked(false, 0, "", nil, p, false, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close 发送最后的空块和 SetTrailer 设置的尾部字段，结束响应
+func (w *ChunkedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.c.writeChunked(false, 0, "", nil, nil, true, w.trailers)
+}
+
+// writeChunked 以分块传输的方式写入响应的一部分：head 为 true 时先写入头部，data 不为空时写入一个块，
+// last 为 true 时写入最后的空块和 trailers 中的尾部字段
+func (c *Conn) writeChunked(head bool, statusCode int, statusText string, headers map[string]string, data []byte, last bool, trailers []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.response != nil {
+		c.response.mu.Lock()
+		defer c.response.mu.Unlock()
+		if c.response.sealed { // 响应已经被封存，丢弃这次写入
+			return ErrResponseSealed
+		}
+		if head && c.response.written() { // 已经写入过最终响应，不能再开始一个流式响应
+			return ErrResponseAlreadyWritten
+		}
+	}
+
+	var buf bytes.Buffer
+	if head {
+		if data == nil && !hasHeader([]map[string]string{headers}, "Content-Type") { // 还没有内容可以检测类型
+			headers = withHeader(headers, "Content-Type", "text/plain; charset=utf-8")
+		}
+		c.writeHead(&buf, statusCode, statusText, data, "Transfer-Encoding: chunked", []map[string]string{headers})
+	}
+	if c.headRequest() { // HEAD 请求的响应只发送头部，之后的块都被丢弃
+		data, last = nil, false
+	}
+	if len(data) > 0 {
+		fmt.Fprintf(&buf, "%x\r\n", len(data))
+		buf.Write(data)
+		buf.WriteString("\r\n")
+	}
+	if last {
+		buf.WriteString("0\r\n")
+		for _, t := range trailers {
+			buf.WriteString(t + "\r\n")
+		}
+		buf.WriteString("\r\n")
+	}
+
+	// 流式响应的每一块都立即发送，头部和第一块仍然在一次写入中
+	if _, err := c.output().Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := c.flushOutput(); err != nil {
+		return err
+	}
+
+	if head {
+		c.recordResponse(statusCode, []map[string]string{headers})
+	}
+	c.recordBody(data)
+	return nil
+}
+
+// withHeader 返回 headers 加上 key: value 的副本，不修改调用者的 map
+func withHeader(headers map[string]string, key, value string) map[string]string {
+	m := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		m[k] = v
+	}
+	m[key] = value
+	return m
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBufferedWriterThreshold(t *testing.T) {
+	const threshold = 16
+	chunked := make(chan bool, 1)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		w := c.BufferedWriter(threshold)
+		size := len(c.Message.Path()) - 1 // 路径 "/xxx" 决定写入的字节数
+		for i := 0; i < size; i++ {       // 每次写入一个字节，恰好在第 threshold+1 个字节时切换
+			w.Write([]byte("x"))
+		}
+		chunked <- w.Chunked()
+		w.Close()
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	for _, tt := range []struct {
+		size    int
+		chunked bool
+	}{
+		{0, false},
+		{threshold - 1, false},
+		{threshold, false},
+		{threshold + 1, true},
+		{3 * threshold, true},
+	} {
+		io.WriteString(conn, "GET /"+strings.Repeat("x", tt.size)+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, body := readResponse(t, reader)
+		if switched := <-chunked; len(body) != tt.size || switched != tt.chunked {
+			t.Fatalf("%d bytes: body of %d bytes, chunked=%v, want chunked=%v", tt.size, len(body), switched, tt.chunked)
+		}
+		if isChunked := len(resp.TransferEncoding) > 0; isChunked != tt.chunked || (!tt.chunked && resp.ContentLength != int64(tt.size)) {
+			t.Fatalf("%d bytes: Transfer-Encoding %v, Content-Length %d", tt.size, resp.TransferEncoding, resp.ContentLength)
+		}
+	}
+}
+
+func TestBufferedWriterAfterResponse(t *testing.T) {
+	errs := make(chan error, 2)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte("first"))
+		w := c.BufferedWriter(4)
+		_, err := w.Write([]byte("too long for the buffer"))
+		errs <- err
+		_, err = w.Write([]byte("still too long")) // 头部没有发送，不能只发送块
+		errs <- err
+		if w.Chunked() {
+			t.Error("Chunked() = true after the headers failed to write")
+		}
+		w.Close()
+	})})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	if _, body := readResponse(t, reader); body != "first" {
+		t.Fatalf("body = %q", body)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrResponseAlreadyWritten {
+			t.Fatalf("Write() err = %v, want ErrResponseAlreadyWritten", err)
+		}
+	}
+
+	// 连接上没有多余的块，下一个请求的响应紧跟在第一个响应之后
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != "first" {
+		t.Fatalf("next response = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestChunkedWriterTrailers(t *testing.T) {
+	errs := make(chan error, 3)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		w, err := c.WriteResponseStream(200, "OK", map[string]string{"Trailer": "X-Checksum, X-Count"})
+		if err != nil {
+			errs <- err
+			return
+		}
+		io.WriteString(w, "part one,")
+		io.WriteString(w, "part two")
+		errs <- w.SetTrailer("x-checksum", "old")
+		errs <- w.SetTrailer("X-Checksum", "abc123") // 替换之前的值
+		if err := w.SetTrailer("X-Undeclared", "1"); err != errTrailerNotDeclared {
+			t.Errorf("SetTrailer(undeclared) = %v", err)
+		}
+		errs <- w.Close()
+	})})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if body != "part one,part two" {
+		t.Fatalf("body = %q", body)
+	}
+	// net/http 在读完主体之后才填充 Trailer
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Fatalf("trailer X-Checksum = %q, want %q (trailers %v)", got, "abc123", resp.Trailer)
+	}
+	if _, ok := resp.Trailer["X-Count"]; !ok {
+		t.Fatalf("declared trailer X-Count missing from %v", resp.Trailer)
+	}
+	if got := resp.Trailer.Get("X-Count"); got != "" {
+		t.Fatalf("unset trailer X-Count = %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseCache 是 WriteCached 使用的响应缓存，由一个 Server 的所有连接共享
+// 缓存项只会被同一个键的新结果替换，不会被淘汰，所以键应该来自一个有限的集合（例如页面的名称），不能直接使用请求中的参数
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry 是一个缓存的响应，ready 被关闭之后其他字段不再改变
+type cacheEntry struct {
+	ready chan struct{} // 生成完成（或者失败）时被关闭，同一个键的其他请求等待它而不是重复生成
+	ok    bool          // 是否成功生成，生成函数 panic 时为 false
+
+	body        []byte
+	gzipped     []byte // body 的 gzip 压缩结果，压缩后没有变小时为 nil
+	contentType string
+	expires     time.Time
+}
+
+// get 返回 key 对应的没有过期的缓存项，缺失或者过期时调用 generate 生成一个新的缓存项；
+// 同一个键同时只有一个请求调用 generate，其他请求等待它的结果，避免缓存过期时大量请求同时重新生成
+func (rc *responseCache) get(key string, ttl time.Duration, generate func() ([]byte, string)) *cacheEntry {
+	for {
+		rc.mu.Lock()
+		e, found := rc.entries[key]
+		if found {
+			select {
+			case <-e.ready:
+				if e.ok && time.Now().Before(e.expires) { // 命中
+					rc.mu.Unlock()
+					return e
+				}
+			default: // 另一个请求正在生成，等待它的结果
+				rc.mu.Unlock()
+				<-e.ready
+				if e.ok {
+					return e
+				}
+				continue // 生成失败，重新尝试
+			}
+		}
+
+		e = &cacheEntry{ready: make(chan struct{})}
+		if rc.entries == nil {
+			rc.entries = make(map[string]*cacheEntry)
+		}
+		rc.entries[key] = e
+		rc.mu.Unlock()
+
+		e.fill(ttl, generate)
+		return e
+	}
+}
+
+// fill 调用 generate 填充缓存项，generate panic 时缓存项被标记为失败，等待它的请求会重新尝试，panic 继续向上传递
+func (e *cacheEntry) fill(ttl time.Duration, generate func() ([]byte, string)) {
+	defer close(e.ready)
+	e.body, e.contentType = generate()
+	e.gzipped = gzipBytes(e.body)
+	e.expires = time.Now().Add(ttl)
+	e.ok = true
+}
+
+// gzipBytes 返回 body 的 gzip 压缩结果，压缩后没有变小时返回 nil
+func gzipBytes(body []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(body)
+	if zw.Close() != nil || buf.Len() >= len(body) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// WriteCached 写入一个缓存的 200 响应：key 对应的缓存没有过期时直接使用，否则调用 generate 生成主体和内容类型并缓存 ttl 时间，
+// 同一个键同时只会有一个请求调用 generate。主体的 gzip 压缩结果也会被缓存，客户端接受 gzip 时直接发送压缩后的字节，
+// 不需要每次重新生成和压缩。内容类型为空时根据主体检测。不经过 Server 创建的 Conn 没有缓存，每次都会调用 generate
+func (c *Conn) WriteCached(key string, ttl time.Duration, generate func() ([]byte, string)) error {
+	var e *cacheEntry
+	if c.cache != nil {
+		e = c.cache.get(key, ttl, generate)
+	} else {
+		e = &cacheEntry{}
+		e.body, e.contentType = generate()
+	}
+
+	headers := map[string]string{"Vary": "Accept-Encoding"}
+	if e.contentType != "" {
+		headers["Content-Type"] = e.contentType
+	}
+	body := e.body
+	if e.gzipped != nil && c.Message != nil && AcceptsEncoding(c.Message.Header("Accept-Encoding"), "gzip") {
+		body = e.gzipped
+		headers["Content-Encoding"] = "gzip"
+		if e.contentType == "" { // 检测内容类型需要未压缩的主体
+			headers["Content-Type"] = detectContentType(e.body)
+		}
+	}
+	return c.WriteResponse(200, "OK", body, headers)
+}
+
+// AcceptsEncoding 判断 Accept-Encoding 头部的值是否接受 coding，"*" 匹配任意编码，q=0 表示不接受
+func AcceptsEncoding(value, coding string) bool {
+	accepted := false
+	for _, item := range strings.Split(value, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+		if strings.EqualFold(name, coding) { // 明确列出的编码优先于 "*"
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheHitAndMiss(t *testing.T) {
+	var rc responseCache
+	var calls int
+	generate := func() ([]byte, string) {
+		calls++
+		return []byte("v" + strconv.Itoa(calls)), "text/plain"
+	}
+
+	if e := rc.get("page", 50*time.Millisecond, generate); string(e.body) != "v1" || e.contentType != "text/plain" {
+		t.Fatalf("miss = %q %q", e.body, e.contentType)
+	}
+	if e := rc.get("page", 50*time.Millisecond, generate); string(e.body) != "v1" || calls != 1 {
+		t.Fatalf("hit = %q after %d calls", e.body, calls)
+	}
+	if e := rc.get("other", 50*time.Millisecond, generate); string(e.body) != "v2" { // 不同的键分别缓存
+		t.Fatalf("other key = %q", e.body)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if e := rc.get("page", 50*time.Millisecond, generate); string(e.body) != "v3" { // 过期后重新生成
+		t.Fatalf("after expiry = %q", e.body)
+	}
+}
+
+func TestResponseCacheSingleFlight(t *testing.T) {
+	var rc responseCache
+	var calls atomic.Int32
+	release := make(chan struct{})
+	generate := func() ([]byte, string) {
+		calls.Add(1)
+		<-release // 生成期间到达的请求都在等待
+		return []byte("shared"), ""
+	}
+
+	const requests = 20
+	var wg sync.WaitGroup
+	bodies := make(chan string, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies <- string(rc.get("page", time.Minute, generate).body)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(bodies)
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("generate called %d times, want 1", n)
+	}
+	for body := range bodies {
+		if body != "shared" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+}
+
+func TestResponseCacheRetriesAfterPanic(t *testing.T) {
+	var rc responseCache
+	func() {
+		defer func() { recover() }()
+		rc.get("page", time.Minute, func() ([]byte, string) { panic("boom") })
+	}()
+	// 失败的缓存项不会被当作命中
+	if e := rc.get("page", time.Minute, func() ([]byte, string) { return []byte("ok"), "" }); string(e.body) != "ok" {
+		t.Fatalf("after panic = %q", e.body)
+	}
+}
+
+func TestWriteCachedGzip(t *testing.T) {
+	page := strings.Repeat("cached page ", 100)
+	var calls atomic.Int32
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		c.WriteCached("page", time.Minute, func() ([]byte, string) {
+			calls.Add(1)
+			return []byte(page), "text/plain"
+		})
+	})}
+	conn := dial(t, startServer(t, s))
+	reader := bufio.NewReader(conn)
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, body := readResponse(t, reader); body != page || resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("identity response = %v %d bytes", resp.Header, len(body))
+	}
+
+	// 缓存的压缩结果直接发送，不需要重新生成
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nAccept-Encoding: gzip\r\n\r\n")
+	resp, body := readResponse(t, reader)
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("gzip response headers = %v", resp.Header)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := io.ReadAll(zr); string(plain) != page {
+		t.Fatalf("decompressed %d bytes", len(plain))
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("generate called %d times, want 1", n)
+	}
+}
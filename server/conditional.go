@@ -0,0 +1,74 @@
+package server
+
+import "strings"
+
+// CheckIfMatch 检查请求的 If-Match 前提条件，用于 PUT、PATCH 等写操作的乐观并发控制
+// currentETag 是资源当前的 ETag（带引号，例如 "\"v2\""），资源不存在时传入空字符串。
+// 没有 If-Match 头部时总是通过；"*" 只要求资源存在；否则当前 ETag 必须与列表中的某一个强匹配，弱 ETag（W/ 开头）永远不匹配。
+// 前提条件不满足时写入 412 Precondition Failed 并返回 false，处理器应该直接返回。
+func (c *Conn) CheckIfMatch(currentETag string) (proceed bool) {
+	ifMatch, ok := c.Message.LookupHeader("If-Match")
+	if !ok {
+		return true
+	}
+
+	if ifMatchSatisfied(ifMatch, currentETag) {
+		return true
+	}
+	c.WriteResponse(412, "Precondition Failed", []byte("Precondition Failed"))
+	return false
+}
+
+// ifMatchSatisfied 判断 If-Match 头部的值是否与当前的 ETag 匹配
+func ifMatchSatisfied(ifMatch, currentETag string) bool {
+	if currentETag == "" { // 资源不存在时，任何 If-Match 都不满足
+		return false
+	}
+	for _, tag := range parseETagList(ifMatch) {
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") || strings.HasPrefix(currentETag, "W/") { // If-Match 使用强比较
+			continue
+		}
+		if tag == currentETag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETagList 将逗号分隔的 ETag 列表拆分为单个的 ETag，ETag 中可以包含逗号
+func parseETagList(value string) []string {
+	var tags []string
+	for value = strings.TrimSpace(value); value != ""; value = strings.TrimSpace(value) {
+		if value[0] == ',' {
+			value = value[1:]
+			continue
+		}
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+			continue
+		}
+
+		prefix := ""
+		if strings.HasPrefix(value, "W/") {
+			prefix, value = "W/", value[2:]
+		}
+		if value == "" || value[0] != '"' { // 格式错误，跳过到下一个逗号
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				value = value[i:]
+				continue
+			}
+			break
+		}
+		end := strings.IndexByte(value[1:], '"')
+		if end < 0 {
+			break
+		}
+		tags = append(tags, prefix+value[:end+2])
+		value = value[end+2:]
+	}
+	return tags
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+func TestIfMatchSatisfied(t *testing.T) {
+	for _, tt := range []struct {
+		ifMatch, current string
+		want             bool
+	}{
+		{`"v2"`, `"v2"`, true},
+		{`"v1", "v2"`, `"v2"`, true},
+		{`"a,b", "v2"`, `"v2"`, true}, // ETag 中可以包含逗号
+		{`"a,b"`, `"a,b"`, true},
+		{`"v1"`, `"v2"`, false},
+		{`W/"v2"`, `"v2"`, false}, // 强比较，弱 ETag 不匹配
+		{`"v2"`, `W/"v2"`, false},
+		{`*`, `"anything"`, true},
+		{`*`, ``, false}, // 资源不存在
+		{`"v2"`, ``, false},
+		{`garbage, "v2"`, `"v2"`, true},
+	} {
+		if got := ifMatchSatisfied(tt.ifMatch, tt.current); got != tt.want {
+			t.Errorf("ifMatchSatisfied(%q, %q) = %v, want %v", tt.ifMatch, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		current := `"v2"`
+		if c.Message.Path() == "/missing" {
+			current = ""
+		}
+		if !c.CheckIfMatch(current) {
+			return
+		}
+		c.WriteResponse(200, "OK", []byte("updated"))
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	for _, tt := range []struct {
+		path, ifMatch string
+		code          int
+	}{
+		{"/doc", "", 200}, // 没有 If-Match 时总是通过
+		{"/doc", `"v2"`, 200},
+		{"/doc", `"v1"`, 412},
+		{"/doc", "*", 200},
+		{"/missing", "*", 412},
+	} {
+		request := "PUT " + tt.path + " HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n"
+		if tt.ifMatch != "" {
+			request += "If-Match: " + tt.ifMatch + "\r\n"
+		}
+		io.WriteString(conn, request+"\r\n")
+		if resp, _ := readResponse(t, reader); resp.StatusCode != tt.code {
+			t.Errorf("PUT %s If-Match %s: status = %d, want %d", tt.path, tt.ifMatch, resp.StatusCode, tt.code)
+		}
+	}
+}
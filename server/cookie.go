@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite 是 Cookie 的 SameSite 属性
+type SameSite string
+
+const (
+	SameSiteDefault SameSite = ""       // 不设置 SameSite 属性，由浏览器决定（现代浏览器视为 Lax）
+	SameSiteLax     SameSite = "Lax"    // 跨站的顶级导航会携带 Cookie
+	SameSiteStrict  SameSite = "Strict" // 跨站请求都不会携带 Cookie
+	SameSiteNone    SameSite = "None"   // 跨站请求都会携带 Cookie，必须同时设置 Secure
+)
+
+// Cookie 表示一个 Set-Cookie 头部中的 Cookie
+type Cookie struct {
+	Name   string
+	Value  string
+	Path   string
+	Domain string
+
+	Expires time.Time // 为零值时不设置 Expires 属性
+	MaxAge  int       // 为0时不设置 Max-Age 属性，为负数时输出 Max-Age=0 让浏览器立即删除
+
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool // CHIPS 分区 Cookie，必须同时设置 Secure
+}
+
+var (
+	errInvalidCookieName       = errors.New("invalid cookie name")
+	errInvalidCookieValue      = errors.New("invalid cookie value")
+	errSameSiteNoneNeedsSecure = errors.New("cookie with SameSite=None must be Secure")
+	errPartitionedNeedsSecure  = errors.New("partitioned cookie must be Secure")
+	errInvalidSameSite         = errors.New("invalid SameSite value")
+)
+
+// Validate 检查 Cookie 的名称、值和属性组合是否符合浏览器的规则
+func (ck *Cookie) Validate() error {
+	if ck.Name == "" || strings.ContainsAny(ck.Name, "()<>@,;:\\\"/[]?={} \t\r\n") {
+		return errInvalidCookieName
+	}
+	if strings.ContainsAny(ck.Value, "\",;\\ \t\r\n") {
+		return errInvalidCookieValue
+	}
+	switch ck.SameSite {
+	case SameSiteDefault, SameSiteLax, SameSiteStrict:
+	case SameSiteNone:
+		if !ck.Secure {
+			return errSameSiteNoneNeedsSecure
+		}
+	default:
+		return errInvalidSameSite
+	}
+	if ck.Partitioned && !ck.Secure {
+		return errPartitionedNeedsSecure
+	}
+	return nil
+}
+
+// String 将 Cookie 序列化为 Set-Cookie 头部的值，不合法的 Cookie 返回空字符串
+func (ck *Cookie) String() string {
+	if ck.Validate() != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(ck.Name + "=" + ck.Value)
+	if ck.Path != "" {
+		b.WriteString("; Path=" + ck.Path)
+	}
+	if ck.Domain != "" {
+		b.WriteString("; Domain=" + ck.Domain)
+	}
+	if !ck.Expires.IsZero() {
+		b.WriteString("; Expires=" + ck.Expires.UTC().Format(cookieTimeFormat))
+	}
+	if ck.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(ck.MaxAge))
+	} else if ck.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if ck.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if ck.Secure {
+		b.WriteString("; Secure")
+	}
+	if ck.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + string(ck.SameSite))
+	}
+	if ck.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// cookieTimeFormat 是 Expires 属性使用的时间格式
+const cookieTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ParseSetCookie 解析一个 Set-Cookie 头部的值，用于客户端保存服务器下发的 Cookie
+// 无法识别的属性会被忽略，名称不合法或属性组合不合法时返回错误
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok {
+		return nil, errInvalidCookieName
+	}
+	ck := &Cookie{Name: strings.TrimSpace(name), Value: strings.Trim(strings.TrimSpace(value), "\"")}
+
+	for _, part := range parts[1:] {
+		attr, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(attr)) {
+		case "path":
+			ck.Path = val
+		case "domain":
+			ck.Domain = strings.TrimPrefix(val, ".")
+		case "expires":
+			if t, err := time.Parse(cookieTimeFormat, val); err == nil {
+				ck.Expires = t
+			}
+		case "max-age":
+			if n, err := strconv.Atoi(val); err == nil {
+				if n <= 0 {
+					n = -1
+				}
+				ck.MaxAge = n
+			}
+		case "secure":
+			ck.Secure = true
+		case "httponly":
+			ck.HttpOnly = true
+		case "partitioned":
+			ck.Partitioned = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				ck.SameSite = SameSiteLax
+			case "strict":
+				ck.SameSite = SameSiteStrict
+			case "none":
+				ck.SameSite = SameSiteNone
+			}
+		}
+	}
+
+	if err := ck.Validate(); err != nil {
+		return nil, err
+	}
+	return ck, nil
+}
+
+// CookieOptions 是 SetCookie 设置的 Cookie 的属性
+type CookieOptions struct {
+	Path   string
+	Domain string
+	MaxAge int // 为0时不设置 Max-Age 属性，Cookie 在浏览器关闭时失效；为负数时让浏览器立即删除
+
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Cookie 返回请求中名称为 name 的 Cookie 的值，同名的 Cookie 出现多次时返回第一个（按照 RFC 6265，路径更具体的排在前面）
+func (c *Conn) Cookie(name string) (string, bool) {
+	value, ok := c.Cookies()[name]
+	return value, ok
+}
+
+// Cookies 解析请求的 Cookie 头部，返回所有 Cookie 的名称和值，值两边的双引号会被去掉
+func (c *Conn) Cookies() map[string]string {
+	cookies := make(map[string]string)
+	if c.Message == nil {
+		return cookies
+	}
+	for _, header := range c.Message.HeaderValues("Cookie") {
+		for _, pair := range strings.Split(header, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" {
+				continue
+			}
+			if _, seen := cookies[name]; !seen {
+				cookies[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return cookies
+}
+
+// SetCookie 让这个请求之后写入的响应带有一个 Set-Cookie 头部，每个 Cookie 单独一行；
+// 名称、路径和域名都相同的 Cookie 会替换之前设置的那个。名称、值或属性组合不合法时返回错误，见 Cookie.Validate
+func (c *Conn) SetCookie(name, value string, opts CookieOptions) error {
+	return c.addCookie(&Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		MaxAge:   opts.MaxAge,
+		Secure:   opts.Secure,
+		HttpOnly: opts.HttpOnly,
+		SameSite: opts.SameSite,
+	})
+}
+
+// addCookie 将 ck 添加到之后写入的响应中
+func (c *Conn) addCookie(ck *Cookie) error {
+	if err := ck.Validate(); err != nil {
+		return err
+	}
+	if c.response == nil {
+		return nil
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	for i, old := range c.response.cookies {
+		if old.Name == ck.Name && old.Path == ck.Path && old.Domain == ck.Domain {
+			c.response.cookies[i] = ck
+			return nil
+		}
+	}
+	c.response.cookies = append(c.response.cookies, ck)
+	return nil
+}
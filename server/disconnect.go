@@ -0,0 +1,80 @@
+package server
+
+import (
+	stdcontext "context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// requestState 是一个请求在处理期间共享的状态，中间件之间传递的 Conn 副本共享同一个记录
+type requestState struct {
+	cancel stdcontext.CancelFunc // 取消请求的上下文
+
+	once     sync.Once
+	done     chan struct{} // 客户端断开连接时被关闭
+	probing  chan struct{} // 探测协程退出时被关闭，为 nil 表示没有开始探测
+	stopping atomic.Bool   // 服务器正在停止探测，这时读取的超时不表示客户端断开
+}
+
+// newRequestState 创建一个请求的状态，并返回从 parent 派生的、客户端断开连接时会被取消的上下文
+func newRequestState(parent stdcontext.Context) (*requestState, stdcontext.Context) {
+	ctx, cancel := stdcontext.WithCancel(parent)
+	return &requestState{cancel: cancel, done: make(chan struct{})}, ctx
+}
+
+// Disconnected 返回一个在客户端断开连接时被关闭的通道，同时请求的上下文（Conn.Context）会被取消，
+// 流式响应、SSE 或长轮询等长时间运行的处理器可以用它尽早停止工作。
+// 第一次调用时会丢弃还没有读取的请求主体，之后在后台探测连接，不会消耗下一个请求的字节；WebSocket 连接上返回的通道永远不会被关闭，
+// 因为帧的读取本身就会发现连接关闭
+func (c *Conn) Disconnected() <-chan struct{} {
+	if c.request == nil {
+		return nil
+	}
+	c.request.once.Do(func() {
+		if c.IsWebSocket() || c.Status() == 101 {
+			return
+		}
+		if c.Message != nil && c.Message.DiscardBody() != nil { // 读取主体失败，连接已经不可用
+			c.request.disconnect()
+			return
+		}
+		c.request.probing = make(chan struct{})
+		go c.request.probe(c)
+	})
+	return c.request.done
+}
+
+// probe 等待连接上的下一个字节：读到 EOF 或者错误表示客户端已经断开；Peek 不会消耗读到的字节，
+// 流水线中的下一个请求会留给服务器读取
+func (r *requestState) probe(c *Conn) {
+	defer close(r.probing)
+	if _, err := c.reader.Peek(1); err != nil && !r.stopping.Load() {
+		r.disconnect()
+	}
+}
+
+// disconnect 记录客户端已经断开连接
+func (r *requestState) disconnect() {
+	close(r.done)
+	r.cancel()
+}
+
+// finish 在处理器返回后结束请求：停止还在进行的探测，使服务器可以独占读取器，返回客户端是否已经断开连接
+func (r *requestState) finish(conn net.Conn) (disconnected bool) {
+	r.once.Do(func() {}) // 处理器留下的协程（例如超时之后）不能再开始探测
+	if r.probing != nil {
+		r.stopping.Store(true)
+		conn.SetReadDeadline(time.Now()) // 使阻塞的 Peek 立即返回
+		<-r.probing
+		conn.SetReadDeadline(time.Time{})
+	}
+	r.cancel()
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
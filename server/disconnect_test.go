@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDisconnectedFiresWhenClientCloses(t *testing.T) {
+	results := make(chan error, 1)
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		select {
+		case <-c.Disconnected():
+			results <- c.Context().Err() // 请求的上下文同时被取消
+		case <-time.After(5 * time.Second):
+			results <- nil
+		}
+	})}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\n\r\nbody")
+	time.Sleep(50 * time.Millisecond) // 处理器已经在等待
+	conn.Close()
+
+	if err := <-results; err == nil {
+		t.Fatal("Disconnected() did not fire after the client closed the connection")
+	}
+}
+
+func TestDisconnectedIgnoresPipelinedRequest(t *testing.T) {
+	fired := make(chan bool, 2)
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		select {
+		case <-c.Disconnected():
+			fired <- true
+		case <-time.After(100 * time.Millisecond):
+			fired <- false
+		}
+		c.WriteResponse(200, "OK", []byte(c.Message.Path()))
+	})}
+	conn := dial(t, startServer(t, s))
+	// 流水线中的下一个请求不表示断开，探测也不能消耗它的字节
+	io.WriteString(conn, "GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"/a", "/b"} {
+		if _, body := readResponse(t, reader); body != want {
+			t.Fatalf("body = %q, want %q", body, want)
+		}
+		if <-fired {
+			t.Fatalf("Disconnected() fired for %s on a live connection", want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import "strings"
+
+// ResponseEncoder 在 WriteResponse 写入一个完整的响应之前转换它的主体，例如压缩中间件：
+// header 返回响应头部的值（名称不区分大小写，没有设置 Content-Type 时返回根据主体检测出的类型），
+// 返回转换后的主体和需要添加或替换的头部，不需要转换时原样返回 body 和 nil
+type ResponseEncoder func(statusCode int, header func(name string) string, body []byte) ([]byte, map[string]string)
+
+// SetResponseEncoder 让这个请求之后用 WriteResponse（以及基于它的 WriteContent、WriteCached 等）写入的响应主体先经过 encoder，
+// 再次调用会替换之前的编码器。流式响应（WriteResponseStream、分块传输）和没有主体的响应不经过编码器
+func (c *Conn) SetResponseEncoder(encoder ResponseEncoder) {
+	if c.response == nil {
+		return
+	}
+	c.response.mu.Lock()
+	c.response.encoder = encoder
+	c.response.mu.Unlock()
+}
+
+// encodeBody 用编码器转换响应主体，调用者需要持有 response.mu
+func (c *Conn) encodeBody(statusCode int, body []byte, headers []map[string]string) ([]byte, []map[string]string) {
+	if c.response == nil || c.response.encoder == nil || len(body) == 0 || !bodyAllowed(statusCode) {
+		return body, headers
+	}
+	header := func(name string) string {
+		for _, h := range headers {
+			for key, value := range h {
+				if strings.EqualFold(key, name) {
+					return value
+				}
+			}
+		}
+		if strings.EqualFold(name, "Content-Type") {
+			return detectContentType(body)
+		}
+		return ""
+	}
+	encoded, extra := c.response.encoder(statusCode, header, body)
+	for key := range extra {
+		headers = withoutHeader(headers, key)
+	}
+	if len(extra) > 0 {
+		headers = append(headers, extra)
+	}
+	return encoded, headers
+}
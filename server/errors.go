@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"github.com/lvkeliang/httpws/context"
+	"html/template"
+)
+
+// StatusCoder 由携带HTTP状态码的错误实现，DefaultErrorHandler 会使用它返回的状态码
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// ErrorHandler 将处理器返回的错误写成一个HTTP响应
+type ErrorHandler func(c *Conn, err error)
+
+// sentinelStatus 是已知的错误和它们对应的状态码
+var sentinelStatus = map[error]int{
+	context.ErrDecompressedTooLarge: 413,
+	context.ErrBodyPartiallyRead:    500,
+	context.ErrBodyDiscarded:        500,
+	ErrNotJSON:                      415,
+	errInvalidHandshake:             400,
+	errUnsupportedProtocol:          426,
+	errTooManyWebSockets:            503,
+	errBodyTooLarge:                 413,
+	context.ErrInvalidChunk:         400,
+}
+
+// DefaultErrorHandler 是默认的错误映射：
+// 实现了 StatusCoder 的错误使用它的状态码，ValidationErrors 回复 422，已知的错误回复对应的状态码，
+// 客户端取消的请求（context.Canceled）回复 499，超时（context.DeadlineExceeded）回复 503，其他错误回复 500。
+// 响应的格式根据请求的 Accept 头部选择，见 ErrorRenderer
+func DefaultErrorHandler(c *Conn, err error) {
+	defaultErrorRenderer.Handle(c, err)
+}
+
+// ErrorRenderer 根据请求的 Accept 头部将错误渲染成纯文本、JSON 或 HTML 响应，没有 Accept 头部或者接受任何类型时使用纯文本。
+// 它的 Handle 方法是一个 ErrorHandler，可以赋给 Server.ErrorHandler 来定制错误响应的格式
+type ErrorRenderer struct {
+	// HTML 是 HTML 错误页面的模板，数据是 ErrorData，为 nil 时使用一个简单的默认页面
+	HTML *template.Template
+
+	// JSON 返回 JSON 错误响应中被编码的值，为 nil 时使用 {"error":"..."}
+	JSON func(data ErrorData) interface{}
+}
+
+// ErrorData 是渲染错误响应时使用的数据
+type ErrorData struct {
+	Code    int    // 状态码
+	Status  string // 状态码对应的原因短语
+	Message string // 错误信息，5xx 错误不暴露服务器内部错误的细节，使用原因短语
+}
+
+// defaultErrorRenderer 是 DefaultErrorHandler 使用的渲染器
+var defaultErrorRenderer = &ErrorRenderer{}
+
+// defaultErrorPage 是默认的 HTML 错误页面
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Code}} {{.Status}}</title></head>
+<body>
+<h1>{{.Code}} {{.Status}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+// Handle 将 err 写成一个HTTP响应，状态码的映射和 DefaultErrorHandler 相同
+func (r *ErrorRenderer) Handle(c *Conn, err error) {
+	code := errorStatus(err)
+	data := ErrorData{Code: code, Status: StatusText(code), Message: err.Error()}
+	if code >= 500 { // 不向客户端暴露服务器内部错误的细节
+		data.Message = data.Status
+	}
+
+	accepts := "text/plain"
+	if c.Message != nil {
+		accepts = c.Message.Accepts("text/plain", "application/json", "text/html")
+	}
+
+	switch accepts {
+	case "application/json":
+		var v interface{} = map[string]string{"error": data.Message}
+		if r.JSON != nil {
+			v = r.JSON(data)
+		}
+		if body, jsonErr := json.Marshal(v); jsonErr == nil {
+			c.WriteResponse(code, data.Status, body, map[string]string{"Content-Type": "application/json"})
+			return
+		}
+	case "text/html":
+		page := r.HTML
+		if page == nil {
+			page = defaultErrorPage
+		}
+		var body bytes.Buffer
+		if page.Execute(&body, data) == nil {
+			c.WriteResponse(code, data.Status, body.Bytes(), map[string]string{"Content-Type": "text/html; charset=utf-8"})
+			return
+		}
+	}
+	c.WriteResponse(code, data.Status, []byte(data.Message), map[string]string{"Content-Type": "text/plain; charset=utf-8"})
+}
+
+// errorStatus 返回 err 对应的状态码
+func errorStatus(err error) int {
+	var coder StatusCoder
+	if errors.As(err, &coder) {
+		if code := coder.StatusCode(); code >= 100 && code <= 599 {
+			return code
+		}
+	}
+
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return 422
+	}
+
+	for sentinel, code := range sentinelStatus {
+		if errors.Is(err, sentinel) {
+			return code
+		}
+	}
+
+	switch {
+	case errors.Is(err, stdcontext.Canceled):
+		return 499
+	case errors.Is(err, stdcontext.DeadlineExceeded):
+		return 503
+	}
+	return 500
+}
+
+// WriteError 使用服务器配置的 ErrorHandler 将 err 写成一个HTTP响应，没有配置时使用 DefaultErrorHandler
+func (c *Conn) WriteError(err error) {
+	if c.errorHandler != nil {
+		c.errorHandler(c, err)
+		return
+	}
+	DefaultErrorHandler(c, err)
+}
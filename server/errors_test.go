@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"testing"
+)
+
+// teapotError 是一个实现了 StatusCoder 的错误
+type teapotError struct{ code int }
+
+func (e teapotError) Error() string   { return "teapot" }
+func (e teapotError) StatusCode() int { return e.code }
+
+func TestErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"StatusCoder", teapotError{418}, 418},
+		{"wrapped StatusCoder", fmt.Errorf("brewing: %w", teapotError{418}), 418},
+		{"StatusCoder out of range", teapotError{42}, 500},
+		{"context canceled", stdcontext.Canceled, 499},
+		{"wrapped context canceled", fmt.Errorf("query: %w", stdcontext.Canceled), 499},
+		{"deadline exceeded", stdcontext.DeadlineExceeded, 503},
+		{"body too large", errBodyTooLarge, 413},
+		{"unknown", errors.New("boom"), 500},
+	} {
+		if got := errorStatus(tt.err); got != tt.code {
+			t.Errorf("%s: errorStatus() = %d, want %d", tt.name, got, tt.code)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var err error
+	s := &Server{Handler: handlerFunc(func(c *Conn) { c.WriteError(err) })}
+	conn := dial(t, startServer(t, s))
+	reader := bufio.NewReader(conn)
+
+	for _, tt := range []struct {
+		err  error
+		code int
+		body string
+	}{
+		{teapotError{418}, 418, "teapot"},
+		{stdcontext.Canceled, 499, "context canceled"},
+		{fmt.Errorf("db password wrong: %w", stdcontext.DeadlineExceeded), 503, "Service Unavailable"}, // 5xx 不暴露错误的细节
+	} {
+		err = tt.err
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		if resp, body := readResponse(t, reader); resp.StatusCode != tt.code || body != tt.body {
+			t.Errorf("WriteError(%v) = %d %q, want %d %q", tt.err, resp.StatusCode, body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestWriteErrorCustomHandler(t *testing.T) {
+	s := &Server{
+		Handler: handlerFunc(func(c *Conn) { c.WriteError(stdcontext.Canceled) }),
+		ErrorHandler: func(c *Conn, err error) {
+			c.WriteResponse(errorStatus(err)-99, "Custom", []byte("custom: "+err.Error()))
+		},
+	}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, body := readResponse(t, bufio.NewReader(conn)); resp.StatusCode != 400 || body != "custom: context canceled" {
+		t.Fatalf("response = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestErrorRendererNegotiation(t *testing.T) {
+	var renderer *ErrorRenderer
+	s := &Server{Handler: handlerFunc(func(c *Conn) { renderer.Handle(c, teapotError{418}) })}
+	conn := dial(t, startServer(t, s))
+	reader := bufio.NewReader(conn)
+
+	for _, tt := range []struct {
+		renderer    *ErrorRenderer
+		accept      string
+		contentType string
+		body        string
+	}{
+		{&ErrorRenderer{}, "", "text/plain; charset=utf-8", "teapot"}, // 没有 Accept 头部时使用纯文本
+		{&ErrorRenderer{}, "*/*", "text/plain; charset=utf-8", "teapot"},
+		{&ErrorRenderer{}, "application/json", "application/json", `{"error":"teapot"}`},
+		{&ErrorRenderer{}, "text/plain;q=0.5, application/json", "application/json", `{"error":"teapot"}`},
+		{&ErrorRenderer{}, "application/json;q=0.1, text/plain", "text/plain; charset=utf-8", "teapot"},
+		{&ErrorRenderer{}, "image/png", "text/plain; charset=utf-8", "teapot"}, // 不能满足时退回纯文本
+		{&ErrorRenderer{}, "text/html", "text/html; charset=utf-8", "<p>teapot</p>"},
+		{
+			&ErrorRenderer{JSON: func(d ErrorData) interface{} { return map[string]interface{}{"code": d.Code, "message": d.Message} }},
+			"application/json", "application/json", `{"code":418,"message":"teapot"}`,
+		},
+		{
+			&ErrorRenderer{HTML: template.Must(template.New("e").Parse("<p>{{.Code}}: {{.Message}}</p>"))},
+			"text/html", "text/html; charset=utf-8", "<p>418: teapot</p>",
+		},
+	} {
+		renderer = tt.renderer
+		request := "GET / HTTP/1.1\r\nHost: x\r\n"
+		if tt.accept != "" {
+			request += "Accept: " + tt.accept + "\r\n"
+		}
+		io.WriteString(conn, request+"\r\n")
+		resp, body := readResponse(t, reader)
+		if resp.StatusCode != 418 || resp.Header.Get("Content-Type") != tt.contentType || !strings.Contains(body, tt.body) {
+			t.Errorf("Accept %q: %d %q %q, want %q containing %q", tt.accept, resp.StatusCode, resp.Header.Get("Content-Type"), body, tt.contentType, tt.body)
+		}
+	}
+}
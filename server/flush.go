@@ -0,0 +1,30 @@
+package server
+
+import "io"
+
+// Flush 立即发送已经写入但还缓冲在连接中的响应。Server 在每个请求结束时自动刷新，
+// 处理器只有在写入响应之后还要继续运行一段时间（例如写入响应后再做耗时的清理）时才需要调用它
+func (c *Conn) Flush() error {
+	if c.response == nil {
+		return nil
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	return c.flushOutput()
+}
+
+// output 返回写入响应的目标，经过 Server 时是连接的缓冲写入器，调用者需要持有 response.mu
+func (c *Conn) output() io.Writer {
+	if c.response != nil && c.response.writer != nil {
+		return c.response.writer
+	}
+	return c.Conn
+}
+
+// flushOutput 发送缓冲写入器中的内容，调用者需要持有 response.mu
+func (c *Conn) flushOutput() error {
+	if c.response == nil || c.response.writer == nil {
+		return nil
+	}
+	return c.response.writer.Flush()
+}
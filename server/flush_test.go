@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func BenchmarkWriteResponse(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 512)
+	for _, buffered := range []bool{false, true} {
+		name := "direct"
+		if buffered {
+			name = "buffered"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := &Conn{Conn: discardTCPConn(b), connLocks: &connLocks{}}
+			var writer *bufio.Writer // 为 nil 时直接写入连接
+			if buffered {
+				writer = bufio.NewWriter(c.Conn)
+			}
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// 和 Server 处理一个请求时一样：每个请求有新的响应记录，写入响应，在请求结束时刷新
+				c.response = &responseRecord{writer: writer}
+				if err := c.WriteResponse(200, "OK", body, map[string]string{"Content-Type": "text/plain"}); err != nil {
+					b.Fatal(err)
+				}
+				if err := c.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkKeepAliveRequests(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 512)
+	addr := startServer(b, &Server{Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", body)
+	})})
+	conn := dial(b, addr)
+	conn.SetDeadline(time.Time{}) // 基准测试可能运行超过 dial 设置的5秒
+	reader := bufio.NewReader(conn)
+	request := []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(request); err != nil {
+			b.Fatal(err)
+		}
+		resp, body := readResponse(b, reader)
+		if resp.StatusCode != 200 || len(body) != 512 {
+			b.Fatalf("response = %d, %d bytes", resp.StatusCode, len(body))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/lvkeliang/httpws/context"
+	"strings"
+)
+
+// isHTTP2Preface 判断请求是否是 HTTP/2 连接前言 "PRI * HTTP/2.0"，客户端直接以 HTTP/2 开始了连接（prior knowledge）
+func isHTTP2Preface(msg *context.Context) bool {
+	return msg.StartLine == "PRI * HTTP/2.0"
+}
+
+// ignoreH2CUpgrade 忽略 HTTP/2 明文升级（Upgrade: h2c）：服务器不支持 HTTP/2，按照 RFC 7540 3.2 可以忽略升级，
+// 以 HTTP/1.1 回复请求。删除 Upgrade 中的 h2c、HTTP2-Settings 头部和 Connection 中对应的选项，
+// 使处理器看到一个普通的 HTTP/1.1 请求，不会把它误当作其他协议（例如WebSocket）的升级
+func ignoreH2CUpgrade(msg *context.Context) {
+	upgrade, ok := msg.LookupHeader("Upgrade")
+	if !ok || !hasToken(upgrade, "h2c") {
+		return
+	}
+
+	protocols := withoutTokens(upgrade, "h2c")
+	if protocols == "" {
+		msg.DelHeader("Upgrade")
+	} else {
+		msg.SetHeader("Upgrade", protocols)
+	}
+	msg.DelHeader("HTTP2-Settings")
+
+	if connection, ok := msg.LookupHeader("Connection"); ok {
+		remove := []string{"HTTP2-Settings"}
+		if protocols == "" { // 没有其他升级的协议
+			remove = append(remove, "Upgrade")
+		}
+		if connection = withoutTokens(connection, remove...); connection == "" {
+			msg.DelHeader("Connection")
+		} else {
+			msg.SetHeader("Connection", connection)
+		}
+	}
+}
+
+// withoutTokens 从逗号分隔的列表 value 中删除 tokens，不区分大小写
+func withoutTokens(value string, tokens ...string) string {
+	var kept []string
+	for _, t := range strings.Split(value, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		removed := false
+		for _, token := range tokens {
+			if strings.EqualFold(t, token) {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, t)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+func TestH2CUpgradeIgnored(t *testing.T) {
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		// 处理器看到的是一个普通的 HTTP/1.1 请求
+		c.WriteResponse(200, "OK", []byte(c.Message.Header("Upgrade")+"|"+c.Message.Header("Connection")+"|"+c.Message.Header("HTTP2-Settings")))
+	})}
+	conn := dial(t, startServer(t, s))
+	reader := bufio.NewReader(conn)
+
+	for _, tt := range []struct{ upgrade, connection, want string }{
+		{"h2c", "Upgrade, HTTP2-Settings", "||"},
+		{"h2c", "keep-alive, Upgrade, HTTP2-Settings", "|keep-alive|"},
+		{"h2c, foo/1", "Upgrade, HTTP2-Settings", "foo/1|Upgrade|"}, // 其他升级的协议保留
+	} {
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: "+tt.upgrade+"\r\nConnection: "+tt.connection+"\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n")
+		resp, body := readResponse(t, reader)
+		if resp.StatusCode != 200 || resp.Proto != "HTTP/1.1" || body != tt.want {
+			t.Errorf("Upgrade %q: %s %d %q, want 200 %q", tt.upgrade, resp.Proto, resp.StatusCode, body, tt.want)
+		}
+		if resp.Close {
+			t.Fatalf("Upgrade %q: connection closed", tt.upgrade)
+		}
+	}
+}
+
+func TestHTTP2PrefaceRejected(t *testing.T) {
+	s := &Server{Handler: handlerFunc(func(c *Conn) { t.Error("handler called for the HTTP/2 preface") })}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 505 || !resp.Close {
+		t.Fatalf("preface response = %d close=%v, want 505 and close", resp.StatusCode, resp.Close)
+	}
+}
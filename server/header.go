@@ -0,0 +1,22 @@
+package server
+
+import "strings"
+
+// SetResponseHeader 设置一个会添加到这个请求之后写入的所有响应（包括流式响应和没有主体的响应）中的头部，
+// 替换之前设置的不同大小写的同名头部；写入响应时传入的同名头部优先。它适合在调用下一个处理器之前由中间件设置，例如 CORS
+func (c *Conn) SetResponseHeader(name, value string) {
+	if c.response == nil {
+		return
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	if c.response.headers == nil {
+		c.response.headers = make(map[string]string)
+	}
+	for key := range c.response.headers {
+		if strings.EqualFold(key, name) {
+			delete(c.response.headers, key)
+		}
+	}
+	c.response.headers[name] = value
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readRawResponse 读取一个带有 Content-Length 的响应，返回原样的头部和主体，不像 http.ReadResponse 那样把头部名称规范化
+func readRawResponse(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var head strings.Builder
+	length := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(line)
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		t.Fatal(err)
+	}
+	return head.String(), string(body)
+}
+
+func TestHeaderCasing(t *testing.T) {
+	for _, tt := range []struct {
+		canonical      bool
+		requestKeys    string
+		responseHeader string
+	}{
+		{false, "Host x-lower-case", "x-custom-HEADER: v"}, // 默认保留原始的大小写
+		{true, "Host X-Lower-Case", "X-Custom-Header: v"},
+	} {
+		s := &Server{CanonicalHeaderKeys: tt.canonical, Handler: handlerFunc(func(c *Conn) {
+			var keys []string
+			for key := range c.Message.Headers {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			// 查找不区分大小写，与选项无关
+			body := strings.Join(keys, " ") + "|" + c.Message.Header("X-LOWER-CASE")
+			c.WriteResponse(200, "OK", []byte(body), map[string]string{"x-custom-HEADER": "v"})
+		})}
+		conn := dial(t, startServer(t, s))
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nx-lower-case: 1\r\n\r\n")
+		head, body := readRawResponse(t, bufio.NewReader(conn))
+		if body != tt.requestKeys+"|1" {
+			t.Errorf("canonical=%v: request headers = %q, want %q", tt.canonical, body, tt.requestKeys+"|1")
+		}
+		if !strings.Contains(head, "\r\n"+tt.responseHeader+"\r\n") {
+			t.Errorf("canonical=%v: response head %q does not contain %q", tt.canonical, head, tt.responseHeader)
+		}
+	}
+}
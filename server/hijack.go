@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+// ErrHijacked 表示连接已经被 Hijack 接管，或者已经写入过响应，不能再接管
+var ErrHijacked = errors.New("connection hijacked")
+
+// Hijack 将底层的连接交给处理器，例如 CONNECT 隧道：返回的读取器中可能已经缓冲了客户端紧跟在请求之后发送的字节，
+// 处理器应该从它而不是直接从连接读取。接管之后这个请求的响应被封存（WriteResponse 返回 ErrResponseSealed），
+// 处理器自己负责写入响应；处理器返回后服务器会关闭连接，不会再读取下一个请求
+func (c *Conn) Hijack() (net.Conn, *bufio.Reader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.IsWebSocket() {
+		return nil, nil, ErrHijacked
+	}
+	if c.response != nil {
+		c.response.mu.Lock()
+		defer c.response.mu.Unlock()
+		if c.response.sealed || c.response.status != 0 {
+			return nil, nil, ErrHijacked
+		}
+		c.response.sealed = true
+		c.response.close = true
+	}
+	return c.Conn, c.bufReader(), nil
+}
+
+// CloseAfterRequest 使服务器在这个请求结束后关闭连接，不再读取下一个请求，已经写入或之后写入的响应照常发送。
+// 它用于连接的状态已经无法确定的情况，例如处理器超时后仍然在另一个协程中运行、可能还在读取请求主体
+func (c *Conn) CloseAfterRequest() {
+	if c.response == nil {
+		return
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	c.response.close = true
+}
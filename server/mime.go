@@ -0,0 +1,42 @@
+package server
+
+import (
+	"mime"
+	"strings"
+	"sync"
+)
+
+// mimeTypes 是通过 RegisterMIMEType 注册的扩展名到内容类型的映射
+var (
+	mimeMu    sync.RWMutex
+	mimeTypes = map[string]string{}
+)
+
+// RegisterMIMEType 为扩展名 ext（例如 ".wasm" 或 "wasm"）注册内容类型，覆盖 mime 包中的类型
+// 映射是进程全局的，可以并发调用，通常在启动时注册
+func RegisterMIMEType(ext, contentType string) {
+	mimeMu.Lock()
+	defer mimeMu.Unlock()
+	mimeTypes[normalizeExt(ext)] = contentType
+}
+
+// TypeByExtension 返回扩展名对应的内容类型，先查找 RegisterMIMEType 注册的类型，再查找 mime 包，都没有时返回空字符串
+func TypeByExtension(ext string) string {
+	ext = normalizeExt(ext)
+	mimeMu.RLock()
+	contentType, ok := mimeTypes[ext]
+	mimeMu.RUnlock()
+	if ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// normalizeExt 将扩展名转为以点开头的小写形式
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
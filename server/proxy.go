@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature 是 PROXY 协议 v2 头部开头的12字节签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+// readProxyHeader 从 reader 中读取一个 PROXY 协议 v1 或 v2 头部，并返回其中记录的客户端地址
+// 如果头部声明的是 LOCAL 命令或 UNKNOWN 协议，返回 nil，此时应继续使用连接本身的地址
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	sig, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(reader)
+	}
+	return nil, errInvalidProxyHeader
+}
+
+// readProxyHeaderV1 解析文本格式的头部，例如 "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for { // v1 头部最长107字节，逐字节读取以免越界读入请求数据
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= 107 {
+			return nil, errInvalidProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" { // 代理不知道来源地址
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errInvalidProxyHeader
+	}
+	port, err := strconv.Atoi(fields[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyHeaderV2 解析二进制格式的头部
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	var header [16]byte // 12字节签名、版本和命令、地址族和协议、2字节地址长度
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 { // 高4位是版本号，必须为2
+		return nil, errInvalidProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13] >> 4
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	addrs := make([]byte, length)
+	if _, err := io.ReadFull(reader, addrs); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x00: // LOCAL：代理自己发起的连接（例如健康检查），使用连接本身的地址
+		return nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, errInvalidProxyHeader
+	}
+
+	switch family {
+	case 0x01: // AF_INET：源地址4字节、目的地址4字节、源端口2字节、目的端口2字节
+		if len(addrs) < 12 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[0:4]), Port: int(binary.BigEndian.Uint16(addrs[8:10]))}, nil
+	case 0x02: // AF_INET6：源地址16字节、目的地址16字节、源端口2字节、目的端口2字节
+		if len(addrs) < 36 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[0:16]), Port: int(binary.BigEndian.Uint16(addrs[32:34]))}, nil
+	default: // AF_UNSPEC 或 AF_UNIX，没有可用的 IP 地址
+		return nil, nil
+	}
+}
+
+// trustsProxy 判断 addr 是否是允许发送 PROXY 协议头部的上游地址
+func (s *Server) trustsProxy(addr net.Addr) bool {
+	if len(s.TrustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, trusted := range s.TrustedProxies {
+		if strings.Contains(trusted, "/") { // CIDR 格式，例如 "10.0.0.0/8"
+			if _, network, err := net.ParseCIDR(trusted); err == nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if trustedIP := net.ParseIP(trusted); trustedIP != nil && trustedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
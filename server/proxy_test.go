@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 构造一个 PROXY 协议 v2 头部，addrs 是地址部分的原始字节
+func proxyV2(versionCommand, family byte, addrs string) string {
+	length := len(addrs)
+	return string(proxyV2Signature) + string([]byte{versionCommand, family, byte(length >> 8), byte(length)}) + addrs
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv6 := "\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01" + // 源地址 2001:db8::1
+		strings.Repeat("\x00", 15) + "\x02" + // 目的地址 ::2
+		"\x30\x39\x01\xbb" // 源端口 12345，目的端口 443
+
+	for _, tt := range []struct {
+		name   string
+		header string
+		addr   string // 期望的地址，空字符串表示使用连接本身的地址
+		err    bool
+	}{
+		{name: "v1 TCP4", header: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", addr: "192.168.0.1:56324"},
+		{name: "v1 TCP6", header: "PROXY TCP6 2001:db8::1 ::2 12345 443\r\n", addr: "[2001:db8::1]:12345"},
+		{name: "v1 UNKNOWN", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 UNKNOWN with addresses", header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		{name: "v1 bare LF", header: "PROXY TCP4 1.2.3.4 5.6.7.8 1 2\n", err: true},
+		{name: "v1 bad address", header: "PROXY TCP4 1.2.3 5.6.7.8 1 2\r\n", err: true},
+		{name: "v1 bad port", header: "PROXY TCP4 1.2.3.4 5.6.7.8 70000 2\r\n", err: true},
+		{name: "v1 missing fields", header: "PROXY TCP4 1.2.3.4\r\n", err: true},
+		{name: "v1 unknown protocol", header: "PROXY UDP4 1.2.3.4 5.6.7.8 1 2\r\n", err: true},
+		{name: "v1 too long", header: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", err: true},
+		{name: "v2 IPv4", header: proxyV2(0x21, 0x11, "\x01\x02\x03\x04\x05\x06\x07\x08\x04\x57\x00\x50"), addr: "1.2.3.4:1111"},
+		{name: "v2 IPv4 with TLVs", header: proxyV2(0x21, 0x11, "\x01\x02\x03\x04\x05\x06\x07\x08\x04\x57\x00\x50\x04\x00\x01\x00"), addr: "1.2.3.4:1111"},
+		{name: "v2 IPv6", header: proxyV2(0x21, 0x21, ipv6), addr: "[2001:db8::1]:12345"},
+		{name: "v2 LOCAL", header: proxyV2(0x20, 0x00, "")},
+		{name: "v2 UNSPEC", header: proxyV2(0x21, 0x00, "")},
+		{name: "v2 bad version", header: proxyV2(0x11, 0x11, "\x01\x02\x03\x04\x05\x06\x07\x08\x04\x57\x00\x50"), err: true},
+		{name: "v2 bad command", header: proxyV2(0x22, 0x11, "\x01\x02\x03\x04\x05\x06\x07\x08\x04\x57\x00\x50"), err: true},
+		{name: "v2 short IPv4", header: proxyV2(0x21, 0x11, "\x01\x02\x03\x04"), err: true},
+		{name: "no header", header: "GET / HTTP/1.1\r\n", err: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.header + "GET / HTTP/1.1\r\n"))
+			addr, err := readProxyHeader(reader)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("readProxyHeader() = %v, want an error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ""; addr != nil {
+				got = addr.String()
+				if got != tt.addr {
+					t.Fatalf("addr = %q, want %q", got, tt.addr)
+				}
+			} else if tt.addr != "" {
+				t.Fatalf("addr = nil, want %q", tt.addr)
+			}
+			if rest, _ := reader.ReadString('\n'); rest != "GET / HTTP/1.1\r\n" { // 头部之后的请求没有被读走
+				t.Fatalf("rest = %q", rest)
+			}
+		})
+	}
+}
+
+func TestTrustsProxy(t *testing.T) {
+	s := &Server{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32"}}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:4000":     true,
+		"192.168.1.5:80":    true,
+		"192.168.1.6:80":    false,
+		"[2001:db8::7]:443": true,
+		"[2001:db9::7]:443": false,
+		"172.16.0.1:1":      false,
+	} {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.trustsProxy(tcpAddr); got != want {
+			t.Errorf("trustsProxy(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if (&Server{}).trustsProxy(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}) {
+		t.Error("a server without TrustedProxies trusts a proxy")
+	}
+}
+
+func TestProxyProtocolClientIP(t *testing.T) {
+	clientIP := handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte(c.ClientIP()))
+	})
+
+	conn := dial(t, startServer(t, &Server{ProxyProtocol: true, TrustedProxies: []string{"127.0.0.1"}, Handler: clientIP}))
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 80\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if _, body := readResponse(t, bufio.NewReader(conn)); body != "203.0.113.7" {
+		t.Fatalf("ClientIP() = %q behind a trusted proxy", body)
+	}
+
+	// 不受信任的上游发送的连接不会被当作 PROXY 协议读取
+	conn = dial(t, startServer(t, &Server{ProxyProtocol: true, TrustedProxies: []string{"10.0.0.0/8"}, Handler: clientIP}))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if _, body := readResponse(t, bufio.NewReader(conn)); body != "127.0.0.1" {
+		t.Fatalf("ClientIP() = %q from an untrusted peer", body)
+	}
+}
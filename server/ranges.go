@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// byteRange 是一个字节范围，包含 start 和 end 两端
+type byteRange struct {
+	start, end int64
+}
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// maxRanges 是一个 multipart/byteranges 响应中最多的部分数，超过时写入完整的内容，防止大量细碎的范围放大响应
+const maxRanges = 32
+
+// WriteContent 写入一个支持范围请求的200响应，并带有 Accept-Ranges: bytes 头部
+// 请求带有单个 Range: bytes=... 时只写入对应的部分并回复 206 Partial Content，范围无法满足时回复 416；
+// 请求多个范围时回复 206 和一个 multipart/byteranges 主体，每个部分带有自己的 Content-Range，只有一个范围可以满足时按单个范围回复；
+// 格式无法识别的 Range 头部，或者范围过多、总长度超过内容长度时会被忽略，写入完整的内容。
+// 不支持范围请求的动态处理器可以在自定义头部中写入 "Accept-Ranges": "none" 告诉客户端不要尝试断点续传。
+func (c *Conn) WriteContent(body []byte, headers ...map[string]string) error {
+	headers = append(headers, map[string]string{"Accept-Ranges": "bytes"})
+
+	rangeHeader := c.Message.Header("Range")
+	if rangeHeader == "" || c.Message.Method() != "GET" {
+		return c.WriteResponse(200, "OK", body, headers...)
+	}
+
+	ranges, err := parseRange(rangeHeader, int64(len(body)))
+	if err == errUnsatisfiableRange {
+		headers = append(headers, map[string]string{"Content-Range": "bytes */" + strconv.Itoa(len(body))})
+		return c.WriteResponse(416, "Range Not Satisfiable", nil, headers...)
+	}
+	if err != nil || len(ranges) > maxRanges || rangesLength(ranges) > int64(len(body)) {
+		return c.WriteResponse(200, "OK", body, headers...)
+	}
+
+	// 内容类型根据完整的内容检测，而不是其中的一部分
+	contentType := detectContentType(body)
+	if hasHeader(headers, "Content-Type") {
+		contentType = headerValue(headers, "Content-Type")
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		if !hasHeader(headers, "Content-Type") {
+			headers = append(headers, map[string]string{"Content-Type": contentType})
+		}
+		headers = append(headers, map[string]string{"Content-Range": r.contentRange(len(body))})
+		return c.WriteResponse(206, "Partial Content", body[r.start:r.end+1], headers...)
+	}
+
+	// 多个范围，每个范围作为 multipart/byteranges 主体的一个部分
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {r.contentRange(len(body))},
+		})
+		if err != nil {
+			return err
+		}
+		part.Write(body[r.start : r.end+1])
+	}
+	mw.Close()
+
+	headers = withoutHeader(headers, "Content-Type")
+	headers = append(headers, map[string]string{"Content-Type": "multipart/byteranges; boundary=" + mw.Boundary()})
+	return c.WriteResponse(206, "Partial Content", buf.Bytes(), headers...)
+}
+
+// contentRange 返回这个范围的 Content-Range 头部的值，size 是完整内容的长度
+func (r byteRange) contentRange(size int) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.end, 10) + "/" + strconv.Itoa(size)
+}
+
+// rangesLength 返回所有范围的总长度
+func rangesLength(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.end - r.start + 1
+	}
+	return n
+}
+
+// withoutHeader 返回去掉了名称为 name 的头部的自定义头部，不修改调用者的 map
+func withoutHeader(headers []map[string]string, name string) []map[string]string {
+	result := make([]map[string]string, 0, len(headers))
+	for _, header := range headers {
+		filtered := make(map[string]string, len(header))
+		for key, value := range header {
+			if !strings.EqualFold(key, name) {
+				filtered[key] = value
+			}
+		}
+		result = append(result, filtered)
+	}
+	return result
+}
+
+// parseRange 解析 Range 头部，例如 "bytes=0-499"、"bytes=500-" 或 "bytes=-500"，size 是完整内容的长度
+// 所有范围都无法满足时返回 errUnsatisfiableRange，格式错误时返回其他错误
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil, errors.New("invalid range unit")
+	}
+
+	var ranges []byteRange
+	for _, spec := range strings.Split(header[len("bytes="):], ",") {
+		spec = strings.TrimSpace(spec)
+		startStr, endStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errors.New("invalid range")
+		}
+
+		var r byteRange
+		if startStr == "" { // 后缀范围，最后 N 个字节
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if start >= size { // 起点超出内容长度，这个范围无法满足
+				continue
+			}
+			r = byteRange{start: start, end: size - 1}
+			if endStr != "" {
+				end, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || end < start {
+					return nil, errors.New("invalid range")
+				}
+				if end < size-1 {
+					r.end = end
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
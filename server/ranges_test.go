@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestWriteContentMultipleRanges(t *testing.T) {
+	content := "0123456789abcdefghij"
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		c.WriteContent([]byte(content), map[string]string{"Content-Type": "text/plain"})
+	})}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nRange: bytes=0-3, 15-\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	if resp.StatusCode != 206 {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" || params["boundary"] == "" {
+		t.Fatalf("Content-Type = %q (%v)", resp.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for _, want := range []struct{ contentRange, data string }{
+		{"bytes 0-3/20", "0123"},
+		{"bytes 15-19/20", "fghij"},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(part)
+		// 每个部分带有自己的 Content-Range，内容类型是完整内容的类型
+		if part.Header.Get("Content-Range") != want.contentRange || part.Header.Get("Content-Type") != "text/plain" || string(data) != want.data {
+			t.Errorf("part = %v %q, want %s %q", part.Header, data, want.contentRange, want.data)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("NextPart() after two parts = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,605 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"github.com/lvkeliang/httpws/context"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBodySize 是 Server 未设置 MaxBodySize 时允许的最大请求主体长度
+	DefaultMaxBodySize = 64 << 20
+
+	// DefaultIdleTimeout 是 Server 没有设置 IdleTimeout 和 ReadHeaderTimeout 时保持的连接等待下一个请求的最长时间
+	DefaultIdleTimeout = 2 * time.Minute
+
+	// DefaultMaxHeaderBytes 是 Server 未设置 MaxHeaderBytes 时允许的请求行和头部的最大总长度
+	DefaultMaxHeaderBytes = 1 << 20
+
+	// DefaultMaxPipelinedRequests 是 Server 未设置 MaxPipelinedRequests 时一个连接上最多合并发送响应的流水线请求数
+	DefaultMaxPipelinedRequests = 16
+)
+
+// Handler 是处理连接的处理器接口，router.Router 实现了这个接口
+type Handler interface {
+	Serve(c *Conn)
+}
+
+// Server 包含了服务器监听的地址和一个处理器接口
+type Server struct {
+	Addr        string  // 监听的地址，例如 ":8080"
+	Handler     Handler // 处理请求的处理器
+	MaxBodySize int64   // 允许的最大请求主体长度，为0时使用 DefaultMaxBodySize，为负数时不限制
+
+	// MaxHeaderBytes 是请求行和头部的最大总长度，为0时使用 DefaultMaxHeaderBytes
+	// 超过读取缓冲区的头部会被读入可以增长的缓冲区，超过这个限制时回复 431 Request Header Fields Too Large
+	MaxHeaderBytes int
+
+	// MaxHeaderLineBytes 是请求行和每一个头部行的最大长度，为0时只受 MaxHeaderBytes 限制，超过时同样回复 431
+	MaxHeaderLineBytes int
+
+	// CanonicalHeaderKeys 为 true 时将请求头部的名称改写为规范的形式（例如 "content-type" 改为 "Content-Type"），
+	// 响应头部的名称也以规范的形式写出。默认保留原始的大小写：请求的 Headers 中是客户端发送的名称（Header 查找时不区分大小写），
+	// 响应中是处理器设置的名称，服务器作为代理时不会改变经过它的头部，也不会破坏依赖头部名称的签名校验
+	CanonicalHeaderKeys bool
+
+	// ProxyProtocol 为 true 时，在连接开始时读取 PROXY 协议 v1/v2 头部，用其中的客户端地址作为 Conn.RemoteAddr
+	// 只有来自 TrustedProxies 的连接才会被读取，其他连接使用原始地址
+	ProxyProtocol  bool
+	TrustedProxies []string // 允许发送 PROXY 协议头部的上游地址，可以是 IP 或 CIDR，例如 "10.0.0.0/8"
+
+	// ReadHeaderTimeout 是读取请求头的超时时间，为0时不限制请求头的读取，但等待第一个字节时仍然受 IdleTimeout 的限制
+	// 还没有收到任何字节就超时的连接会被直接关闭，读到一半超时的请求会先收到 408 Request Timeout
+	ReadHeaderTimeout time.Duration
+
+	// IdleTimeout 是保持的连接等待下一个请求的最长时间，为0时使用 ReadHeaderTimeout，两者都为0时使用 DefaultIdleTimeout
+	// 它也会以 Keep-Alive: timeout=N 的形式告诉客户端
+	IdleTimeout time.Duration
+
+	// WebSocketHandshakeTimeout 是从收到握手请求的第一个字节到 UpgradeToWebSocket 完成握手的最长时间，为0时不限制
+	// 它同时限制请求头的读取（见 ReadHeaderTimeout）和 UpgradeToWebSocket 中请求主体的丢弃与 101 响应的写入，
+	// 只发送了一部分握手请求的客户端会在超时后被断开；它和升级之后的 WebSocketIdleTimeout 相互独立
+	WebSocketHandshakeTimeout time.Duration
+
+	// WebSocketIdleTimeout 是升级为WebSocket后，连接在没有收到任何帧（包括 ping 和 pong）时保持的最长时间，为0时不限制
+	// 它和只作用于HTTP请求之间的 IdleTimeout 相互独立，安静的WebSocket连接不会因为较短的HTTP空闲超时被关闭。
+	// 如果依靠心跳保持连接，它应该比心跳间隔长，这样每次收到对方的 ping 或 pong 都会重新开始计时
+	WebSocketIdleTimeout time.Duration
+
+	// WebSocketCompression 为 true 时在握手中接受客户端提出的 permessage-deflate 扩展（RFC 7692），之后压缩发送的数据消息、解压收到的压缩消息；
+	// 服务器总是使用 server_no_context_takeover，每个消息单独压缩，不需要为每个连接保留压缩器的状态
+	WebSocketCompression bool
+
+	// WebSocketCompressionMinSize 是压缩发送的消息的最小长度，更短的消息不压缩，为0时使用 DefaultWebSocketCompressionMinSize
+	WebSocketCompressionMinSize int
+
+	// WebSocketLegacyHixie 为 true 时 UpgradeToWebSocket 也接受旧的 draft-hixie-76 握手（Sec-WebSocket-Key1/Key2 和8字节的 key3），
+	// 用于兼容一些老旧的嵌入式设备；这样升级的连接只能收发文本消息，没有二进制消息、ping/pong 和分片。默认只接受 RFC 6455 握手
+	WebSocketLegacyHixie bool
+
+	// TLSConfig 是 ListenAndServeTLS 使用的 TLS 配置，为 nil 时使用默认配置
+	TLSConfig *tls.Config
+
+	// MaxWebSocketConns 是同时存在的WebSocket连接的最大数量，为0时不限制
+	// 达到限制时 UpgradeToWebSocket 回复 503 Service Unavailable 并返回错误，不会完成握手
+	MaxWebSocketConns int
+
+	// MaxRequestsPerConn 是一个连接上最多处理的请求数，为0时不限制，达到后关闭连接
+	// 它也会以 Keep-Alive: max=N 的形式告诉客户端剩余的请求数
+	MaxRequestsPerConn int
+
+	// MaxPipelinedRequests 是一个连接上最多有多少个已经处理、响应还留在缓冲区中没有发送的流水线请求，为0时使用 DefaultMaxPipelinedRequests，
+	// 为负数时每个请求的响应都立即发送。下一个请求的头部已经在读取缓冲区中时，服务器先处理它再一起发送响应；
+	// 达到限制后先发送缓冲的响应，客户端读走之前不会再读取新的请求，所以一个连接占用的内存不随流水线请求的数量增长。
+	// 合并期间处理较慢的请求会推迟之前的响应，处理器可以调用 Conn.Flush 提前发送
+	MaxPipelinedRequests int
+
+	// ErrorHandler 是 Conn.WriteError 使用的错误映射，为 nil 时使用 DefaultErrorHandler
+	ErrorHandler ErrorHandler
+
+	// Templates 是 Conn.Render 使用的页面模板，为 nil 时 Render 返回错误
+	Templates *TemplateSet
+
+	// AllowedHosts 是这个服务器负责的主机名，例如 "example.com" 或 "*.example.com"，为空时不检查
+	// Host 头部不匹配任何一个主机名的请求会收到 421 Misdirected Request 并关闭连接，客户端会在新的连接上重试
+	AllowedHosts []string
+
+	// ConnStateHook 在连接的状态变化时被调用，可以用于导出监控指标；Stats 方法提供了同样信息的计数
+	ConnStateHook func(conn net.Conn, state ConnState)
+
+	counters serverCounters // 各个状态的连接数
+	cache    responseCache  // WriteCached 缓存的响应
+
+	shutdownMu sync.Mutex                // 保护下面的关闭状态
+	closing    bool                      // 已经调用了 Shutdown 或 Close
+	stopped    bool                      // 已经调用了 Close，连接被强制关闭
+	listeners  map[net.Listener]struct{} // Serve 正在使用的监听器
+	conns      map[*connTracker]struct{} // 所有没有关闭的连接
+	active     sync.WaitGroup            // 没有关闭的连接数，Shutdown 等待它归零
+
+	mu sync.RWMutex // 保护运行时被 SetHandler 替换的 Handler
+}
+
+// SetHandler 在运行时原子地替换处理器，例如换成一个新的 *router.Router
+// 正在处理的请求继续使用旧的处理器，之后读取完请求头的请求使用新的处理器
+func (s *Server) SetHandler(h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Handler = h
+}
+
+// handler 返回当前的处理器
+func (s *Server) handler() Handler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Handler
+}
+
+// ListenAndServe 方法使用 net.Listen 函数监听 s.Addr 上的 TCP 连接，并为每个新连接启动一个协程处理它
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// errNoCertificate 表示 ListenAndServeTLS 没有可以使用的证书
+var errNoCertificate = errors.New("tls: no certificate configured")
+
+// ListenAndServeTLS 方法与 ListenAndServe 相同，但使用 TLS 提供 HTTPS 服务，证书和私钥从 certFile 和 keyFile 中加载
+// s.TLSConfig 不为 nil 时使用它的副本（例如定制的密码套件），证书文件为空时使用其中已经配置的证书。
+// WebSocket 升级同样可以在 TLS 连接上进行（wss://）；PROXY 协议头部在 TLS 握手之前发送，不能与 TLS 同时使用
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append([]tls.Certificate{cert}, config.Certificates...)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		return errNoCertificate
+	}
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(tls.NewListener(listener, config))
+}
+
+// Serve 方法从 listener 中接受连接，并为每个新连接启动一个协程处理它
+// 调用 Shutdown 或 Close 之后返回 ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	if !s.trackListener(listener) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(listener)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if isTimeout(err) { // 临时错误，继续接受连接
+				log.Println("listener err: ", err)
+				continue
+			}
+			return err
+		}
+		tracker := s.newConnTracker(conn)
+		if !s.trackConn(tracker) { // Shutdown 在 Accept 返回之后才关闭监听器
+			conn.Close()
+			tracker.set(StateClosed)
+			return ErrServerClosed
+		}
+		go s.serveConn(conn, tracker)
+	}
+}
+
+// serveConn 循环读取连接上的请求并交给处理器，直到连接不再保持
+func (s *Server) serveConn(conn net.Conn, tracker *connTracker) {
+	defer s.untrackConn(tracker)
+	defer tracker.set(StateClosed)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn) // 合并一个请求的响应写入，在请求结束时刷新
+	var remoteAddr net.Addr
+
+	if s.ProxyProtocol && s.trustsProxy(conn.RemoteAddr()) {
+		conn.SetReadDeadline(time.Now().Add(s.waitTimeout(0))) // 不发送 PROXY 头部的连接同样不能一直占用协程
+		addr, err := readProxyHeader(reader)
+		if err != nil {
+			log.Println("read proxy header err: ", err)
+			return
+		}
+		remoteAddr = addr
+	}
+
+	pending := 0 // 已经处理、响应还没有发送的请求数
+	for served := 0; ; served++ {
+		// 每个请求都使用一个新的 Conn，并发的连接和同一个连接上的前后请求之间不共享解析出的消息和数据；
+		// Data 在这里创建，中间件之间传递的 Conn 副本共享同一个 map，之前的中间件也能看到之后设置的值（例如 Abort）
+		c := &Conn{Conn: conn, Data: make(map[string]interface{}), reader: reader, remoteAddr: remoteAddr, response: &responseRecord{writer: writer}, errorHandler: s.ErrorHandler, templates: s.Templates, cache: &s.cache, tracker: tracker, connLocks: &connLocks{wsIdleTimeout: s.WebSocketIdleTimeout}, wsCompression: s.webSocketCompression(), canonicalHeaders: s.CanonicalHeaderKeys, legacyHixie: s.WebSocketLegacyHixie}
+		keepAlive := s.serveRequest(c, served)
+		if pending++; keepAlive && pending < s.maxPipelinedRequests() && pipelined(reader) { // 下一个请求已经到达，合并发送它们的响应
+			continue
+		}
+		pending = 0
+		if err := c.Flush(); err != nil || !keepAlive { // 等待下一个请求或者关闭连接之前发送缓冲的响应
+			return
+		}
+	}
+}
+
+// webSocketCompression 返回 Conn.wsCompression：没有启用压缩时为0，否则是压缩的最小消息长度
+func (s *Server) webSocketCompression() int {
+	switch {
+	case !s.WebSocketCompression:
+		return 0
+	case s.WebSocketCompressionMinSize > 0:
+		return s.WebSocketCompressionMinSize
+	}
+	return DefaultWebSocketCompressionMinSize
+}
+
+// serveRequest 读取一个请求，根据请求头检查主体长度、处理 Expect: 100-continue，然后将连接交给处理器
+// served 是这个连接上已经处理过的请求数，返回值表示是否继续在这个连接上读取下一个请求
+func (s *Server) serveRequest(c *Conn, served int) bool {
+	conn := c.Conn
+
+	// 第一个请求之前的等待受 ReadHeaderTimeout 限制，之后两个请求之间的等待受 IdleTimeout 限制
+	conn.SetReadDeadline(time.Now().Add(s.waitTimeout(served)))
+
+	if served > 0 {
+		c.tracker.set(StateIdle)
+	}
+
+	if _, err := c.reader.Peek(1); err != nil { // 空闲的连接超时或关闭，直接关闭
+		if err != io.EOF && !isTimeout(err) {
+			log.Println("conn read err: ", err)
+		}
+		return false
+	}
+	c.tracker.set(StateReading)
+	started := time.Now()
+
+	if timeout := s.headerTimeout(); timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	} else { // 清除等待请求时设置的空闲超时，以及 Shutdown 在请求到达的同时设置的期限
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	msg, err := context.ReadContextLimit(c.reader, s.maxHeaderBytes(), s.MaxHeaderLineBytes)
+	if err != nil {
+		if err == context.ErrHeaderTooLarge {
+			c.WriteResponse(431, "Request Header Fields Too Large", []byte("Request Header Fields Too Large"), map[string]string{"Connection": "close"})
+			return false
+		}
+		if isTimeout(err) { // 已经收到部分请求，告诉客户端超时的原因
+			c.WriteResponse(408, "Request Timeout", []byte("Request Timeout"), map[string]string{"Connection": "close"})
+			return false
+		}
+		if err != io.EOF {
+			log.Println("create new context err: ", err)
+		}
+		return false
+	}
+	if s.CanonicalHeaderKeys {
+		msg.CanonicalizeHeaders()
+	}
+	c.Message = msg
+	if draining, stopped := s.shutdownState(); draining { // 服务器正在关闭，不再处理新的请求
+		if !stopped { // 排空期间告诉客户端（和负载均衡器）稍后在其他连接上重试；Close 强制关闭时连接已经断开，不再回复
+			c.WriteResponse(503, "Service Unavailable", []byte("Service Unavailable"), map[string]string{"Retry-After": shutdownRetryAfter, "Connection": "close"})
+		}
+		return false
+	}
+	if s.WebSocketHandshakeTimeout > 0 { // 如果这是一个握手请求，升级必须在从收到请求开始的这段时间内完成
+		c.wsHandshakeDeadline = started.Add(s.WebSocketHandshakeTimeout)
+	}
+
+	if s.headerTimeout() > 0 {
+		conn.SetReadDeadline(time.Time{}) // 请求头读取完毕，主体的读取不受这个超时限制
+	}
+
+	if isHTTP2Preface(msg) { // 服务器只支持 HTTP/1.1
+		c.WriteResponse(505, "HTTP Version Not Supported", []byte("HTTP Version Not Supported"), map[string]string{"Connection": "close"})
+		return false
+	}
+	ignoreH2CUpgrade(msg)
+
+	if len(msg.HeaderValues("Host")) > 1 { // 多个 Host 字段无法确定请求的目标主机
+		c.WriteResponse(400, "Bad Request", []byte("Bad Request"), map[string]string{"Connection": "close"})
+		return false
+	}
+	if len(s.AllowedHosts) > 0 && !s.allowsHost(msg.Header("Host")) {
+		c.WriteResponse(421, "Misdirected Request", []byte("Misdirected Request"), map[string]string{"Connection": "close"})
+		return false
+	}
+
+	length, err := msg.ContentLength()
+	_, hasTE := msg.LookupHeader("Transfer-Encoding")
+	// 同时带有 Transfer-Encoding 和 Content-Length 的请求可能被用于请求走私，最后一个编码不是 chunked 时无法确定主体的长度
+	if err != nil || hasTE && (length >= 0 || !msg.Chunked()) {
+		c.WriteResponse(400, "Bad Request", []byte("Bad Request"), map[string]string{"Connection": "close"})
+		return false
+	}
+
+	if max := s.maxBodySize(); max > 0 && length > max { // 主体过大，直接拒绝，不发送 100 Continue
+		c.WriteResponse(413, "Payload Too Large", []byte("Payload Too Large"), map[string]string{"Connection": "close"})
+		return false
+	}
+
+	expectContinue := strings.EqualFold(msg.Header("Expect"), "100-continue")
+	var continueReader *expectContinueReader
+	if length > 0 || hasTE {
+		var body io.Reader = io.LimitReader(c.reader, length)
+		if hasTE { // 分块传输的主体长度事先未知，读取时检查长度限制
+			body = &maxBodyReader{r: context.NewChunkedReader(c.reader, msg.Headers), limit: s.maxBodySize()}
+		}
+		if expectContinue { // 客户端可能在等待 100 Continue 后才会发送主体，也可能不等待直接发送
+			continueReader = &expectContinueReader{c: c, r: body}
+			body = continueReader
+		}
+		msg.SetBodyReader(body)
+	}
+
+	keepAlive := s.keepAliveHeaders(c, served)
+	c.tracker.set(StateActive)
+	c.request, c.ctx = newRequestState(c.Context())
+
+	handler := s.handler()
+	if handler == nil {
+		c.WriteResponse(404, "404 Not Found", []byte("Not Found"))
+	} else {
+		handler.Serve(c)
+	}
+	msg.RemoveTempFiles() // 删除处理器解析表单时写入的临时文件
+
+	if c.request.finish(conn) { // 客户端已经断开连接
+		return false
+	}
+
+	if status, closing := c.response.result(); !keepAlive || closing || status < 200 { // 没有写入响应或者已经切换了协议
+		return false
+	}
+	if continueReader != nil && continueReader.waiting() { // 客户端还在等待 100 Continue，无法读取下一个请求
+		return false
+	}
+	if err := msg.DiscardBody(); err != nil { // 丢弃处理器没有读取的主体，使连接停在下一个请求的开头
+		return false
+	}
+	return true
+}
+
+// allowsHost 判断 Host 头部是否匹配 AllowedHosts 中的一个主机名，忽略端口和大小写
+func (s *Server) allowsHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, allowed := range s.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if strings.HasPrefix(allowed, "*.") { // 通配符只匹配子域名，不匹配域名本身
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// waitTimeout 返回等待请求第一个字节的超时时间，总是大于0：
+// 第一个请求之前使用 headerTimeout，没有配置时和之后的请求一样使用 idleTimeout，连接之后从不发送数据的客户端不会一直占用协程
+func (s *Server) waitTimeout(served int) time.Duration {
+	if timeout := s.headerTimeout(); served == 0 && timeout > 0 {
+		return timeout
+	}
+	return s.idleTimeout()
+}
+
+// headerTimeout 返回读取请求头的超时时间，即 ReadHeaderTimeout 和 WebSocketHandshakeTimeout 中较小的非零值
+// 请求头读取完之前无法知道它是否是握手请求，所以握手超时同样限制了所有请求头的读取
+func (s *Server) headerTimeout() time.Duration {
+	timeout := s.ReadHeaderTimeout
+	if hs := s.WebSocketHandshakeTimeout; hs > 0 && (timeout <= 0 || hs < timeout) {
+		timeout = hs
+	}
+	return timeout
+}
+
+// idleTimeout 返回保持的连接等待下一个请求的最长时间，没有配置任何超时时使用 DefaultIdleTimeout，避免被客户端抛弃的连接一直占用协程
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+// keepAliveHeaders 根据请求和服务器的配置决定是否保持连接，并设置响应中的 Connection 和 Keep-Alive 头部
+// 客户端的 Keep-Alive: timeout=5, max=100 提示会和服务器的配置取较小值
+func (s *Server) keepAliveHeaders(c *Conn, served int) bool {
+	msg := c.Message
+	connection := strings.ToLower(msg.Header("Connection"))
+	keepAlive := strings.HasSuffix(msg.StartLine, "HTTP/1.1") && !strings.Contains(connection, "close")
+	if strings.HasSuffix(msg.StartLine, "HTTP/1.0") && strings.Contains(connection, "keep-alive") {
+		keepAlive = true
+	}
+
+	max := s.MaxRequestsPerConn
+	timeout := s.idleTimeout()
+	clientTimeout, clientMax := parseKeepAlive(msg.Header("Keep-Alive"))
+	if clientMax > 0 && (max <= 0 || clientMax < max) {
+		max = clientMax
+	}
+	if clientTimeout > 0 && (timeout <= 0 || clientTimeout < timeout) {
+		timeout = clientTimeout
+	}
+
+	remaining := 0
+	if max > 0 {
+		remaining = max - served - 1
+		if remaining <= 0 {
+			keepAlive = false
+		}
+	}
+
+	if !keepAlive {
+		c.response.headers = map[string]string{"Connection": "close"}
+		return false
+	}
+
+	var hints []string
+	if timeout > 0 {
+		hints = append(hints, "timeout="+strconv.Itoa(int(timeout/time.Second)))
+	}
+	if max > 0 {
+		hints = append(hints, "max="+strconv.Itoa(remaining))
+	}
+	c.response.headers = map[string]string{"Connection": "keep-alive"}
+	if len(hints) > 0 {
+		c.response.headers["Keep-Alive"] = strings.Join(hints, ", ")
+	}
+	return true
+}
+
+// parseKeepAlive 解析 Keep-Alive 头部中的 timeout 和 max 提示，没有的值返回0
+func parseKeepAlive(value string) (timeout time.Duration, max int) {
+	for _, part := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil || n < 0 {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "timeout":
+			timeout = time.Duration(n) * time.Second
+		case "max":
+			max = n
+		}
+	}
+	return
+}
+
+// isTimeout 判断 err 是否是网络超时错误
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// maxBodySize 返回实际生效的最大请求主体长度
+func (s *Server) maxBodySize() int64 {
+	if s.MaxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+	return s.MaxBodySize
+}
+
+// maxPipelinedRequests 返回实际生效的合并发送响应的流水线请求数，至少为1
+func (s *Server) maxPipelinedRequests() int {
+	switch {
+	case s.MaxPipelinedRequests < 0:
+		return 1
+	case s.MaxPipelinedRequests == 0:
+		return DefaultMaxPipelinedRequests
+	}
+	return s.MaxPipelinedRequests
+}
+
+// pipelined 判断读取缓冲区中是否已经有下一个请求完整的头部，这时读取它不会阻塞，之前的响应可以稍后发送
+func pipelined(reader *bufio.Reader) bool {
+	buffered, _ := reader.Peek(reader.Buffered())
+	return bytes.Contains(buffered, []byte("\n\r\n")) || bytes.Contains(buffered, []byte("\n\n"))
+}
+
+// maxHeaderBytes 返回实际生效的请求行和头部的最大总长度
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes <= 0 {
+		return DefaultMaxHeaderBytes
+	}
+	return s.MaxHeaderBytes
+}
+
+// expectContinueReader 在第一次读取主体时向客户端发送 100 Continue。
+// 很多客户端发送了 Expect: 100-continue 却不等待（或者只等待很短的时间）就开始发送主体，
+// 这时主体的字节已经在读取缓冲区中，不再发送 100 Continue，直接读取主体
+type expectContinueReader struct {
+	c       *Conn
+	r       io.Reader
+	started bool // 已经开始读取主体：发送了 100 Continue，或者客户端没有等待
+}
+
+func (e *expectContinueReader) Read(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		if e.c.reader.Buffered() == 0 { // 还没有收到主体，客户端在等待
+			// 流水线中之前请求的响应可能还在缓冲区中，100 Continue 必须排在它们之后发送
+			e.c.response.mu.Lock()
+			_, err := io.WriteString(e.c.output(), "HTTP/1.1 100 Continue\r\n\r\n")
+			if err == nil {
+				err = e.c.flushOutput()
+			}
+			e.c.response.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return e.r.Read(p)
+}
+
+// waiting 判断处理器没有读取主体时客户端是否还在等待 100 Continue：已经收到了主体的字节说明客户端没有等待，
+// 剩余的主体可以被丢弃，连接可以继续读取下一个请求
+func (e *expectContinueReader) waiting() bool {
+	return !e.started && e.c.reader.Buffered() == 0
+}
+
+// errBodyTooLarge 表示分块传输的请求主体超过了 Server.MaxBodySize
+var errBodyTooLarge = errors.New("request body too large")
+
+// maxBodyReader 限制读取的总长度，超过 limit 时返回 errBodyTooLarge，limit 为0或负数时不限制
+type maxBodyReader struct {
+	r     io.Reader
+	limit int64
+	n     int64 // 已经读取的字节数
+}
+
+func (m *maxBodyReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	if m.limit > 0 && m.n > m.limit {
+		return 0, errBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,948 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// handlerFunc 让测试可以直接用函数作为 Handler
+type handlerFunc func(c *Conn)
+
+func (f handlerFunc) Serve(c *Conn) {
+	f(c)
+}
+
+// startServer 在本地的随机端口上运行 s，返回监听的地址，测试结束时强制关闭服务器
+func startServer(t testing.TB, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+// dial 连接到 addr，所有读写在5秒后超时，避免出错的测试一直挂起
+func dial(t testing.TB, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readResponse 从 reader 中读取一个完整的响应，返回它和它的主体
+func readResponse(t testing.TB, reader *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// expectClosed 检查服务器在 within 之内关闭了连接，并且没有发送任何数据
+func expectClosed(t *testing.T, conn net.Conn, within time.Duration) {
+	t.Helper()
+	start := time.Now()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	if len(data) > 0 {
+		t.Fatalf("unexpected data %q", data)
+	}
+	if elapsed := time.Since(start); elapsed > within {
+		t.Fatalf("connection closed after %v, want within %v", elapsed, within)
+	}
+}
+
+func TestSilentConnectionClosed(t *testing.T) {
+	addr := startServer(t, &Server{IdleTimeout: 100 * time.Millisecond, Handler: handlerFunc(func(c *Conn) {})})
+	expectClosed(t, dial(t, addr), time.Second)
+}
+
+func TestSilentProxyConnectionClosed(t *testing.T) {
+	s := &Server{ProxyProtocol: true, TrustedProxies: []string{"127.0.0.1"}, IdleTimeout: 100 * time.Millisecond, Handler: handlerFunc(func(c *Conn) {})}
+	conn := dial(t, startServer(t, s))
+	conn.Write([]byte("PROXY TCP4 ")) // 只发送了一部分 PROXY 头部
+	expectClosed(t, conn, time.Second)
+}
+
+func TestExpectContinueLargeUpload(t *testing.T) {
+	const size = 50 << 20
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		form, err := c.Message.ParseMultipartForm(1<<20, t.TempDir())
+		if err != nil {
+			c.WriteResponse(400, "Bad Request", []byte(err.Error()))
+			return
+		}
+		fh := form.Files["file"][0]
+		file, err := fh.Open()
+		if err != nil {
+			c.WriteResponse(500, "Internal Server Error", []byte(err.Error()))
+			return
+		}
+		defer file.Close()
+		hash := sha256.New()
+		n, _ := io.Copy(hash, file)
+		c.WriteResponse(200, "OK", []byte(fmt.Sprintf("%s %s %d %x", form.Values["name"][0], fh.Filename, n, hash.Sum(nil))))
+	})})
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	want := sha256.New()
+	go func() {
+		form.WriteField("name", "upload")
+		part, _ := form.CreateFormFile("file", "big.bin")
+		chunk := make([]byte, 64<<10)
+		for written := 0; written < size; written += len(chunk) {
+			for i := range chunk {
+				chunk[i] = byte(written/len(chunk) + i)
+			}
+			want.Write(chunk)
+			if _, err := part.Write(chunk); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.CloseWithError(form.Close())
+	}()
+
+	req, _ := http.NewRequest("POST", "http://"+addr+"/upload", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Expect", "100-continue")
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}, Timeout: 30 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	if elapsed := time.Since(start); elapsed > 4*time.Second { // 客户端没有等到 100 Continue，直到超时才发送主体
+		t.Errorf("upload took %v", elapsed)
+	}
+	if expected := fmt.Sprintf("upload big.bin %d %x", size, want.Sum(nil)); resp.StatusCode != 200 || string(got) != expected {
+		t.Fatalf("response = %d %q, want %q", resp.StatusCode, got, expected)
+	}
+}
+
+func TestExpectContinueOrder(t *testing.T) {
+	addr := startServer(t, &Server{MaxBodySize: 1 << 10, Handler: handlerFunc(func(c *Conn) {
+		body, err := c.Message.ReadBody()
+		if err != nil {
+			c.WriteResponse(400, "Bad Request", []byte(err.Error()))
+			return
+		}
+		c.WriteResponse(200, "OK", body)
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("interim response = %q, %v", line, err)
+	}
+	if blank, _ := reader.ReadString('\n'); blank != "\r\n" {
+		t.Fatalf("interim response not terminated: %q", blank)
+	}
+	io.WriteString(conn, "hello")
+	if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != "hello" {
+		t.Fatalf("response = %d %q", resp.StatusCode, body)
+	}
+
+	// 超过 MaxBodySize 的主体在发送 100 Continue 之前就被拒绝
+	conn = dial(t, addr)
+	reader = bufio.NewReader(conn)
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 4096\r\n\r\n")
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 413 {
+		t.Fatalf("status = %d, want 413", resp.StatusCode)
+	}
+}
+
+func TestPartialHeaderTimeout(t *testing.T) {
+	addr := startServer(t, &Server{ReadHeaderTimeout: 100 * time.Millisecond, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte("OK"))
+	})})
+
+	// 已经收到一部分请求头，超时后回复 408 再关闭
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nX-Partial: ")
+	reader := bufio.NewReader(conn)
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 408 {
+		t.Fatalf("status = %d, want 408", resp.StatusCode)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+		t.Fatalf("connection not closed after 408: %q, %v", rest, err)
+	}
+
+	// 在两个请求之间空闲的连接直接关闭，不回复 408
+	conn = dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	reader = bufio.NewReader(conn)
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+		t.Fatalf("idle connection got %q, %v", rest, err)
+	}
+}
+
+// connectionHeader 返回响应的 Connection 头部，http.ReadResponse 把 "close" 移到了 resp.Close 中
+func connectionHeader(resp *http.Response) string {
+	if resp.Close {
+		return "close"
+	}
+	return resp.Header.Get("Connection")
+}
+
+func TestKeepAliveHeaders(t *testing.T) {
+	addr := startServer(t, &Server{IdleTimeout: 5 * time.Second, MaxRequestsPerConn: 3, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte("OK"))
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	for i, want := range []struct{ connection, keepAlive string }{
+		{"keep-alive", "timeout=5, max=2"},
+		{"keep-alive", "timeout=5, max=1"},
+		{"close", ""},
+	} {
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, _ := readResponse(t, reader)
+		if got := connectionHeader(resp); got != want.connection {
+			t.Errorf("request %d: Connection = %q, want %q", i+1, got, want.connection)
+		}
+		if got := resp.Header.Get("Keep-Alive"); got != want.keepAlive {
+			t.Errorf("request %d: Keep-Alive = %q, want %q", i+1, got, want.keepAlive)
+		}
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 { // 达到 max 之后服务器关闭连接
+		t.Fatalf("connection not closed after max requests: %q, %v", rest, err)
+	}
+}
+
+func TestKeepAliveClientHints(t *testing.T) {
+	addr := startServer(t, &Server{IdleTimeout: time.Minute, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte("OK"))
+	})})
+
+	for _, tt := range []struct {
+		request, connection, keepAlive string
+		closed                         bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: x\r\nKeep-Alive: timeout=10, max=5\r\n\r\n", "keep-alive", "timeout=10, max=4", false},
+		{"GET / HTTP/1.1\r\nHost: x\r\nKeep-Alive: max=1\r\n\r\n", "close", "", true},
+		{"GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n", "close", "", true},
+		{"GET / HTTP/1.0\r\n\r\n", "close", "", true},
+		{"GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", "keep-alive", "timeout=60", false},
+	} {
+		conn := dial(t, addr)
+		io.WriteString(conn, tt.request)
+		reader := bufio.NewReader(conn)
+		resp, _ := readResponse(t, reader)
+		if got := connectionHeader(resp); got != tt.connection {
+			t.Errorf("%q: Connection = %q, want %q", tt.request, got, tt.connection)
+		}
+		if got := resp.Header.Get("Keep-Alive"); got != tt.keepAlive {
+			t.Errorf("%q: Keep-Alive = %q, want %q", tt.request, got, tt.keepAlive)
+		}
+		if tt.closed {
+			if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+				t.Errorf("%q: connection not closed: %q, %v", tt.request, rest, err)
+			}
+		}
+	}
+}
+
+func TestParseKeepAlive(t *testing.T) {
+	for value, want := range map[string]struct {
+		timeout time.Duration
+		max     int
+	}{
+		"timeout=5, max=100":  {5 * time.Second, 100},
+		"max=3":               {0, 3},
+		"Timeout=2":           {2 * time.Second, 0},
+		"timeout=abc, max=-1": {0, 0},
+		"":                    {0, 0},
+	} {
+		timeout, max := parseKeepAlive(value)
+		if timeout != want.timeout || max != want.max {
+			t.Errorf("parseKeepAlive(%q) = %v, %d, want %v, %d", value, timeout, max, want.timeout, want.max)
+		}
+	}
+}
+
+// upgrade 在 conn 上完成一个WebSocket握手，返回之后用于读取服务器帧的 reader
+func upgrade(t *testing.T, conn net.Conn) *bufio.Reader {
+	t.Helper()
+	io.WriteString(conn, webSocketHandshake(""))
+	reader := bufio.NewReader(conn)
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 101 {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	return reader
+}
+
+// echoWebSocket 升级连接后回显收到的每一条消息，读取出错时把错误发送到 errs
+func echoWebSocket(errs chan<- error) Handler {
+	return handlerFunc(func(c *Conn) {
+		if err := c.UpgradeToWebSocket(); err != nil {
+			errs <- err
+			return
+		}
+		for {
+			op, payload, err := c.ReadWebSocketMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.WriteWebSocketMessage(op, payload)
+		}
+	})
+}
+
+func TestWebSocketOutlivesHTTPIdleTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{IdleTimeout: 50 * time.Millisecond, WebSocketIdleTimeout: 2 * time.Second, Handler: echoWebSocket(errs)})
+	conn := dial(t, addr)
+	reader := upgrade(t, conn)
+
+	time.Sleep(200 * time.Millisecond) // 远远超过HTTP的空闲超时
+	conn.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("still here")))
+	if _, payload := readServerFrame(t, reader); string(payload) != "still here" {
+		t.Fatalf("echo = %q", payload)
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("handler stopped: %v", err)
+	default:
+	}
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{IdleTimeout: time.Minute, WebSocketIdleTimeout: 150 * time.Millisecond, Handler: echoWebSocket(errs)})
+	conn := dial(t, addr)
+	reader := upgrade(t, conn)
+
+	// 每一个帧都会让空闲计时重新开始
+	for i := 0; i < 4; i++ {
+		time.Sleep(75 * time.Millisecond)
+		conn.Write(BuildFrame(true, WebSocketFrameOpCodePing, true, nil))
+		if op, _ := readServerFrame(t, reader); op != WebSocketFrameOpCodePong {
+			t.Fatalf("reply opcode = %d, want pong", op)
+		}
+	}
+
+	// 安静的连接在 WebSocketIdleTimeout 之后读取超时，而不是等待HTTP的 IdleTimeout
+	select {
+	case err := <-errs:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatalf("read err = %v, want a timeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("quiet WebSocket connection was not timed out")
+	}
+}
+
+func TestWebSocketHandshakeTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{IdleTimeout: time.Minute, WebSocketHandshakeTimeout: 100 * time.Millisecond, Handler: echoWebSocket(errs)})
+
+	// 握手请求头只发送了一部分
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\n")
+	start := time.Now()
+	reader := bufio.NewReader(conn)
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 408 {
+		t.Fatalf("status = %d, want 408", resp.StatusCode)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+		t.Fatalf("connection not closed: %q, %v", rest, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stalled handshake dropped after %v", elapsed)
+	}
+
+	// 握手请求声明了主体却一直不发送，UpgradeToWebSocket 丢弃主体时超时
+	conn = dial(t, addr)
+	handshake := webSocketHandshake("0123456789")
+	io.WriteString(conn, handshake[:len(handshake)-5])
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("UpgradeToWebSocket() succeeded with a stalled body")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("UpgradeToWebSocket() did not time out")
+	}
+	if data, _ := io.ReadAll(conn); len(data) > 0 {
+		if resp, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil); resp == nil || resp.StatusCode == 101 {
+			t.Fatalf("unexpected reply %q", data)
+		}
+	}
+}
+
+func TestConcurrentRequestsDoNotShareState(t *testing.T) {
+	const n = 100
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		c.Set("id", c.Message.Header("X-Id"))
+		time.Sleep(10 * time.Millisecond) // 让所有请求的处理重叠在一起
+		id, _ := c.Get("id")
+		c.WriteResponse(200, "OK", []byte(fmt.Sprintf("%s %s %s", c.Message.Path(), c.Message.Header("X-Id"), id)))
+	})})
+
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		i := i
+		go func() {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://%s/item/%d", addr, i), nil)
+			req.Header.Set("X-Id", fmt.Sprint(i))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if want := fmt.Sprintf("/item/%d %d %d", i, i, i); string(body) != want {
+				errs <- fmt.Errorf("request %d got %q, want %q", i, body, want)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestKeepAliveRequestsDoNotShareState(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		previous, _ := c.Get("path")
+		c.Set("path", c.Message.Path())
+		c.WriteResponse(200, "OK", []byte(fmt.Sprint(previous)))
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	for _, path := range []string{"/first", "/second"} {
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		if _, body := readResponse(t, reader); body != "<nil>" {
+			t.Fatalf("GET %s saw data %q from the previous request", path, body)
+		}
+	}
+}
+
+func TestRequestSplitAcrossWrites(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		body, err := c.Message.ReadBody()
+		if err != nil {
+			c.WriteResponse(400, "Bad Request", []byte(err.Error()))
+			return
+		}
+		c.WriteResponse(200, "OK", []byte(fmt.Sprintf("%s %d %d", c.Message.Path(), len(c.Message.Header("Cookie")), len(body))))
+	})})
+
+	cookie := strings.Repeat("c", 10000)
+	request := "POST /upload HTTP/1.1\r\nHost: x\r\nCookie: " + cookie + "\r\nContent-Length: 3000\r\n\r\n" + strings.Repeat("b", 3000) +
+		"GET /next HTTP/1.1\r\nHost: x\r\n\r\n"
+	conn := dial(t, addr)
+	go func() {
+		for len(request) > 0 { // 请求被拆成很多次写入，第二个请求和第一个请求的主体在同一次写入中
+			n := 700
+			if n > len(request) {
+				n = len(request)
+			}
+			io.WriteString(conn, request[:n])
+			request = request[n:]
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"/upload 10000 3000", "/next 0 0"} {
+		if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != want {
+			t.Fatalf("response = %d %q, want %q", resp.StatusCode, body, want)
+		}
+	}
+}
+
+// writeRecorder 记录服务器对连接的每一次写入
+type writeRecorder struct {
+	net.Listener
+	mu     sync.Mutex
+	writes []string
+}
+
+func (l *writeRecorder) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &recordedConn{Conn: conn, l: l}, nil
+}
+
+// recorded 返回到目前为止记录的写入
+func (l *writeRecorder) recorded() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.writes...)
+}
+
+type recordedConn struct {
+	net.Conn
+	l *writeRecorder
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	c.l.mu.Lock()
+	c.l.writes = append(c.l.writes, string(p))
+	c.l.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// startRecordedServer 和 startServer 相同，同时返回记录服务器写入的监听器
+func startRecordedServer(t *testing.T, s *Server) (string, *writeRecorder) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &writeRecorder{Listener: ln}
+	go s.Serve(recorder)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String(), recorder
+}
+
+func TestPipelinedResponsesBounded(t *testing.T) {
+	const requests = 7
+	addr, recorder := startRecordedServer(t, &Server{MaxPipelinedRequests: 3, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte(c.Message.Path()))
+	})})
+
+	conn := dial(t, addr)
+	var pipeline strings.Builder
+	for i := 0; i < requests; i++ {
+		fmt.Fprintf(&pipeline, "GET /%d HTTP/1.1\r\nHost: x\r\n\r\n", i)
+	}
+	io.WriteString(conn, pipeline.String()) // 所有请求在一次写入中到达
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i < requests; i++ {
+		if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != fmt.Sprintf("/%d", i) {
+			t.Fatalf("response %d = %d %q", i, resp.StatusCode, body)
+		}
+	}
+
+	// 每一次写入最多合并 MaxPipelinedRequests 个响应
+	total := 0
+	for _, w := range recorder.recorded() {
+		n := strings.Count(w, "HTTP/1.1 200")
+		if n > 3 {
+			t.Errorf("one write carried %d responses, want at most 3", n)
+		}
+		total += n
+	}
+	if total != requests {
+		t.Fatalf("recorded %d responses, want %d", total, requests)
+	}
+}
+
+func TestPipelinedExpectContinueOrder(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		body, _ := c.Message.ReadBody()
+		c.WriteResponse(200, "OK", []byte(c.Message.Path()+" "+string(body)))
+	})})
+
+	conn := dial(t, addr)
+	// 第二个请求在第一个请求的响应还在缓冲区中时等待 100 Continue
+	io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: x\r\n\r\n"+
+		"POST /second HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != "/first " {
+		t.Fatalf("first response = %d %q, want the response to /first before 100 Continue", resp.StatusCode, body)
+	}
+	if line, _ := reader.ReadString('\n'); line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("interim response = %q", line)
+	}
+	reader.ReadString('\n')
+	io.WriteString(conn, "hello")
+	if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != "/second hello" {
+		t.Fatalf("second response = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestExpectContinueBodyAlreadySent(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		if c.Message.Path() == "/ignore" { // 不读取主体
+			c.WriteResponse(200, "OK", []byte("ignored"))
+			return
+		}
+		body, _ := c.Message.ReadBody()
+		c.WriteResponse(200, "OK", body)
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	for _, tt := range []struct{ path, sent, body string }{
+		{"/read", "hello", "hello"},
+		{"/ignore", "hello", "ignored"}, // 客户端没有等待，没有读取的主体被丢弃，连接仍然可以读取下一个请求
+		{"/read", "again", "again"},
+	} {
+		// 客户端发送了 Expect: 100-continue，但是没有等待就紧接着发送了主体
+		io.WriteString(conn, "POST "+tt.path+" HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"+tt.sent)
+		resp, body := readResponse(t, reader)
+		if resp.StatusCode == 100 {
+			t.Fatalf("POST %s: got 100 Continue after the body was sent", tt.path)
+		}
+		if resp.StatusCode != 200 || body != tt.body || resp.Close {
+			t.Fatalf("POST %s: response = %d %q, close=%v", tt.path, resp.StatusCode, body, resp.Close)
+		}
+	}
+}
+
+func TestSetHandlerWhileServing(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	version := func(name string, block bool) Handler {
+		return handlerFunc(func(c *Conn) {
+			if block {
+				started <- struct{}{}
+				<-release
+			}
+			c.WriteResponse(200, "OK", []byte(name))
+		})
+	}
+	s := &Server{Handler: version("old", true)}
+	addr := startServer(t, s)
+
+	// 正在处理的请求继续使用旧的处理器
+	inFlight := dial(t, addr)
+	io.WriteString(inFlight, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+	s.SetHandler(version("new", false))
+	close(release)
+	if _, body := readResponse(t, bufio.NewReader(inFlight)); body != "old" {
+		t.Fatalf("in-flight request answered by %q", body)
+	}
+
+	// 并发的请求在不断替换处理器时都能得到响应
+	const n = 50
+	s.SetHandler(version("v0", false))
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.SetHandler(version(fmt.Sprint("v", i%2), false))
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	defer close(done)
+
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			resp, err := http.Get("http://" + addr + "/")
+			if err != nil {
+				errs <- err
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != 200 || (string(body) != "v0" && string(body) != "v1") {
+				errs <- fmt.Errorf("response = %d %q", resp.StatusCode, body)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBareLFRequest(t *testing.T) {
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		body, _ := c.Message.ReadBody()
+		c.WriteResponse(200, "OK", []byte(c.Message.Path()+" "+c.Message.Header("Host")+" "+string(body)))
+	})})
+	conn := dial(t, addr)
+	io.WriteString(conn, "POST /lf HTTP/1.1\nHost: example.com\nContent-Length: 2\n\nhiGET /next HTTP/1.1\nHost: x\n\n")
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"/lf example.com hi", "/next x "} {
+		if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != want {
+			t.Fatalf("response = %d %q, want %q", resp.StatusCode, body, want)
+		}
+	}
+}
+
+func TestMisdirectedRequest(t *testing.T) {
+	addr := startServer(t, &Server{AllowedHosts: []string{"example.com", "*.example.org"}, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte("OK"))
+	})})
+
+	for host, want := range map[string]int{
+		"example.com":        200,
+		"EXAMPLE.com:8080":   200,
+		"api.example.org":    200,
+		"example.org":        421, // 通配符不匹配域名本身
+		"evil.com":           421,
+		"example.com.evil.c": 421,
+	} {
+		conn := dial(t, addr)
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, _ := readResponse(t, reader)
+		if resp.StatusCode != want {
+			t.Errorf("Host %s: status = %d, want %d", host, resp.StatusCode, want)
+		}
+		if want == 421 {
+			if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 || !resp.Close {
+				t.Errorf("Host %s: connection not closed after 421: %q, %v", host, rest, err)
+			}
+		}
+	}
+}
+
+func TestHeaderLargerThanReadBuffer(t *testing.T) {
+	const limit = 16 << 10
+	addr := startServer(t, &Server{MaxHeaderBytes: limit, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte(strconv.Itoa(len(c.Message.Header("Cookie")))))
+	})})
+
+	// 比 bufio.Reader 默认的4096字节缓冲区大得多的头部在限制之内仍然被完整读取
+	prefix := "GET / HTTP/1.1\r\nHost: x\r\nCookie: "
+	fits := limit - len(prefix) - len("\r\n\r\n")
+	for _, tt := range []struct {
+		cookie int
+		code   int
+	}{
+		{8 << 10, 200},
+		{fits, 200},
+		{fits + 1, 431},
+	} {
+		conn := dial(t, addr)
+		io.WriteString(conn, prefix+strings.Repeat("c", tt.cookie)+"\r\n\r\n")
+		resp, body := readResponse(t, bufio.NewReader(conn))
+		if resp.StatusCode != tt.code || (tt.code == 200 && body != strconv.Itoa(tt.cookie)) {
+			t.Errorf("Cookie of %d bytes: %d %q, want %d", tt.cookie, resp.StatusCode, body, tt.code)
+		}
+	}
+}
+
+func TestHeaderLineTooLarge(t *testing.T) {
+	addr := startServer(t, &Server{MaxHeaderLineBytes: 256, Handler: handlerFunc(func(c *Conn) {
+		c.WriteResponse(200, "OK", []byte("OK"))
+	})})
+
+	for _, tt := range []struct {
+		request string
+		code    int
+	}{
+		{"GET / HTTP/1.1\r\nHost: x\r\nX-Fine: " + strings.Repeat("a", 200) + "\r\n\r\n", 200},
+		{"GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 300) + "\r\n\r\n", 431},
+		{"GET /" + strings.Repeat("a", 300) + " HTTP/1.1\r\nHost: x\r\n\r\n", 431},
+	} {
+		conn := dial(t, addr)
+		io.WriteString(conn, tt.request)
+		reader := bufio.NewReader(conn)
+		resp, _ := readResponse(t, reader)
+		if resp.StatusCode != tt.code {
+			t.Errorf("request of %d bytes: status = %d, want %d", len(tt.request), resp.StatusCode, tt.code)
+		}
+		if tt.code == 431 {
+			if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 || !resp.Close {
+				t.Errorf("connection not closed after 431: %q, %v", rest, err)
+			}
+		}
+	}
+}
+
+func TestMaxWebSocketConns(t *testing.T) {
+	errs := make(chan error, 4)
+	addr := startServer(t, &Server{Handler: echoWebSocket(errs), MaxWebSocketConns: 1})
+
+	first := dial(t, addr)
+	upgrade(t, first)
+
+	// 第二个握手超过限制，得到 503 且不会升级
+	second := dial(t, addr)
+	defer second.Close()
+	io.WriteString(second, webSocketHandshake(""))
+	if resp, body := readResponse(t, bufio.NewReader(second)); resp.StatusCode != 503 || body != "Service Unavailable" {
+		t.Fatalf("over limit: %d %q", resp.StatusCode, body)
+	}
+	if err := <-errs; err != errTooManyWebSockets {
+		t.Fatalf("UpgradeToWebSocket() = %v, want %v", err, errTooManyWebSockets)
+	}
+
+	// 第一个连接关闭后名额被释放
+	first.Close()
+	<-errs
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn := dial(t, addr)
+		io.WriteString(conn, webSocketHandshake(""))
+		resp, _ := readResponse(t, bufio.NewReader(conn))
+		conn.Close()
+		if resp.StatusCode == 101 {
+			break
+		}
+		<-errs
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released, status = %d", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTeeBodyIncludesDiscardedRemainder(t *testing.T) {
+	sinks := make(chan *bytes.Buffer, 1)
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		if c.Message.Method() != "POST" {
+			c.WriteResponse(200, "OK", nil)
+			return
+		}
+		sink := &bytes.Buffer{}
+		if err := c.TeeBody(sink); err != nil {
+			t.Error(err)
+		}
+		c.Message.BodyReader.Read(make([]byte, 3)) // 只读一部分，剩下的由服务器丢弃
+		c.WriteResponse(200, "OK", []byte("ok"))
+		sinks <- sink
+	})}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	readResponse(t, reader)
+	// 下一个请求完成时，上一个请求剩余的主体一定已经被丢弃
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	readResponse(t, reader)
+	if sink := <-sinks; sink.String() != "hello world" {
+		t.Fatalf("sink = %q, want %q", sink, "hello world")
+	}
+}
+
+func TestNoBodyStatuses(t *testing.T) {
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		switch c.Message.Path() {
+		case "/103":
+			c.WriteResponse(103, "Early Hints", []byte("ignored"), map[string]string{"Link": "</a.css>; rel=preload"})
+			c.WriteResponse(200, "OK", []byte("after hints"))
+		case "/204":
+			c.WriteResponse(204, "No Content", []byte("ignored"))
+		case "/304":
+			c.WriteResponse(304, "Not Modified", []byte("ignored"))
+		case "/buffered-204":
+			w := c.BufferedWriter(4)
+			w.Status = 204
+			w.Write([]byte("longer than the threshold"))
+			w.Close()
+		default:
+			c.WriteResponse(200, "OK", []byte("last"))
+		}
+	})}
+	conn := dial(t, startServer(t, s))
+	for _, path := range []string{"/103", "/204", "/304", "/buffered-204", "/"} {
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+	}
+
+	// 直接检查原始字节：不能有主体的响应在空行之后紧接着下一个响应
+	var raw strings.Builder
+	reader := bufio.NewReader(conn)
+	for !strings.HasSuffix(raw.String(), "last") {
+		b, err := reader.ReadByte()
+		if err != nil {
+			t.Fatalf("read: %v after %q", err, raw.String())
+		}
+		raw.WriteByte(b)
+	}
+	responses := strings.Split(raw.String(), "HTTP/1.1 ")[1:]
+	if len(responses) != 6 {
+		t.Fatalf("got %d responses: %q", len(responses), raw.String())
+	}
+	for _, response := range responses {
+		status := response[:3]
+		if status == "200" {
+			continue
+		}
+		if !strings.HasSuffix(response, "\r\n\r\n") || strings.Contains(response, "ignored") || strings.Contains(response, "longer") {
+			t.Errorf("%s response has a body: %q", status, response)
+		}
+		if strings.Contains(response, "Content-Length") || strings.Contains(response, "Transfer-Encoding") || strings.Contains(response, "Content-Type") {
+			t.Errorf("%s response has framing headers: %q", status, response)
+		}
+	}
+	if !strings.Contains(responses[0], "Link: </a.css>; rel=preload") || !strings.HasSuffix(responses[1], "after hints") {
+		t.Errorf("103 then 200 = %q %q", responses[0], responses[1])
+	}
+}
+
+func TestChunkedRequestBody(t *testing.T) {
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		body, err := c.Message.ReadBody()
+		if err != nil {
+			c.WriteError(err)
+			return
+		}
+		c.WriteResponse(200, "OK", []byte(string(body)+"|"+c.Message.Header("Checksum")))
+	})}
+	conn := dial(t, startServer(t, s))
+	reader := bufio.NewReader(conn)
+	// 分成多次写入，块的边界和TCP段的边界不一致
+	for _, part := range []string{
+		"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nWi",
+		"ki\r\n5;ext=1\r\npedia\r\nE\r\n in\r\n\r\nchunks.\r\n",
+		"0\r\nChecksum: 42\r\n\r\n",
+	} {
+		io.WriteString(conn, part)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp, body := readResponse(t, reader); resp.StatusCode != 200 || body != "Wikipedia in\r\n\r\nchunks.|42" {
+		t.Fatalf("response = %d %q", resp.StatusCode, body)
+	}
+
+	// 尾部字段被完整读取，连接可以继续使用
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	if _, body := readResponse(t, reader); body != "|" {
+		t.Fatalf("second response = %q", body)
+	}
+
+	// 格式错误的块回复 400
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nnot-hex\r\n")
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 400 {
+		t.Fatalf("invalid chunk status = %d, want 400", resp.StatusCode)
+	}
+}
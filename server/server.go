@@ -4,6 +4,8 @@ package server
 import (
 	"bufio"
 	"bytes"
+	stdcontext "context"
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
@@ -14,15 +16,161 @@ import (
 	"log"
 	"math"
 	"net"
+	"net/textproto"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// connLocks 是 Conn 的锁和由它们保护的状态
+type connLocks struct {
+	mu sync.RWMutex // 保护 Data、升级和HTTP响应的写入，不会在阻塞的WebSocket读取期间被持有
+
+	// 升级之后帧的读取和写入分别加锁，一个协程阻塞在读取上时，另一个协程仍然可以写入（包括自动回复的pong帧）
+	readMu      sync.Mutex
+	writeMu     sync.Mutex
+	fragmenting bool // WriteWebSocketFragment 正在写入一个分片消息，由 writeMu 保护
+
+	wsIdleTimeout  time.Duration // 升级后读取WebSocket帧的空闲超时，来自 Server.WebSocketIdleTimeout，由 mu 保护
+	wsReadDeadline time.Time     // SetReadDeadline 设置的读取截止时间，由 mu 保护
+}
+
 type Conn struct {
 	Conn    net.Conn
 	Message *context.Context
 	Data    map[string]interface{}
-	mu      sync.RWMutex
+	reader  *bufio.Reader // 连接的缓冲读取器，由 Server 创建；请求和升级之后的所有帧都从它读取，不能重新创建，否则会丢失已经缓冲的字节
+
+	// 锁和由它们保护的状态，由 Server 创建；处理器和中间件之间按值传递的 Conn 副本共享同一份，不会各自复制出一把锁
+	*connLocks
+
+	remoteAddr net.Addr // PROXY 协议头部中记录的客户端地址
+
+	// response 记录已经写入的响应，由 Server 创建，中间件之间传递的 Conn 副本共享同一个记录
+	response *responseRecord
+
+	errorHandler ErrorHandler   // WriteError 使用的错误映射，来自 Server.ErrorHandler
+	templates    *TemplateSet   // Render 使用的模板，来自 Server.Templates
+	cache        *responseCache // WriteCached 使用的缓存，由 Server 的所有连接共享
+	tracker      *connTracker   // 记录连接状态，由 Server 创建
+
+	wsHandshakeDeadline time.Time          // 握手必须完成的时刻，来自 Server.WebSocketHandshakeTimeout，零值表示不限制
+	wsCompression       int                // 大于0时在握手中接受 permessage-deflate，值是压缩的最小消息长度，来自 Server.WebSocketCompression
+	deflate             *permessageDeflate // 握手中协商出的 permessage-deflate，没有协商时为 nil
+	legacyHixie         bool               // 接受旧的 Hixie-76 握手，来自 Server.WebSocketLegacyHixie
+	hixie               bool               // 连接是通过 Hixie-76 握手升级的，使用旧的帧格式
+
+	canonicalHeaders bool // 以规范的形式写出响应头部的名称，来自 Server.CanonicalHeaderKeys
+
+	ctx     stdcontext.Context // 请求的上下文
+	request *requestState      // 请求处理期间的共享状态，由 Server 创建，用于发现客户端断开连接
+}
+
+// responseRecord 记录一个请求的响应状态码和写入的主体长度
+type responseRecord struct {
+	status  int
+	bytes   int
+	headers map[string]string // 服务器添加到响应中的头部，例如 Connection 和 Keep-Alive
+	cookies []*Cookie         // SetCookie 和会话添加到响应中的 Cookie，每个写成一个 Set-Cookie 头部
+	timings []serverTiming    // AddServerTiming 记录的耗时，合并写成一个 Server-Timing 头部
+	close   bool              // 响应要求关闭连接
+
+	encoder ResponseEncoder // SetResponseEncoder 设置的响应主体编码器
+
+	// writer 是连接的缓冲写入器，由 Server 创建并在同一个连接的请求之间复用；响应先写入它，在请求结束或者 Flush 时才发送，
+	// 头部和主体（以及处理器的多次写入）被合并成尽量少的系统调用。为 nil 时直接写入连接
+	writer *bufio.Writer
+
+	captureLimit int    // 大于0时记录响应主体的前 captureLimit 个字节
+	captured     []byte // 记录下来的响应主体
+
+	mu     sync.Mutex // 保护响应的写入，处理器可能在另一个协程中写入（例如超时中间件）
+	sealed bool       // 响应已经被封存，之后的写入都会被丢弃
+}
+
+// ErrResponseSealed 表示响应已经被 WriteFinalResponse 封存（例如超时中间件已经回复了客户端），这次写入被丢弃
+var ErrResponseSealed = errors.New("response already sealed")
+
+// ErrResponseAlreadyWritten 表示这个请求已经写入了一个最终响应（或者已经切换了协议），再次写入会在连接上产生第二个响应，这次写入被丢弃
+var ErrResponseAlreadyWritten = errors.New("response already written")
+
+// written 返回是否已经写入了最终响应：1xx 的临时响应之后还可以写入最终响应，101 之后连接已经切换了协议，调用者需要持有 mu
+func (r *responseRecord) written() bool {
+	return r.status >= 200 || r.status == 101
+}
+
+// result 返回响应的状态码和是否要求关闭连接
+func (r *responseRecord) result() (status int, close bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status, r.close
+}
+
+// TeeBody 使处理器之后读取的请求主体同时被写入 w（例如审计日志），必须在读取主体之前调用，见 context.Context.TeeBody
+func (c *Conn) TeeBody(w io.Writer) error {
+	if c.Message == nil {
+		return nil
+	}
+	return c.Message.TeeBody(w)
+}
+
+// CaptureResponseBody 开始记录之后写入的响应主体，最多记录 limit 个字节，用于调试日志等中间件
+func (c *Conn) CaptureResponseBody(limit int) {
+	if c.response != nil {
+		c.response.captureLimit = limit
+	}
+}
+
+// CapturedResponseBody 返回 CaptureResponseBody 开始之后记录下来的响应主体
+func (c *Conn) CapturedResponseBody() []byte {
+	if c.response == nil {
+		return nil
+	}
+	return c.response.captured
+}
+
+// Written 返回这个请求是否已经写入了最终响应，中间件可以据此决定是否还需要写入一个默认的响应
+func (c *Conn) Written() bool {
+	if c.response == nil {
+		return false
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	return c.response.written()
+}
+
+// Status 返回已经写入的响应状态码，还没有写入响应时返回0
+func (c *Conn) Status() int {
+	if c.response == nil {
+		return 0
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	return c.response.status
+}
+
+// BytesWritten 返回已经写入的响应主体长度
+func (c *Conn) BytesWritten() int {
+	if c.response == nil {
+		return 0
+	}
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	return c.response.bytes
+}
+
+// Context 返回这个请求的上下文，超时中间件等会在请求需要停止时取消它，没有设置时返回 context.Background()
+func (c *Conn) Context() stdcontext.Context {
+	if c.ctx == nil {
+		return stdcontext.Background()
+	}
+	return c.ctx
+}
+
+// SetContext 替换这个请求的上下文，之后传递给下一个中间件的 Conn 副本会使用新的上下文
+func (c *Conn) SetContext(ctx stdcontext.Context) {
+	c.ctx = ctx
 }
 
 // Set 用于跨中间件设置值
@@ -46,48 +194,273 @@ func (c *Conn) Get(key string) (value interface{}, ok bool) {
 	return
 }
 
+// Abort 停止调用之后的中间件和处理器，已经在运行的中间件在 next 返回后仍然会继续执行。
+// 它通常在鉴权或校验失败、已经写入了错误响应之后调用，标记保存在 Data["aborted"] 中
+func (c *Conn) Abort() {
+	c.Set("aborted", true)
+}
+
+// IsAborted 返回是否已经调用了 Abort
+func (c *Conn) IsAborted() bool {
+	aborted, _ := c.Get("aborted")
+	return aborted == true
+}
+
+// Query 返回查询字符串中参数 key 的第一个值，经过URL解码，没有这个参数时返回空字符串，所有的值见 context.Context.Query
+func (c *Conn) Query(key string) string {
+	if c.Message == nil {
+		return ""
+	}
+	if values := c.Message.Query()[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// paramsKey 是路由捕获的路径参数在 Data 中的键
+const paramsKey = "params"
+
+// SetParams 保存路由从路径中捕获的参数，由 router.Router 在调用处理器之前设置
+func (c *Conn) SetParams(params map[string]string) {
+	c.Set(paramsKey, params)
+}
+
+// Param 返回路由模式中名称为 name 的路径参数的值，例如模式 "/users/:id" 匹配 "/users/42" 时 c.Param("id") 返回 "42"，没有这个参数时返回空字符串
+func (c *Conn) Param(name string) string {
+	params, _ := c.Get(paramsKey)
+	m, _ := params.(map[string]string)
+	return m[name]
+}
+
+// routePatternKey 是匹配的路由模式在 Data 中的键
+const routePatternKey = "routePattern"
+
+// SetRoutePattern 保存请求匹配的路由模式，由 router.Router 在调用处理器之前设置
+func (c *Conn) SetRoutePattern(pattern string) {
+	c.Set(routePatternKey, pattern)
+}
+
+// RoutePattern 返回请求匹配的路由模式，例如 "/users/:id" 而不是具体的路径 "/users/42"，适合作为指标和日志的标签；
+// 没有匹配任何路由（例如 404）时返回空字符串
+func (c *Conn) RoutePattern() string {
+	pattern, _ := c.Get(routePatternKey)
+	s, _ := pattern.(string)
+	return s
+}
+
+// RemoteAddr 返回客户端的地址，启用 PROXY 协议时返回头部中记录的地址
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ClientIP 返回客户端的 IP 地址，不包含端口
+func (c *Conn) ClientIP() string {
+	addr := c.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 // WriteResponse 将一个自定义的http响应写入到Conn中
+// 一个请求只能写入一个最终响应（可以在它之前写入 1xx 的临时响应），再次写入时返回 ErrResponseAlreadyWritten，不会写入任何内容
 func (c *Conn) WriteResponse(statusCode int, statusText string, body []byte, headers ...map[string]string) error {
 	// 对Conn加写锁
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.response != nil {
+		c.response.mu.Lock()
+		defer c.response.mu.Unlock()
+		if c.response.sealed { // 响应已经被封存，丢弃这次写入
+			return ErrResponseSealed
+		}
+		if c.response.written() { // 已经写入过最终响应，不能再写入第二个
+			return ErrResponseAlreadyWritten
+		}
+	}
+
+	return c.writeResponse(statusCode, statusText, body, headers...)
+}
+
+// WriteFinalResponse 在还没有写入过响应时写入一个响应，并封存这个请求的响应，之后的 WriteResponse 都会返回 ErrResponseSealed
+// 已经写入过响应或者已经封存时什么也不做，返回 false。它用于超时等需要抢在处理器之前回复、并阻止处理器之后再写入的场景
+func (c *Conn) WriteFinalResponse(statusCode int, statusText string, body []byte, headers ...map[string]string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.response == nil {
+		return c.writeResponse(statusCode, statusText, body, headers...) == nil
+	}
+
+	c.response.mu.Lock()
+	defer c.response.mu.Unlock()
+	if c.response.sealed || c.response.written() {
+		c.response.sealed = true
+		return false
+	}
+	c.response.sealed = true
+	return c.writeResponse(statusCode, statusText, body, headers...) == nil
+}
+
+// writeResponse 构造并写入响应，调用者需要持有锁
+func (c *Conn) writeResponse(statusCode int, statusText string, body []byte, headers ...map[string]string) error {
 	// 创建一个缓冲区来写入响应
 	var buf bytes.Buffer
 
+	// 写入状态行和头部，使用内容长度头；1xx、204 和 304 响应不能有主体，也不写入内容长度头
+	body, headers = c.encodeBody(statusCode, body, headers)
+	framing := fmt.Sprintf("Content-Length: %d", len(body))
+	if !bodyAllowed(statusCode) {
+		body, framing = nil, ""
+	}
+	c.writeHead(&buf, statusCode, statusText, body, framing, headers)
+
+	// 写入主体，HEAD 请求的响应保留和 GET 相同的头部（包括 Content-Length），但不发送主体
+	if c.headRequest() {
+		body = nil
+	}
+	buf.Write(body)
+
+	// 将缓冲区的内容写入到连接的缓冲写入器中，1xx 的临时响应需要立即发送
+	if _, err := c.output().Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if statusCode < 200 {
+		if err := c.flushOutput(); err != nil {
+			return err
+		}
+	}
+
+	// 记录响应的状态码和主体长度
+	c.recordResponse(statusCode, headers)
+	c.recordBody(body)
+
+	return nil
+}
+
+// writeHead 将状态行和头部写入 buf，framing 是表示主体长度的头部（Content-Length 或 Transfer-Encoding），body 用于检测内容类型
+// framing 为空表示响应不能有主体，这时既不写入长度头部也不检测内容类型
+func (c *Conn) writeHead(buf *bytes.Buffer, statusCode int, statusText string, body []byte, framing string, headers []map[string]string) {
 	// 写入状态行
-	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", statusCode, statusText)
+	fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", statusCode, statusText)
 
-	// 根据body的内容自动检测MIME类型
-	contentType := detectContentType(body)
+	// 如果用户没有自定义内容类型头，根据body的内容自动检测MIME类型并写入；没有主体的响应不需要检测
+	if framing != "" && !hasHeader(headers, "Content-Type") {
+		fmt.Fprintf(buf, "Content-Type: %s\r\n", detectContentType(body))
+	}
 
-	// 写入内容类型头
-	fmt.Fprintf(&buf, "Content-Type: %s\r\n", contentType)
+	// 写入表示主体长度的头部
+	if framing != "" {
+		fmt.Fprintf(buf, "%s\r\n", framing)
+	}
 
-	// 写入内容长度头
-	fmt.Fprintf(&buf, "Content-Length: %d\r\n", len(body))
+	// 写入服务器添加的头部，用户自定义了同名头部时以用户的为准
+	if c.response != nil {
+		for key, value := range c.response.headers {
+			if !hasHeader(headers, key) {
+				fmt.Fprintf(buf, "%s: %s\r\n", c.headerName(key), value)
+			}
+		}
+		for _, ck := range c.response.cookies {
+			fmt.Fprintf(buf, "Set-Cookie: %s\r\n", ck.String())
+		}
+		if len(c.response.timings) > 0 && !hasHeader(headers, "Server-Timing") {
+			fmt.Fprintf(buf, "Server-Timing: %s\r\n", formatServerTiming(c.response.timings))
+		}
+	}
 
 	// 写入用户自定义的其他头部，如果有的话
 	for _, header := range headers {
 		for key, value := range header {
 			// fmt.Printf("headers: %s: %s\r\n", key, value)
-			fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+			fmt.Fprintf(buf, "%s: %s\r\n", c.headerName(key), value)
 		}
 	}
 
 	// 写入一个空行来分隔头部和主体
-	fmt.Fprint(&buf, "\r\n")
+	fmt.Fprint(buf, "\r\n")
+}
 
-	// 写入主体
-	buf.Write(body)
+// headerName 返回写入响应时使用的头部名称，设置了 Server.CanonicalHeaderKeys 时是规范的形式，否则保持不变
+func (c *Conn) headerName(name string) string {
+	if c.canonicalHeaders {
+		return textproto.CanonicalMIMEHeaderKey(name)
+	}
+	return name
+}
 
-	// 将缓冲区的内容写入到Conn中
-	_, err := c.Conn.Write(buf.Bytes())
-	if err != nil {
-		return err
+// recordResponse 记录已经写入的响应的状态码和是否要求关闭连接，调用者需要持有锁
+func (c *Conn) recordResponse(statusCode int, headers []map[string]string) {
+	if c.response == nil {
+		return
 	}
+	c.response.status = statusCode
+	if headerValue(headers, "Connection") == "close" || c.response.headers["Connection"] == "close" {
+		c.response.close = true
+	}
+}
 
-	return nil
+// recordBody 记录已经写入的响应主体长度，需要时记录主体的内容，调用者需要持有锁
+func (c *Conn) recordBody(body []byte) {
+	if c.response == nil {
+		return
+	}
+	c.response.bytes += len(body)
+	if room := c.response.captureLimit - len(c.response.captured); room > 0 {
+		if len(body) < room {
+			room = len(body)
+		}
+		c.response.captured = append(c.response.captured, body[:room]...)
+	}
+}
+
+// headRequest 判断当前的请求是否是 HEAD 请求，它的响应只有状态行和头部
+func (c *Conn) headRequest() bool {
+	return c.Message != nil && c.Message.Method() == "HEAD"
+}
+
+// bodyAllowed 判断状态码为 statusCode 的响应是否可以有主体，1xx、204 No Content 和 304 Not Modified 不可以
+func bodyAllowed(statusCode int) bool {
+	return statusCode >= 200 && statusCode != 204 && statusCode != 304
+}
+
+// hasToken 判断逗号分隔的头部值中是否包含 token，不区分大小写
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHeader 判断用户自定义的头部中是否包含 name，不区分大小写
+func hasHeader(headers []map[string]string, name string) bool {
+	for _, header := range headers {
+		for key := range header {
+			if strings.EqualFold(key, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerValue 返回用户自定义的头部中 name 的值，转为小写，不区分名称的大小写
+func headerValue(headers []map[string]string, name string) string {
+	for _, header := range headers {
+		for key, value := range header {
+			if strings.EqualFold(key, name) {
+				return strings.ToLower(strings.TrimSpace(value))
+			}
+		}
+	}
+	return ""
 }
 
 // detectContentType 根据body的内容自动检测MIME类型
@@ -124,6 +497,9 @@ const (
 	// WebSocketFrameOpCodeMask 是用于表示操作码的位掩码，在WebSocket帧的第一个字节中
 	WebSocketFrameOpCodeMask = 0x0F
 
+	// WebSocketFrameRSVMask 是用于表示 RSV1-3 保留位的位掩码，在WebSocket帧的第一个字节中，没有协商扩展时它们必须为0
+	WebSocketFrameRSVMask = 0x70
+
 	// WebSocketFrameOpCodeText 是用于表示文本帧的操作码
 	WebSocketFrameOpCodeText = 0x01
 
@@ -147,14 +523,31 @@ const (
 
 	// WebSocketMaxPayloadLen 是WebSocket帧的最大有效载荷长度
 	WebSocketMaxPayloadLen = 1<<63 - 1
+
+	// WebSocketCloseTimeout 是 CloseWebSocket 等待对方回复关闭帧的最长时间
+	WebSocketCloseTimeout = 5 * time.Second
 )
 
 var (
 	errInvalidHandshake    = errors.New("invalid handshake")
 	errUnsupportedProtocol = errors.New("unsupported protocol")
-	errInvalidFrame        = errors.New("invalid frame")
+	errInvalidFrame        = protocolError("invalid frame") // 延续帧出现在错误的位置
+	errAlreadyWebSocket    = errors.New("connection is already a websocket")
+	errTooManyWebSockets   = errors.New("too many websocket connections")
+	errFragmentedControl   = errors.New("control frames cannot be fragmented")
+	errMessageInProgress   = errors.New("a fragmented message is being written")
 )
 
+// ErrNotWebSocket 表示在没有升级（或者升级失败）的连接上调用了WebSocket方法
+var ErrNotWebSocket = errors.New("not a websocket connection")
+
+// webSocketOpen 在持有 c.mu 的读锁时检查连接是否是一个没有关闭的WebSocket连接，检查之后立即释放锁，不在读写帧期间持有它
+func (c *Conn) webSocketOpen() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IsWebSocket()
+}
+
 // IsWebSocket 返回Conn是否已经升级为一个WebSocket连接，是则返回true，否则返回false
 func (c *Conn) IsWebSocket() bool {
 	// c.mu.RLock() // 对Conn加读锁
@@ -167,7 +560,21 @@ func (c *Conn) IsWebSocket() bool {
 }
 
 // UpgradeToWebSocket 将一个Conn升级为一个WebSocket连接，通过进行一个握手
+// 只有所有的检查都通过之后才会写入 101 响应。握手失败时它已经回复了对应的错误响应（400、426 或 503）并返回错误，
+// 连接仍然是一个普通的HTTP连接，处理器不需要再写入响应，也不能在这个连接上调用WebSocket方法（会返回 ErrNotWebSocket）
 func (c *Conn) UpgradeToWebSocket() error {
+	return c.upgradeToWebSocket(nil)
+}
+
+// UpgradeToWebSocketWithProtocols 与 UpgradeToWebSocket 相同，同时协商子协议：按照客户端在 Sec-WebSocket-Protocol 中列出的顺序，
+// 选择第一个同时出现在 protocols 中的子协议，在 101 响应中回复它，并可以通过 c.Get("ws_protocol") 取得（见 WebSocketProtocol）。
+// 没有共同支持的子协议时仍然完成握手，只是不回复 Sec-WebSocket-Protocol，由处理器决定是否关闭连接
+func (c *Conn) UpgradeToWebSocketWithProtocols(protocols ...string) error {
+	return c.upgradeToWebSocket(protocols)
+}
+
+// upgradeToWebSocket 完成握手，protocols 是服务器支持的子协议
+func (c *Conn) upgradeToWebSocket(protocols []string) error {
 	c.mu.Lock() // 对Conn加写锁
 	defer c.mu.Unlock()
 
@@ -176,74 +583,148 @@ func (c *Conn) UpgradeToWebSocket() error {
 		return errInvalidHandshake
 	}
 
+	// 同一个连接只能升级一次；中间件之间传递的是 Conn 的副本，所以还要检查共享的响应记录
+	if c.IsWebSocket() || c.Status() == 101 {
+		return errAlreadyWebSocket
+	}
+
 	if !strings.HasPrefix(c.Message.StartLine, "GET") || !strings.HasSuffix(c.Message.StartLine, "HTTP/1.1") { // 如果请求行不是GET / HTTP/1.1，返回错误
 		log.Printf("Context.StartLine != \"GET / HTTP/1.1\"\nreceved: %v\n", c.Message.StartLine)
-		return errInvalidHandshake
+		return c.rejectUpgrade(errInvalidHandshake)
 	}
 
-	if c.Message.Headers["Upgrade"] != "websocket" { // 如果Upgrade头不是websocket，返回错误
-		return errInvalidHandshake
+	// 头部名称和这两个头部的值都不区分大小写，浏览器可能发送 "Connection: keep-alive, Upgrade"
+	if !hasToken(c.Message.Header("Upgrade"), "websocket") { // 如果Upgrade头不包含websocket，返回错误
+		return c.rejectUpgrade(errInvalidHandshake)
 	}
 
-	if c.Message.Headers["Connection"] != "Upgrade" { // 如果Connection头不是Upgrade，返回错误
-		return errInvalidHandshake
+	if !hasToken(c.Message.Header("Connection"), "Upgrade") { // 如果Connection头不包含Upgrade，返回错误
+		return c.rejectUpgrade(errInvalidHandshake)
 	}
 
-	if c.Message.Headers["Sec-WebSocket-Version"] != WebSocketVersion { // 如果Sec-WebSocket-Version头不是13，返回错误
-		return errUnsupportedProtocol
+	if hixieHandshake(c) { // 启用了 Server.WebSocketLegacyHixie 时接受旧的 Hixie-76 握手
+		return c.upgradeHixie(protocols)
 	}
 
-	key := c.Message.Headers["Sec-WebSocket-Key"] // 获取Sec-WebSocket-Key头的值
-	if key == "" {                                // 如果没有这个头，返回错误
-		return errInvalidHandshake
+	if c.Message.Header("Sec-WebSocket-Version") != WebSocketVersion { // 如果Sec-WebSocket-Version头不是13，返回错误
+		return c.rejectUpgrade(errUnsupportedProtocol)
+	}
+
+	key := c.Message.Header("Sec-WebSocket-Key") // 获取Sec-WebSocket-Key头的值
+	if key == "" {                               // 如果没有这个头，返回错误
+		return c.rejectUpgrade(errInvalidHandshake)
+	}
+
+	if !c.wsHandshakeDeadline.IsZero() { // 丢弃主体和写入响应都不能超过握手的期限，客户端停止发送时放弃握手
+		c.Conn.SetDeadline(c.wsHandshakeDeadline)
+		defer c.Conn.SetDeadline(time.Time{})
+	}
+
+	if c.Message.BodyPartiallyRead() { // 请求主体只读了一部分，剩下的字节会和WebSocket帧混在一起，返回错误
+		return c.rejectUpgrade(context.ErrBodyPartiallyRead)
+	}
+	// 丢弃没有读取的请求主体，保证之后从帧的开头读取；保持的连接上之前请求的主体已经由 Server 丢弃，
+	// 缓冲读取器在请求之间复用，客户端紧跟在握手之后发送的帧不会丢失
+	if err := c.Message.DiscardBody(); err != nil {
+		return err
 	}
 
 	hash := sha1.Sum([]byte(key + WebSocketMagicString))      // 对key和魔术字符串进行SHA1哈希
 	responseKey := base64.StdEncoding.EncodeToString(hash[:]) // 对哈希结果进行Base64编码
 
-	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", responseKey) // 构造响应消息
+	if c.tracker != nil && !c.tracker.upgrade(c.tracker.s.MaxWebSocketConns) { // WebSocket连接数已经达到上限
+		return c.rejectUpgrade(errTooManyWebSockets)
+	}
+
+	if err := c.Flush(); err != nil { // 帧直接写入连接，之前缓冲的内容必须先发送
+		return err
+	}
+	protocol := selectSubprotocol(c.Message.HeaderValues("Sec-WebSocket-Protocol"), protocols)
+	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n", responseKey) // 构造响应消息
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	var deflate *permessageDeflate
+	if c.wsCompression > 0 {
+		if deflate = negotiateDeflate(c.Message.HeaderValues("Sec-WebSocket-Extensions"), c.wsCompression); deflate != nil {
+			response += "Sec-WebSocket-Extensions: " + deflate.responseExtension() + "\r\n"
+		}
+	}
+	response += "\r\n"
 
 	if _, err := c.Conn.Write([]byte(response)); err != nil { // 将响应消息写入到Conn中，如果出错，返回错误
 		return err
 	}
+	if c.response != nil { // 记录协议已经切换，处理器返回后服务器不再在这个连接上读取HTTP请求
+		c.response.mu.Lock()
+		c.response.status = 101
+		c.response.mu.Unlock()
+	}
 
 	if c.Data == nil {
 		c.Data = make(map[string]interface{})
 	}
 	c.Data["websocket"] = true // 将c.Data["websocket"]设置为true，表示已经升级为WebSocket连接
+	c.deflate = deflate
+	if protocol != "" {
+		c.Data["ws_protocol"] = protocol
+	}
 
 	return nil // 返回nil表示成功
 }
 
+// rejectUpgrade 回复握手失败对应的错误响应并返回 err，调用者需要持有 c.mu
+// 不支持的版本回复 426 并在 Sec-WebSocket-Version 头部中告诉客户端服务器支持的版本
+func (c *Conn) rejectUpgrade(err error) error {
+	if c.response != nil {
+		c.response.mu.Lock()
+		defer c.response.mu.Unlock()
+		if c.response.sealed || c.response.status != 0 { // 已经回复过客户端，不能再写入
+			return err
+		}
+	}
+
+	code := errorStatus(err)
+	text := StatusText(code)
+	var headers []map[string]string
+	if err == errUnsupportedProtocol {
+		headers = append(headers, map[string]string{"Sec-WebSocket-Version": WebSocketVersion})
+	}
+	c.writeResponse(code, text, []byte(text), headers...)
+	return err
+}
+
 // ReadWebSocketMessage 从一个WebSocket连接中读取一个消息，并返回它的操作码和有效载荷
+// 收到关闭帧时返回 WebSocketFrameOpCodeClose 和一个 *CloseError，其中有对方发送的状态码和原因
 func (c *Conn) ReadWebSocketMessage() (int, []byte, error) {
-	c.mu.RLock() // 对Conn加读锁
-	defer c.mu.RUnlock()
-
-	if !c.IsWebSocket() { // 如果不是一个WebSocket连接，返回错误
-		return 0, nil, errors.New("not a websocket connection")
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return 0, nil, ErrNotWebSocket
 	}
 
-	reader := bufio.NewReader(c.Conn) // 创建一个缓冲读取器
+	c.readMu.Lock() // 对读取加锁，写入不受影响
+	defer c.readMu.Unlock()
+	return c.readMessage(c.readFrame)
+}
 
-	var opCode int     // 声明一个变量用于存储操作码
-	var payload []byte // 声明一个切片用于存储有效载荷
+// readMessage 用 next 逐个读取帧，自动回复ping帧并重组分片，被压缩的消息在最后一个分片到达后解压，返回一个完整的消息
+func (c *Conn) readMessage(next func() (bool, bool, int, []byte, error)) (int, []byte, error) {
+	var opCode int      // 声明一个变量用于存储操作码
+	var payload []byte  // 声明一个切片用于存储有效载荷
+	var checked int     // 文本消息中已经确认是完整合法UTF-8的字节数，之后可能是被分片切开的一个字符
+	var compressed bool // 消息是否被 permessage-deflate 压缩，由第一个分片的 RSV1 位表示
 
 	for {
-		fin, op, data, err := readWebSocketFrame(reader) // 从读取器中读取一个帧，并获取它的fin位、操作码、有效载荷和错误
-		if err != nil {                                  // 如果出错，返回错误
-			if err != io.EOF {
-				fmt.Println(err)
-				return 0, nil, err
-			}
+		fin, rsv1, op, data, err := next() // 读取一个帧，并获取它的fin位、RSV1位、操作码、有效载荷和错误
+		if err != nil {                    // 如果出错，返回错误；连接关闭时返回 io.EOF
+			return 0, nil, err
 		}
 
-		if op == WebSocketFrameOpCodeClose { // 如果操作码是关闭帧，返回操作码、空有效载荷和EOF错误
-			return op, nil, io.EOF
+		if op == WebSocketFrameOpCodeClose { // 如果操作码是关闭帧，返回操作码、空有效载荷和包含状态码与原因的 *CloseError
+			return op, nil, parseClosePayload(data)
 		}
 
-		if op == WebSocketFrameOpCodePing { // 如果操作码是ping帧，发送一个pong帧给对方，并继续循环
-			if err := c.WriteWebSocketMessage(WebSocketFrameOpCodePong, nil); err != nil {
+		if op == WebSocketFrameOpCodePing { // 如果操作码是ping帧，发送一个携带相同有效载荷的pong帧给对方，并继续循环
+			if err := c.WriteWebSocketMessage(WebSocketFrameOpCodePong, data); err != nil {
 				return 0, nil, err
 			}
 			continue
@@ -253,12 +734,40 @@ func (c *Conn) ReadWebSocketMessage() (int, []byte, error) {
 			continue
 		}
 
-		if opCode == 0 { // 如果操作码还没有被赋值，将它设置为当前帧的操作码
-			opCode = op
+		if opCode == 0 { // 如果操作码还没有被赋值，将它设置为当前帧的操作码；消息不能以延续帧开始
+			if op == 0 {
+				return 0, nil, errInvalidFrame
+			}
+			opCode, compressed = op, rsv1
+		} else if op != 0 { // 分片之间只能出现延续帧
+			return 0, nil, errInvalidFrame
+		} else if rsv1 { // 只有第一个分片可以设置 RSV1
+			return 0, nil, errReservedBits
 		}
 
 		payload = append(payload, data...) // 将当前帧的有效载荷追加到总的有效载荷中
 
+		if compressed { // 压缩的消息只能在解压之后检查
+			if !fin {
+				continue
+			}
+			if payload, err = c.deflate.inflate(payload); err != nil {
+				return 0, nil, err
+			}
+			if opCode == WebSocketFrameOpCodeText && !utf8.Valid(payload) {
+				return 0, nil, ErrWebSocketInvalidUTF8
+			}
+			break
+		}
+
+		if opCode == WebSocketFrameOpCodeText { // 每个分片到达时就检查UTF-8，不合法的字节不需要等到消息结束才发现
+			n, ok := validUTF8Prefix(payload[checked:])
+			checked += n
+			if !ok || (fin && checked < len(payload)) { // 最后一个分片结束时不能留下不完整的字符
+				return 0, nil, ErrWebSocketInvalidUTF8
+			}
+		}
+
 		if fin { // 如果fin位为true，表示这是最后一个帧，跳出循环
 			break
 		}
@@ -267,158 +776,368 @@ func (c *Conn) ReadWebSocketMessage() (int, []byte, error) {
 	return opCode, payload, nil // 返回操作码、有效载荷和nil错误
 }
 
-// readWebSocketFrame 从一个WebSocket连接中读取一个帧，并返回它的fin位、操作码和有效载荷
-func readWebSocketFrame(reader *bufio.Reader) (bool, int, []byte, error) {
+// ReadWebSocketFrame 从一个WebSocket连接中读取一个帧，不进行分片重组，返回它的fin位、操作码和有效载荷
+// 控制帧（关闭、ping、pong）会原样返回而不会被自动处理，调用者需要自己回复pong和关闭帧；
+// 需要自动处理控制帧和重组分片时请使用 ReadWebSocketMessage。两者不要交替读取同一个消息的分片；
+// 协商了 permessage-deflate 时被压缩的消息按原样返回压缩后的字节
+func (c *Conn) ReadWebSocketFrame() (fin bool, opCode int, payload []byte, err error) {
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return false, 0, nil, ErrNotWebSocket
+	}
+
+	c.readMu.Lock() // 对读取加锁，写入不受影响
+	defer c.readMu.Unlock()
+	fin, _, opCode, payload, err = c.readFrame()
+	return fin, opCode, payload, err
+}
+
+// readFrame 从连接中读取一个帧，设置了 WebSocket 空闲超时时，每次读取前都会把读取截止时间延后（不会晚于 SetReadDeadline 设置的时刻）
+// 协商了 permessage-deflate 时 rsv1 表示消息被压缩
+func (c *Conn) readFrame() (fin, rsv1 bool, opCode int, payload []byte, err error) {
+	if deadline := c.frameDeadline(); !deadline.IsZero() {
+		c.Conn.SetReadDeadline(deadline)
+	}
+	return c.readFrameFrom(c.bufReader())
+}
+
+// readFrameFrom 从 reader 中读取一个帧，Hixie-76 连接按照旧的帧格式读取
+func (c *Conn) readFrameFrom(reader *bufio.Reader) (fin, rsv1 bool, opCode int, payload []byte, err error) {
+	if c.hixie {
+		return readHixieFrame(reader)
+	}
+	return readWebSocketFrame(reader, c.deflate != nil)
+}
+
+// bufReader 返回连接的缓冲读取器，在多次读取之间复用，避免丢失已经缓冲的字节（例如同一个TCP段中的多个帧）
+// 只有不经过 Server 直接构造的 Conn 才会在这里创建读取器，之后同样一直复用
+func (c *Conn) bufReader() *bufio.Reader {
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.Conn)
+	}
+	return c.reader
+}
+
+// readWebSocketFrame 从一个WebSocket连接中读取一个帧，并返回它的fin位、RSV1位、操作码和有效载荷
+// extensions 为 true 表示协商了使用 RSV1 位的扩展（permessage-deflate），否则所有的保留位都必须为0
+func readWebSocketFrame(reader *bufio.Reader, extensions bool) (bool, bool, int, []byte, error) {
 	b1, err := reader.ReadByte() // 读取第一个字节
 	if err != nil {              // 如果出错，返回错误
-		return false, 0, nil, err
+		return false, false, 0, nil, err
 	}
 
 	fin := b1&WebSocketFrameFinBit != 0          // 获取fin位的值
 	opCode := int(b1 & WebSocketFrameOpCodeMask) // 获取操作码的值
 
+	rsv1 := b1&webSocketFrameRSV1Bit != 0
+	if b1&WebSocketFrameRSVMask&^webSocketFrameRSV1Bit != 0 || (rsv1 && !extensions) { // 没有协商扩展，保留位必须为0
+		return false, false, 0, nil, errReservedBits
+	}
+	if !validOpCode(opCode) { // 保留的操作码
+		return false, false, 0, nil, errReservedOpCode
+	}
+	if rsv1 && isControl(opCode) { // 控制帧不会被压缩
+		return false, false, 0, nil, errReservedBits
+	}
+
 	b2, err := reader.ReadByte() // 读取第二个字节
 	if err != nil {              // 如果出错，返回错误
-		return false, 0, nil, err
+		return false, false, 0, nil, err
 	}
 
 	masked := b2&WebSocketFrameMaskBit != 0                // 获取MASK位的值
 	payloadLen := int64(b2 & WebSocketFramePayloadLenMask) // 获取有效载荷长度的值
 
+	if !masked { // 客户端发送给服务器的帧必须带有掩码
+		return false, false, 0, nil, errUnmaskedFrame
+	}
+	if isControl(opCode) { // 控制帧不能被分片，有效载荷不能超过125个字节（也就不能使用扩展长度）
+		if !fin {
+			return false, false, 0, nil, errControlFragment
+		}
+		if payloadLen > maxControlPayloadLen {
+			return false, false, 0, nil, errControlTooLong
+		}
+	}
+
 	if payloadLen == 126 { // 如果有效载荷长度为126，表示后面两个字节是扩展长度
 		b1, err := reader.ReadByte() // 读取第三个字节
 		if err != nil {              // 如果出错，返回错误
-			return false, 0, nil, err
+			return false, false, 0, nil, err
 		}
 		b2, err := reader.ReadByte() // 读取第四个字节
 		if err != nil {              // 如果出错，返回错误
-			return false, 0, nil, err
+			return false, false, 0, nil, err
 		}
 		payloadLen = int64(b1)<<8 | int64(b2) // 将两个字节合并为扩展长度的值
 	} else if payloadLen == 127 { // 如果有效载荷长度为127，表示后面八个字节是扩展长度
 		var b [8]byte
 		if _, err := io.ReadFull(reader, b[:]); err != nil { // 读取后面八个字节到数组中，如果出错，返回错误
-			return false, 0, nil, err
+			return false, false, 0, nil, err
 		}
 		payloadLen = int64(b[0])<<56 | int64(b[1])<<48 | int64(b[2])<<40 | int64(b[3])<<32 |
 			int64(b[4])<<24 | int64(b[5])<<16 | int64(b[6])<<8 | int64(b[7]) // 将八个字节合并为扩展长度的值
 	}
 
 	if payloadLen > WebSocketMaxPayloadLen { // 如果有效载荷长度超过限制，返回错误
-		return false, 0, nil, errors.New("payload length exceeds limit")
+		return false, false, 0, nil, errors.New("payload length exceeds limit")
 	}
 
-	var mask [4]byte
-	if masked { // 如果MASK位为true，表示后面四个字节是掩码
-		if _, err := io.ReadFull(reader, mask[:]); err != nil { // 读取后面四个字节到数组中，如果出错，返回错误
-			return false, 0, nil, err
-		}
+	var mask [4]byte                                        // 长度之后的四个字节是掩码
+	if _, err := io.ReadFull(reader, mask[:]); err != nil { // 读取后面四个字节到数组中，如果出错，返回错误
+		return false, false, 0, nil, err
 	}
 
 	payload := make([]byte, payloadLen)                     // 创建一个切片用于存储有效载荷
 	if _, err := io.ReadFull(reader, payload); err != nil { // 读取有效载荷到切片中，如果出错，返回错误
-		return false, 0, nil, err
+		return false, false, 0, nil, err
 	}
 
-	if masked { // 如果MASK位为true，表示需要对有效载荷进行异或运算
-		for i := range payload { // 遍历有效载荷的每个字节
-			payload[i] ^= mask[i%4] // 与掩码的对应字节进行异或运算
-		}
+	for i := range payload { // 遍历有效载荷的每个字节，与掩码的对应字节进行异或运算
+		payload[i] ^= mask[i%4]
 	}
 
-	return fin, opCode, payload, nil // 返回fin位、操作码、有效载荷和nil错误
+	return fin, rsv1, opCode, payload, nil // 返回fin位、RSV1位、操作码、有效载荷和nil错误
 }
 
 // WriteWebSocketMessage 将一个消息写入到连接中。
 func (c *Conn) WriteWebSocketMessage(opCode int, payload []byte) error {
-	// 锁定连接，防止并发写入。
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return ErrNotWebSocket
+	}
 
-	if !c.IsWebSocket() { // 如果不是一个WebSocket连接，返回错误
-		return errors.New("not a websocket connection")
+	// 锁定写入，防止并发写入的帧交错；正在阻塞读取的协程不会影响写入。
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.fragmenting && !isControl(opCode) { // 数据消息不能插入到一个分片消息的分片之间
+		return errMessageInProgress
+	}
+	return c.writeWebSocketFrame(opCode, payload)
+}
+
+// WriteWebSocketFragment 写入一个消息的一个分片，用于在不缓冲整个消息的情况下流式发送生成的数据：
+// 第一个分片使用 opCode，之后的分片自动使用延续帧（操作码为0，它们的 opCode 参数被忽略），fin 为 true 的分片结束这个消息。
+// 分片之间仍然可以用 WriteWebSocketMessage 发送控制帧（例如ping），但在消息结束之前写入其他数据消息会返回错误
+func (c *Conn) WriteWebSocketFragment(opCode int, payload []byte, fin bool) error {
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return ErrNotWebSocket
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.hixie { // Hixie-76 没有分片
+		return errHixieUnsupported
+	}
+	if c.fragmenting {
+		opCode = 0
+	} else if isControl(opCode) {
+		return errFragmentedControl
+	}
+	if _, err := c.Conn.Write(BuildFrame(fin, opCode, false, payload)); err != nil {
+		return err
+	}
+	c.fragmenting = !fin
+	return nil
+}
+
+// isControl 判断操作码是否是控制帧（关闭、ping、pong）的操作码
+func isControl(opCode int) bool {
+	return opCode >= WebSocketFrameOpCodeClose
+}
+
+// WSMessage 是 WriteWebSocketBatch 写入的一个消息
+type WSMessage struct {
+	OpCode  int
+	Payload []byte
+}
+
+// WriteWebSocketBatch 在一次加锁中写入多个消息，所有帧被合并到一个缓冲区中，只调用一次 net.Conn.Write，
+// 在发送很多小消息时可以减少系统调用。每个消息仍然使用自己的操作码和独立的帧，其他写入不会插入到这些消息之间。
+func (c *Conn) WriteWebSocketBatch(msgs []WSMessage) error {
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return ErrNotWebSocket
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.fragmenting {
+		return errMessageInProgress
 	}
 
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if c.hixie {
+			frame, err := hixieFrame(msg.OpCode, msg.Payload)
+			if err != nil {
+				return err
+			}
+			buf.Write(frame)
+			continue
+		}
+		payload, compressed := c.compress(msg.OpCode, msg.Payload)
+		buf.Write(buildFrame(true, compressed, msg.OpCode, false, payload))
+	}
+
+	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeWebSocketFrame 将一个未分片的帧写入到连接中，调用者需要持有 writeMu。
+func (c *Conn) writeWebSocketFrame(opCode int, payload []byte) error {
+	if c.hixie {
+		frame, err := hixieFrame(opCode, payload)
+		if err != nil || frame == nil {
+			return err
+		}
+		_, err = c.Conn.Write(frame)
+		return err
+	}
+
+	// 构造一个未分片、不使用掩码的帧，并写入到网络连接中；协商了 permessage-deflate 时数据消息可能被压缩
+	payload, compressed := c.compress(opCode, payload)
+	if _, err := c.Conn.Write(buildFrame(true, compressed, opCode, false, payload)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// BuildFrame 构造一个WebSocket帧的原始字节，用于测试帧的解析或者向连接写入精确的字节序列。
+// masked 为 true 时使用一个随机的掩码对负载进行掩码操作，和客户端发送的帧一样。
+func BuildFrame(fin bool, opCode int, masked bool, payload []byte) []byte {
+	return buildFrame(fin, false, opCode, masked, payload)
+}
+
+// buildFrame 构造一个WebSocket帧，rsv1 为 true 时设置 RSV1 位，表示消息被 permessage-deflate 压缩
+func buildFrame(fin, rsv1 bool, opCode int, masked bool, payload []byte) []byte {
 	// 创建一个缓冲区，用于存放websocket帧。
 	var buf bytes.Buffer
 
-	// 设置帧的第一个字节，包含fin位和操作码。
-	fin := 1 // 假设消息没有分片。
-	buf.WriteByte(byte(fin)<<7 | byte(opCode))
+	// 设置帧的第一个字节，包含fin位、RSV1位和操作码。
+	var b1 byte
+	if fin {
+		b1 = WebSocketFrameFinBit
+	}
+	if rsv1 {
+		b1 |= webSocketFrameRSV1Bit
+	}
+	buf.WriteByte(b1 | byte(opCode)&WebSocketFrameOpCodeMask)
 
 	// 设置帧的第二个字节，包含mask位和负载长度。
-	mask := 0                          // 不使用掩码。
+	var mask byte
+	if masked {
+		mask = WebSocketFrameMaskBit
+	}
 	payloadLen := uint64(len(payload)) // 获取负载长度，并转换为uint64类型。
 
 	if payloadLen < 126 {
 		// 使用7位来编码长度。
-		buf.WriteByte(byte(mask)<<7 | byte(payloadLen))
+		buf.WriteByte(mask | byte(payloadLen))
 	} else if payloadLen <= math.MaxUint16 {
 		// 使用16位来编码长度，并将长度字段设为126。
-		buf.WriteByte(byte(mask)<<7 | 126)
+		buf.WriteByte(mask | 126)
 		// 以网络字节序（大端）写入长度，使用uint16类型。
 		binary.Write(&buf, binary.BigEndian, uint16(payloadLen))
-	} else if payloadLen <= math.MaxUint32 {
-		// 使用32位来编码长度，并将长度字段设为127。
-		buf.WriteByte(byte(mask)<<7 | 127)
-		// 以网络字节序（大端）写入长度，使用uint32类型。
-		binary.Write(&buf, binary.BigEndian, uint32(payloadLen))
 	} else {
 		// 使用64位来编码长度，并将长度字段设为127。
-		buf.WriteByte(byte(mask)<<7 | 127)
+		buf.WriteByte(mask | 127)
 		// 以网络字节序（大端）写入长度，使用uint64类型。
 		binary.Write(&buf, binary.BigEndian, payloadLen)
 	}
 
-	// 写入负载，不进行掩码操作。
-	buf.Write(payload)
-
-	// 将缓冲区写入到网络连接中。
-	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
-		return err
+	if !masked {
+		// 写入负载，不进行掩码操作。
+		buf.Write(payload)
+		return buf.Bytes()
 	}
 
-	return nil
+	// 写入随机的掩码，然后写入与掩码进行异或运算后的负载。
+	var key [4]byte
+	rand.Read(key[:])
+	buf.Write(key[:])
+	for i, b := range payload {
+		buf.WriteByte(b ^ key[i%4])
+	}
+	return buf.Bytes()
 }
 
-// CloseWebSocket 关闭WebSocket连接
+// CloseWebSocket 以正常关闭（1000）关闭WebSocket连接，发送关闭帧后最多等待 WebSocketCloseTimeout 让对方回复关闭帧，再关闭底层连接
+// 完整的关闭握手让对方有机会处理完已经发送的消息，代价是关闭要多等待一个往返
 func (c *Conn) CloseWebSocket() error {
-	c.mu.Lock() // 对Conn加写锁
-	defer c.mu.Unlock()
+	return c.closeWebSocket(WebSocketCloseNormalClosure, "", true)
+}
+
+// CloseWebSocketNoWait 以正常关闭（1000）发送关闭帧后立即关闭底层连接，不等待对方回复
+// 关闭更快，但对方在收到关闭帧之前发送的消息会丢失，对方也可能看到连接被重置
+func (c *Conn) CloseWebSocketNoWait() error {
+	return c.closeWebSocket(WebSocketCloseNormalClosure, "", false)
+}
 
+// CloseWebSocketWithCode 与 CloseWebSocket 相同，但在关闭帧中发送状态码 code 和原因 reason，例如 WebSocketCloseGoingAway；
+// 原因最多123个字节，更长时被截断。1005 和 1006 等不能发送的状态码返回错误，这时连接不会被关闭
+func (c *Conn) CloseWebSocketWithCode(code int, reason string) error {
+	return c.closeWebSocket(code, reason, true)
+}
+
+// closeWebSocket 发送带有状态码和原因的关闭帧，wait 为 true 时等待对方回复关闭帧，然后关闭底层的net.Conn
+func (c *Conn) closeWebSocket(code int, reason string, wait bool) error {
+	payload, err := closePayload(code, reason)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()           // 对Conn加写锁
 	if !c.IsWebSocket() { // 如果不是一个WebSocket连接，返回错误
-		return errors.New("not a websocket connection")
+		c.mu.Unlock()
+		return ErrNotWebSocket
 	}
+	c.Data["websocket"] = false // 将c.Data["websocket"]设置为false，表示已经关闭WebSocket连接
+	c.mu.Unlock()
 
 	// Send a close frame to the peer 发送一个关闭帧给对方
-	if err := c.WriteWebSocketMessage(WebSocketFrameOpCodeClose, nil); err != nil { // 如果出错，返回错误
+	c.writeMu.Lock()
+	err = c.writeWebSocketFrame(WebSocketFrameOpCodeClose, payload)
+	c.writeMu.Unlock()
+	if err != nil { // 如果出错，关闭连接并返回错误
+		c.Conn.Close()
 		return err
 	}
 
-	// Wait for a close frame from the peer 等待对方回复一个关闭帧
-	for {
-		opCode, _, err := c.ReadWebSocketMessage() // 读取一个消息，并获取它的操作码和错误
-		if err != nil {                            // 如果出错，返回错误
-			return err
-		}
-		if opCode == WebSocketFrameOpCodeClose { // 如果操作码是关闭帧，跳出循环
-			break
+	if wait {
+		// Wait for a close frame from the peer 等待对方回复一个关闭帧，超时或出错时不再等待
+		// 先设置截止时间再加读锁，另一个协程正在进行的读取最晚也会在截止时间返回
+		c.Conn.SetReadDeadline(time.Now().Add(WebSocketCloseTimeout))
+		c.readMu.Lock()
+		defer c.readMu.Unlock()
+		for {
+			_, _, opCode, _, err := c.readFrameFrom(c.bufReader()) // 读取一个帧，丢弃对方在关闭前发送的数据帧
+			if err != nil || opCode == WebSocketFrameOpCodeClose {
+				break
+			}
 		}
 	}
 
 	// Close the underlying net.Conn 关闭底层的net.Conn
-	if err := c.Conn.Close(); err != nil { // 如果出错，返回错误
-		return err
-	}
-
-	c.Data["websocket"] = false // 将c.Data["websocket"]设置为false，表示已经关闭WebSocket连接
-
-	return nil // 返回nil表示成功
+	return c.Conn.Close()
 }
 
 // WebSocketHandleError 处理读取或写入WebSocket消息时发生的错误
 func (c *Conn) WebSocketHandleError(err error) {
-	if err == io.EOF { // 如果错误是EOF，表示对方关闭了连接
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) { // 对方发送了关闭帧，回复关闭帧后直接关闭，不需要再等待对方的关闭帧
+		fmt.Println("connection closed by peer:", closeErr.Code, closeErr.Reason)
+		c.CloseWebSocketNoWait()
+		return
+	} else if errors.Is(err, ErrWebSocketProtocol) { // 对方违反了协议，之后的字节无法再按帧读取，发送1002后直接关闭
+		fmt.Println("protocol error:", err)
+		c.closeWebSocket(WebSocketCloseProtocolError, "", false)
+		return
+	} else if errors.Is(err, ErrWebSocketInvalidUTF8) { // 文本消息不是合法的UTF-8，发送1007后直接关闭
+		fmt.Println("invalid payload:", err)
+		c.closeWebSocket(WebSocketCloseInvalidPayload, "", false)
+		return
+	} else if err == io.EOF { // 如果错误是EOF，表示对方没有发送关闭帧就关闭了连接
 		fmt.Println("connection closed by peer")
 	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() { // 如果错误是一个网络错误，并且是超时错误，表示连接超时
 		fmt.Println("connection timed out")
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/lvkeliang/httpws/context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// webSocketHandshake 返回一个带有 body 作为请求主体的握手请求
+func webSocketHandshake(body string) string {
+	request := "GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if body != "" {
+		request += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+	return request + "\r\n" + body
+}
+
+func TestUpgradeAfterPartialBodyRead(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		io.ReadFull(c.Message.BodyReader, make([]byte, 2))
+		errs <- c.UpgradeToWebSocket()
+	})})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, webSocketHandshake("abcdef"))
+	if err := <-errs; err != context.ErrBodyPartiallyRead {
+		t.Fatalf("UpgradeToWebSocket() err = %v, want ErrBodyPartiallyRead", err)
+	}
+	if resp, _ := readResponse(t, bufio.NewReader(conn)); resp.StatusCode == 101 {
+		t.Fatal("handshake completed after the body was partially read")
+	}
+}
+
+func TestUpgradeDiscardsUnreadBody(t *testing.T) {
+	type result struct {
+		payload []byte
+		bodyErr error
+		err     error
+	}
+	results := make(chan result, 1)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		if err := c.UpgradeToWebSocket(); err != nil {
+			results <- result{err: err}
+			return
+		}
+		_, payload, err := c.ReadWebSocketMessage()
+		_, bodyErr := c.Message.ReadBody()
+		results <- result{payload, bodyErr, err}
+	})})
+
+	conn := dial(t, addr)
+	// 握手请求的主体没有被读取，紧跟在它之后的帧仍然要从开头读取
+	io.WriteString(conn, webSocketHandshake("ignored body")+string(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("hi"))))
+	res := <-results
+	if res.err != nil || string(res.payload) != "hi" {
+		t.Fatalf("ReadWebSocketMessage() = %q, %v", res.payload, res.err)
+	}
+	if res.bodyErr != context.ErrBodyDiscarded {
+		t.Fatalf("ReadBody() after upgrade err = %v, want ErrBodyDiscarded", res.bodyErr)
+	}
+}
+
+func TestUpgradeAfterFullBodyRead(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		if body, err := c.Message.ReadBody(); err != nil || string(body) != "abc" {
+			errs <- err
+			return
+		}
+		errs <- c.UpgradeToWebSocket()
+	})})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, webSocketHandshake("abc"))
+	if err := <-errs; err != nil {
+		t.Fatalf("UpgradeToWebSocket() after ReadBody err = %v", err)
+	}
+	if resp, _ := readResponse(t, bufio.NewReader(conn)); resp.StatusCode != 101 {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+}
+
+func TestReadWebSocketFrameLengths(t *testing.T) {
+	for _, n := range []int{0, 1, 125, 126, 127, 65535, 65536, 70000} {
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		for _, op := range []int{WebSocketFrameOpCodeText, WebSocketFrameOpCodeBinary, 0} {
+			frame := BuildFrame(op != 0, op, true, payload)
+			fin, rsv1, gotOp, got, err := readWebSocketFrame(bufio.NewReader(bytes.NewReader(frame)), false)
+			if err != nil {
+				t.Fatalf("len %d op %d: %v", n, op, err)
+			}
+			if fin != (op != 0) || rsv1 || gotOp != op || !bytes.Equal(got, payload) {
+				t.Fatalf("len %d op %d: got fin=%v rsv1=%v op=%d len=%d", n, op, fin, rsv1, gotOp, len(got))
+			}
+		}
+	}
+}
+
+func TestReadWebSocketFrameErrors(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 126)
+	for _, tt := range []struct {
+		name       string
+		frame      []byte
+		extensions bool
+		err        error
+	}{
+		{"unmasked", BuildFrame(true, WebSocketFrameOpCodeText, false, []byte("hi")), false, errUnmaskedFrame},
+		{"reserved data opcode", BuildFrame(true, 3, true, nil), false, errReservedOpCode},
+		{"reserved control opcode", BuildFrame(true, 0xB, true, nil), false, errReservedOpCode},
+		{"fragmented ping", BuildFrame(false, WebSocketFrameOpCodePing, true, nil), false, errControlFragment},
+		{"long ping", BuildFrame(true, WebSocketFrameOpCodePing, true, long), false, errControlTooLong},
+		{"long close", BuildFrame(true, WebSocketFrameOpCodeClose, true, long), false, errControlTooLong},
+		{"RSV1 without extension", buildFrame(true, true, WebSocketFrameOpCodeText, true, nil), false, errReservedBits},
+		{"RSV1 on control frame", buildFrame(true, true, WebSocketFrameOpCodePing, true, nil), true, errReservedBits},
+		{"RSV2", append([]byte{0x80 | 0x20 | WebSocketFrameOpCodeText}, BuildFrame(true, 1, true, nil)[1:]...), true, errReservedBits},
+		{"truncated payload", BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("hello"))[:8], false, io.ErrUnexpectedEOF},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, _, err := readWebSocketFrame(bufio.NewReader(bytes.NewReader(tt.frame)), tt.extensions)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// 协商了扩展时 RSV1 表示压缩的数据帧
+	_, rsv1, _, _, err := readWebSocketFrame(bufio.NewReader(bytes.NewReader(buildFrame(true, true, WebSocketFrameOpCodeText, true, nil))), true)
+	if err != nil || !rsv1 {
+		t.Fatalf("RSV1 with extension: rsv1=%v err=%v", rsv1, err)
+	}
+}
+
+func TestReadWebSocketMessageFragments(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		frames  [][]byte
+		payload string
+		err     error
+	}{
+		{"ping between fragments", [][]byte{
+			BuildFrame(false, WebSocketFrameOpCodeText, true, []byte("hel")),
+			BuildFrame(true, WebSocketFrameOpCodePing, true, nil),
+			BuildFrame(true, 0, true, []byte("lo")),
+		}, "hello", nil},
+		{"continuation first", [][]byte{BuildFrame(true, 0, true, []byte("x"))}, "", errInvalidFrame},
+		{"data frame between fragments", [][]byte{
+			BuildFrame(false, WebSocketFrameOpCodeText, true, []byte("a")),
+			BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("b")),
+		}, "", errInvalidFrame},
+	} {
+		frames := tt.frames
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := newWebSocketConn(t)
+			go func() {
+				for _, frame := range frames {
+					client.Write(frame)
+				}
+			}()
+			go io.Copy(io.Discard, client) // 丢弃服务器回复的pong帧
+			_, payload, err := c.ReadWebSocketMessage()
+			if err != tt.err || string(payload) != tt.payload {
+				t.Fatalf("ReadWebSocketMessage() = %q, %v, want %q, %v", payload, err, tt.payload, tt.err)
+			}
+		})
+	}
+}
+
+func TestWriteWebSocketBatch(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	go c.WriteWebSocketBatch([]WSMessage{
+		{OpCode: WebSocketFrameOpCodeText, Payload: []byte("one")},
+		{OpCode: WebSocketFrameOpCodeBinary, Payload: []byte{2}},
+		{OpCode: WebSocketFrameOpCodeText, Payload: []byte("three")},
+	})
+	for _, want := range []struct {
+		op      int
+		payload string
+	}{{WebSocketFrameOpCodeText, "one"}, {WebSocketFrameOpCodeBinary, "\x02"}, {WebSocketFrameOpCodeText, "three"}} {
+		if op, payload := readServerFrame(t, client); op != want.op || string(payload) != want.payload {
+			t.Fatalf("frame = %d %q, want %d %q", op, payload, want.op, want.payload)
+		}
+	}
+}
+
+// discardTCPConn 返回一个本地 TCP 连接，另一端读到的数据都被丢弃；和 net.Pipe 不同，每次写入都是一次真正的系统调用
+func discardTCPConn(b *testing.B) net.Conn {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTCPWebSocketConn 返回一个建立在 discardTCPConn 上的已经升级的 Conn
+func newTCPWebSocketConn(b *testing.B) *Conn {
+	b.Helper()
+	return &Conn{Conn: discardTCPConn(b), Data: map[string]interface{}{"websocket": true}, connLocks: &connLocks{}}
+}
+
+// benchmarkBatchSize 是每次写入的小消息数
+const benchmarkBatchSize = 32
+
+func BenchmarkWriteWebSocketMessages(b *testing.B) {
+	c := newTCPWebSocketConn(b)
+	payload := []byte(`{"type":"tick","value":42}`)
+	b.SetBytes(int64(len(payload) * benchmarkBatchSize))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkBatchSize; j++ {
+			if err := c.WriteWebSocketMessage(WebSocketFrameOpCodeText, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkWriteWebSocketBatch(b *testing.B) {
+	c := newTCPWebSocketConn(b)
+	payload := []byte(`{"type":"tick","value":42}`)
+	msgs := make([]WSMessage, benchmarkBatchSize)
+	for i := range msgs {
+		msgs[i] = WSMessage{OpCode: WebSocketFrameOpCodeText, Payload: payload}
+	}
+	b.SetBytes(int64(len(payload) * benchmarkBatchSize))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteWebSocketBatch(msgs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTwoFramesInOneSegment(t *testing.T) {
+	errs := make(chan error, 1)
+	conn := dial(t, startServer(t, &Server{Handler: echoWebSocket(errs)}))
+	// 握手请求和第一个帧在同一次写入中，之后两个帧再合并成一次写入
+	io.WriteString(conn, webSocketHandshake("")+string(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("zero"))))
+	reader := bufio.NewReader(conn)
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 101 {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	conn.Write(append(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("one")), BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("two"))...))
+
+	for _, want := range []string{"zero", "one", "two"} {
+		if _, payload := readServerFrame(t, reader); string(payload) != want {
+			t.Fatalf("echo = %q, want %q", payload, want)
+		}
+	}
+}
+
+func TestTwoFramesInOneWriteWithoutServer(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	// 不经过 Server 构造的 Conn 由 bufReader 创建读取器，之后的读取必须复用它
+	c := &Conn{Conn: server, Data: map[string]interface{}{"websocket": true}, connLocks: &connLocks{}}
+	go client.Write(append(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("one")), BuildFrame(true, WebSocketFrameOpCodeBinary, true, []byte("two"))...))
+
+	for _, want := range []string{"one", "two"} {
+		if _, payload, err := c.ReadWebSocketMessage(); err != nil || string(payload) != want {
+			t.Fatalf("ReadWebSocketMessage() = %q, %v, want %q", payload, err, want)
+		}
+	}
+}
+
+func TestUpgradeAfterKeepAliveRequests(t *testing.T) {
+	errs := make(chan error, 2)
+	addr := startServer(t, &Server{Handler: handlerFunc(func(c *Conn) {
+		if c.Message.Path() != "/ws" {
+			c.WriteResponse(200, "OK", []byte(c.Message.Path())) // 不读取请求主体，由服务器丢弃
+			return
+		}
+		if err := c.UpgradeToWebSocket(); err != nil {
+			errs <- err
+			return
+		}
+		copied := *c // 中间件之间传递的是 Conn 的副本
+		errs <- copied.UpgradeToWebSocket()
+		errs <- c.UpgradeToWebSocket()
+		_, payload, err := c.ReadWebSocketMessage()
+		if err != nil {
+			return
+		}
+		c.WriteWebSocketMessage(WebSocketFrameOpCodeText, payload)
+	})})
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+	io.WriteString(conn, "GET /one HTTP/1.1\r\nHost: x\r\n\r\n")
+	readResponse(t, reader)
+	io.WriteString(conn, "POST /two HTTP/1.1\r\nHost: x\r\nContent-Length: 6\r\n\r\nunread")
+	if _, body := readResponse(t, reader); body != "/two" {
+		t.Fatalf("second response = %q", body)
+	}
+
+	// 握手之后紧跟着的帧在同一次写入中到达
+	io.WriteString(conn, webSocketHandshake("")+string(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("after keep-alive"))))
+	if resp, _ := readResponse(t, reader); resp.StatusCode != 101 {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != errAlreadyWebSocket {
+			t.Fatalf("second upgrade err = %v, want errAlreadyWebSocket", err)
+		}
+	}
+	if _, payload := readServerFrame(t, reader); string(payload) != "after keep-alive" {
+		t.Fatalf("echo = %q", payload)
+	}
+}
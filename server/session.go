@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+)
+
+// SessionIDGenerator 生成一个新的会话 ID，ID 必须不可预测，并且可以直接作为 Cookie 的值
+type SessionIDGenerator func() (string, error)
+
+// DefaultSessionIDGenerator 使用 crypto/rand 生成 32 个字节（256 位）的随机数，编码为 base64url 字符串
+func DefaultSessionIDGenerator() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SessionStore 保存会话数据，实现必须可以被并发调用
+type SessionStore interface {
+	Load(id string) (values map[string]interface{}, ok bool)
+	Save(id string, values map[string]interface{}) error
+	Delete(id string) error
+}
+
+// MemoryStore 是一个保存在内存中的 SessionStore，适合单进程部署和测试
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]map[string]interface{}
+}
+
+// NewMemoryStore 创建一个空的 MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]map[string]interface{})}
+}
+
+// Load 返回 id 对应的会话数据的副本
+func (s *MemoryStore) Load(id string) (map[string]interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	return copyValues(values), true
+}
+
+// Save 保存 id 对应的会话数据的副本
+func (s *MemoryStore) Save(id string, values map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = copyValues(values)
+	return nil
+}
+
+// Delete 删除 id 对应的会话
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		m[k] = v
+	}
+	return m
+}
+
+// SessionManager 从 Cookie 中加载会话并在请求结束时保存，通常通过 middleware.Sessions 使用
+type SessionManager struct {
+	Store SessionStore // 为 nil 时使用一个 MemoryStore
+
+	// GenerateID 生成新的会话 ID，为 nil 时使用 DefaultSessionIDGenerator，部署可以替换它来定制 ID 的长度和格式
+	GenerateID SessionIDGenerator
+
+	// Cookie 是会话 Cookie 的模板，Name 为空时使用 "session_id"，Value 会被替换为会话 ID
+	Cookie Cookie
+
+	once sync.Once
+}
+
+// Session 是一个请求的会话
+type Session struct {
+	id      string
+	values  map[string]interface{}
+	mu      sync.Mutex
+	manager *SessionManager
+}
+
+// ErrNoSession 表示这个请求没有经过会话中间件，没有会话
+var ErrNoSession = errors.New("no session")
+
+// sessionKey 是会话在 Data 中的键
+const sessionKey = "session"
+
+// init 设置零值字段的默认值
+func (m *SessionManager) init() {
+	m.once.Do(func() {
+		if m.Store == nil {
+			m.Store = NewMemoryStore()
+		}
+		if m.GenerateID == nil {
+			m.GenerateID = DefaultSessionIDGenerator
+		}
+		if m.Cookie.Name == "" {
+			m.Cookie.Name = "session_id"
+		}
+		if m.Cookie.Path == "" {
+			m.Cookie.Path = "/"
+		}
+	})
+}
+
+// Start 加载请求的 Cookie 中的会话，会话不存在时创建一个新的会话，并在响应中设置它的 Cookie
+// 客户端提供的不存在的 ID 不会被使用，防止会话固定攻击
+func (m *SessionManager) Start(c *Conn) (*Session, error) {
+	m.init()
+	s := &Session{manager: m}
+	if id, ok := c.Cookie(m.Cookie.Name); ok && id != "" {
+		if values, ok := m.Store.Load(id); ok {
+			s.id, s.values = id, values
+		}
+	}
+	if s.id == "" {
+		id, err := m.GenerateID()
+		if err != nil {
+			return nil, err
+		}
+		s.id, s.values = id, make(map[string]interface{})
+		m.setCookie(c, id)
+	}
+	c.Set(sessionKey, s)
+	return s, nil
+}
+
+// Save 将请求的会话保存到 Store 中
+func (m *SessionManager) Save(c *Conn) error {
+	s := c.Session()
+	if s == nil {
+		return ErrNoSession
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return m.Store.Save(s.id, s.values)
+}
+
+// setCookie 让之后写入的响应带有会话 Cookie
+func (m *SessionManager) setCookie(c *Conn, id string) {
+	cookie := m.Cookie
+	cookie.Value = id
+	c.addCookie(&cookie)
+}
+
+// Session 返回这个请求的会话，没有经过会话中间件时返回 nil
+func (c *Conn) Session() *Session {
+	v, _ := c.Get(sessionKey)
+	s, _ := v.(*Session)
+	return s
+}
+
+// RenewSession 为会话生成一个新的 ID，把数据迁移到新的 ID 下并在 Store 中删除旧的 ID，响应会带有新的会话 Cookie。
+// 在登录等权限变化之后调用它可以防止会话固定攻击；它必须在写入响应之前调用
+func (c *Conn) RenewSession() error {
+	s := c.Session()
+	if s == nil {
+		return ErrNoSession
+	}
+	m := s.manager
+
+	id, err := m.GenerateID()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := m.Store.Save(id, s.values); err != nil {
+		return err
+	}
+	if err := m.Store.Delete(s.id); err != nil {
+		return err
+	}
+	s.id = id
+	m.setCookie(c, id)
+	return nil
+}
+
+// ID 返回会话当前的 ID
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Get 返回会话中 key 对应的值
+func (s *Session) Get(key string) (value interface{}, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok = s.values[key]
+	return
+}
+
+// Set 设置会话中 key 对应的值，请求结束时由 SessionManager.Save 保存
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// Delete 删除会话中 key 对应的值
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	stdcontext "context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrServerClosed 是 Shutdown 或 Close 之后 Serve 和 ListenAndServe 返回的错误
+var ErrServerClosed = errors.New("server: server closed")
+
+const (
+	// shutdownPollInterval 是 Shutdown 检查空闲连接和正在处理的请求的间隔
+	shutdownPollInterval = 50 * time.Millisecond
+
+	// shutdownRetryAfter 是关闭期间拒绝新请求的 503 响应中 Retry-After 的秒数
+	shutdownRetryAfter = "1"
+)
+
+// Shutdown 优雅地关闭服务器：关闭所有监听器停止接受新的连接，等待正在处理的请求完成后关闭它们的连接，
+// 没有正在处理的请求时再关闭空闲的连接，所有连接都关闭后返回 nil。
+// 在这期间到达的新请求（包括保持的连接上的下一个请求）会收到带有 Retry-After 和 Connection: close 的 503 Service Unavailable，
+// 负载均衡器可以据此转向其他实例；WebSocket 连接会一直等待到处理器返回。
+// ctx 在此之前结束时返回 ctx.Err()，剩下的连接保持不变，可以再调用 Close 强制关闭
+func (s *Server) Shutdown(ctx stdcontext.Context) error {
+	s.startShutdown()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.closeIdleConns()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close 立即关闭服务器：关闭所有监听器和所有连接，不等待正在处理的请求，也不再回复 503
+func (s *Server) Close() error {
+	err := s.startShutdown()
+	s.shutdownMu.Lock()
+	s.stopped = true
+	for t := range s.conns {
+		t.conn.Close()
+	}
+	s.shutdownMu.Unlock()
+	return err
+}
+
+// startShutdown 标记服务器正在关闭并关闭所有监听器，返回关闭监听器时遇到的第一个错误
+func (s *Server) startShutdown() error {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	s.closing = true
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.listeners, l)
+	}
+	return err
+}
+
+// shuttingDown 返回是否已经调用了 Shutdown 或 Close
+func (s *Server) shuttingDown() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	return s.closing
+}
+
+// shutdownState 返回服务器是否正在关闭，以及是否已经被 Close 强制关闭
+func (s *Server) shutdownState() (closing, stopped bool) {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	return s.closing, s.stopped
+}
+
+// trackListener 记录 Serve 正在使用的监听器，服务器已经关闭时返回 false
+func (s *Server) trackListener(l net.Listener) bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// untrackListener 在 Serve 返回时移除监听器
+func (s *Server) untrackListener(l net.Listener) {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	delete(s.listeners, l)
+}
+
+// trackConn 记录一个新接受的连接，Shutdown 会等待它关闭；服务器已经关闭时返回 false
+// 检查和计数在同一把锁下进行，Shutdown 开始等待之后不会再有新的连接加入
+func (s *Server) trackConn(t *connTracker) bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*connTracker]struct{})
+	}
+	s.conns[t] = struct{}{}
+	s.active.Add(1)
+	return true
+}
+
+// untrackConn 在连接关闭时移除它的记录
+func (s *Server) untrackConn(t *connTracker) {
+	s.shutdownMu.Lock()
+	delete(s.conns, t)
+	s.shutdownMu.Unlock()
+	s.active.Done()
+}
+
+// closeIdleConns 在没有正在处理的请求时唤醒所有正在等待请求的连接，让它们的 serveRequest 返回并关闭连接。
+// 排空期间空闲的连接保持打开，它们收到的请求会得到 503，而不是在发送途中被断开；
+// 已经收到请求的连接会在读取请求头时重新设置读取期限，不受影响
+func (s *Server) closeIdleConns() {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	for t := range s.conns {
+		if !t.waiting() {
+			return
+		}
+	}
+	for t := range s.conns {
+		t.wakeIdle()
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bufio"
+	stdcontext "context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainReplies503(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		if c.Message.Path() == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		c.WriteResponse(200, "OK", []byte("done"))
+	})}
+	addr := startServer(t, s)
+
+	// 一个保持的空闲连接，和一个正在处理请求的连接
+	idle := dial(t, addr)
+	idleReader := bufio.NewReader(idle)
+	io.WriteString(idle, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	readResponse(t, idleReader)
+
+	busy := dial(t, addr)
+	io.WriteString(busy, "GET /slow HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(stdcontext.Background()) }()
+	time.Sleep(100 * time.Millisecond) // 服务器已经开始排空
+
+	// 排空期间保持的连接上的新请求收到 503，连接被关闭
+	io.WriteString(idle, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	resp, _ := readResponse(t, idleReader)
+	if resp.StatusCode != 503 || resp.Header.Get("Retry-After") != shutdownRetryAfter || !resp.Close {
+		t.Fatalf("request while draining = %d %v close=%v", resp.StatusCode, resp.Header, resp.Close)
+	}
+	expectClosed(t, idle, time.Second)
+
+	// 正在处理的请求照常完成，之后 Shutdown 返回
+	close(release)
+	if resp, body := readResponse(t, bufio.NewReader(busy)); resp.StatusCode != 200 || body != "done" {
+		t.Fatalf("in-flight request = %d %q", resp.StatusCode, body)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("Shutdown() = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown() did not return after the in-flight request finished")
+	}
+}
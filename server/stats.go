@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnState 表示一个连接所处的状态
+type ConnState int
+
+const (
+	StateNew       ConnState = iota // 刚刚被接受，还没有开始读取请求
+	StateIdle                       // 保持的连接正在等待下一个请求
+	StateReading                    // 正在读取请求头
+	StateActive                     // 处理器正在处理请求和写入响应
+	StateWebSocket                  // 已经升级为WebSocket连接
+	StateClosed                     // 已经关闭
+)
+
+// String 返回状态的名称
+func (s ConnState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateIdle:
+		return "idle"
+	case StateReading:
+		return "reading"
+	case StateActive:
+		return "active"
+	case StateWebSocket:
+		return "websocket"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// ServerStats 是某一时刻各个状态的连接数
+type ServerStats struct {
+	Open      int64 // 所有没有关闭的连接
+	Idle      int64
+	Reading   int64
+	Active    int64
+	WebSocket int64
+}
+
+// serverCounters 是按状态计数的原子计数器，下标是 ConnState
+type serverCounters [StateClosed]atomic.Int64
+
+// Stats 返回当前各个状态的连接数，读取是无锁的，可以频繁调用
+func (s *Server) Stats() ServerStats {
+	stats := ServerStats{
+		Idle:      s.counters[StateIdle].Load(),
+		Reading:   s.counters[StateReading].Load(),
+		Active:    s.counters[StateActive].Load(),
+		WebSocket: s.counters[StateWebSocket].Load(),
+	}
+	stats.Open = s.counters[StateNew].Load() + stats.Idle + stats.Reading + stats.Active + stats.WebSocket
+	return stats
+}
+
+// connTracker 记录一个连接的当前状态，在状态变化时更新计数器并调用 Server.ConnStateHook
+// 状态只由连接自己的协程修改，mu 让 Shutdown 可以同时读取它
+type connTracker struct {
+	s     *Server
+	conn  net.Conn
+	mu    sync.Mutex
+	state ConnState
+}
+
+// newConnTracker 创建一个处于 StateNew 状态的连接记录
+func (s *Server) newConnTracker(conn net.Conn) *connTracker {
+	t := &connTracker{s: s, conn: conn, state: StateNew}
+	s.counters[StateNew].Add(1)
+	if s.ConnStateHook != nil {
+		s.ConnStateHook(conn, StateNew)
+	}
+	return t
+}
+
+// set 将连接切换到 state 状态
+func (t *connTracker) set(state ConnState) {
+	t.mu.Lock()
+	if t.state == state || t.state == StateClosed {
+		t.mu.Unlock()
+		return
+	}
+	t.s.counters[t.state].Add(-1)
+	if state != StateClosed {
+		t.s.counters[state].Add(1)
+	}
+	t.state = state
+	t.mu.Unlock()
+	if t.s.ConnStateHook != nil {
+		t.s.ConnStateHook(t.conn, state)
+	}
+}
+
+// upgrade 尝试将连接切换到 StateWebSocket 状态，WebSocket 连接数已经达到 max 时返回 false，max 为0时不限制
+// 检查和计数是同一个原子操作，并发的升级不会超过限制
+func (t *connTracker) upgrade(max int) bool {
+	t.mu.Lock()
+	if t.state == StateWebSocket {
+		t.mu.Unlock()
+		return true
+	}
+	counter := &t.s.counters[StateWebSocket]
+	for {
+		n := counter.Load()
+		if max > 0 && n >= int64(max) {
+			t.mu.Unlock()
+			return false
+		}
+		if counter.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	t.s.counters[t.state].Add(-1)
+	t.state = StateWebSocket
+	t.mu.Unlock()
+	if t.s.ConnStateHook != nil {
+		t.s.ConnStateHook(t.conn, StateWebSocket)
+	}
+	return true
+}
+
+// waiting 返回连接是否正在等待请求（StateNew 或 StateIdle）
+func (t *connTracker) waiting() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state == StateNew || t.state == StateIdle
+}
+
+// wakeIdle 在连接正在等待请求（StateNew 或 StateIdle）时让它的读取立即超时
+func (t *connTracker) wakeIdle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == StateNew || t.state == StateIdle {
+		t.conn.SetReadDeadline(time.Now())
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TemplateSet 从一个目录中加载页面模板，并把页面组合到布局中，由 Conn.Render 使用（通过 Server.Templates 配置）。
+// 目录的结构是：
+//
+//	layouts/*.html  布局和共享的片段，每个页面都会和它们一起解析
+//	*.html          页面，可以在子目录中，页面名称是去掉 ".html" 的相对路径，例如 "users/show"
+//
+// 布局通过 {{block "content" .}}{{end}} 等留出位置，页面用 {{define "content"}}...{{end}} 填充它们；
+// 没有 layouts 目录时直接执行页面本身。解析后的模板会被缓存，Dev 为 true 时每次渲染都重新解析，修改模板文件不需要重启
+type TemplateSet struct {
+	Dir    string           // 模板所在的目录
+	Layout string           // 执行的布局文件名，为空时使用 "base.html"
+	Funcs  template.FuncMap // 模板中可以使用的函数
+	Dev    bool             // 开发模式，不缓存解析后的模板
+
+	mu    sync.RWMutex
+	cache map[string]*template.Template
+}
+
+var (
+	errNoTemplates         = errors.New("no templates configured")
+	errInvalidTemplateName = errors.New("invalid template name")
+)
+
+// NewTemplateSet 创建一个从 dir 中加载模板的 TemplateSet
+func NewTemplateSet(dir string) *TemplateSet {
+	return &TemplateSet{Dir: dir}
+}
+
+// Lookup 返回页面 page 和布局组合后的模板，非开发模式下只解析一次
+func (ts *TemplateSet) Lookup(page string) (*template.Template, error) {
+	if !ts.Dev {
+		ts.mu.RLock()
+		t, ok := ts.cache[page]
+		ts.mu.RUnlock()
+		if ok {
+			return t, nil
+		}
+	}
+
+	t, err := ts.parse(page)
+	if err != nil {
+		return nil, err
+	}
+	if !ts.Dev {
+		ts.mu.Lock()
+		if ts.cache == nil {
+			ts.cache = make(map[string]*template.Template)
+		}
+		ts.cache[page] = t
+		ts.mu.Unlock()
+	}
+	return t, nil
+}
+
+// Execute 渲染页面 page，全部渲染成功后才返回结果，执行模板出错时不会留下渲染了一半的输出
+func (ts *TemplateSet) Execute(page string, data interface{}) ([]byte, error) {
+	t, err := ts.Lookup(page)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parse 解析所有的布局和页面 page，返回以布局（没有布局时以页面）为入口的模板
+func (ts *TemplateSet) parse(page string) (*template.Template, error) {
+	if page == "" || strings.Contains(page, "..") || strings.HasPrefix(page, "/") { // 页面名称不能跳出模板目录
+		return nil, errInvalidTemplateName
+	}
+	layouts, err := filepath.Glob(filepath.Join(ts.Dir, "layouts", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	pageFile := filepath.Join(ts.Dir, filepath.FromSlash(page)+".html")
+
+	entry := filepath.Base(pageFile)
+	if len(layouts) > 0 {
+		entry = ts.Layout
+		if entry == "" {
+			entry = "base.html"
+		}
+	}
+
+	// ParseFiles 以文件名作为模板的名称，入口模板必须是第一个创建的模板，Execute 才会执行它
+	t := template.New(entry).Funcs(ts.Funcs)
+	files := append(layouts, pageFile)
+	return t.ParseFiles(files...)
+}
+
+// Render 用 Server.Templates 渲染页面 pageName，成功后以 statusCode 和 text/html 类型写入响应。
+// 模板在写入任何字节之前就已经完整执行，出错时什么也不写入并返回错误，处理器可以再写入一个错误响应（例如 c.WriteError(err)）
+func (c *Conn) Render(statusCode int, pageName string, data interface{}) error {
+	if c.templates == nil {
+		return errNoTemplates
+	}
+	body, err := c.templates.Execute(pageName, data)
+	if err != nil {
+		return err
+	}
+	return c.WriteResponse(statusCode, StatusText(statusCode), body, map[string]string{"Content-Type": "text/html; charset=utf-8"})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverTiming 是 Server-Timing 头部中的一项
+type serverTiming struct {
+	name string
+	dur  time.Duration
+}
+
+// AddServerTiming 记录一个处理阶段的耗时，之后写入的响应会带有一个合并所有记录的 Server-Timing 头部，
+// 例如 "db;dur=53, render;dur=12.5"，浏览器的开发者工具会显示这些耗时。名称必须是一个 token（不能包含空格、逗号、分号等），
+// 不合法的名称会被忽略；同名的记录会重复出现。处理器中可以这样使用：
+//
+//	start := time.Now()
+//	rows := queryDB()
+//	c.AddServerTiming("db", time.Since(start))
+func (c *Conn) AddServerTiming(name string, dur time.Duration) {
+	if c.response == nil || name == "" || strings.ContainsAny(name, "()<>@,;:\\\"/[]?={} \t\r\n") {
+		return
+	}
+	c.response.mu.Lock()
+	c.response.timings = append(c.response.timings, serverTiming{name: name, dur: dur})
+	c.response.mu.Unlock()
+}
+
+// formatServerTiming 将记录格式化为 Server-Timing 头部的值，dur 以毫秒为单位，精确到微秒
+func formatServerTiming(timings []serverTiming) string {
+	var b strings.Builder
+	for i, t := range timings {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(t.name)
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(float64(t.dur.Microseconds())/1000, 'f', -1, 64))
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFormatServerTiming(t *testing.T) {
+	for _, tt := range []struct {
+		timings []serverTiming
+		want    string
+	}{
+		{[]serverTiming{{"db", 53 * time.Millisecond}}, "db;dur=53"},
+		{[]serverTiming{{"db", 53 * time.Millisecond}, {"render", 12500 * time.Microsecond}}, "db;dur=53, render;dur=12.5"},
+		{[]serverTiming{{"cache", 1234 * time.Nanosecond}}, "cache;dur=0.001"}, // 精确到微秒
+		{[]serverTiming{{"noop", 0}}, "noop;dur=0"},
+	} {
+		if got := formatServerTiming(tt.timings); got != tt.want {
+			t.Errorf("formatServerTiming(%v) = %q, want %q", tt.timings, got, tt.want)
+		}
+	}
+}
+
+func TestServerTimingHeader(t *testing.T) {
+	s := &Server{Handler: handlerFunc(func(c *Conn) {
+		c.AddServerTiming("db", 53*time.Millisecond)
+		c.AddServerTiming("bad name", time.Millisecond) // 不是 token 的名称被忽略
+		c.AddServerTiming("", time.Millisecond)
+		c.AddServerTiming("render", 2*time.Millisecond)
+		if c.Message.Path() == "/custom" { // 处理器自己写入的头部优先
+			c.WriteResponse(200, "OK", nil, map[string]string{"Server-Timing": "total;dur=1"})
+			return
+		}
+		c.WriteResponse(200, "OK", nil)
+	})}
+	conn := dial(t, startServer(t, s))
+	reader := bufio.NewReader(conn)
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, _ := readResponse(t, reader); resp.Header.Get("Server-Timing") != "db;dur=53, render;dur=2" || len(resp.Header.Values("Server-Timing")) != 1 {
+		t.Fatalf("Server-Timing = %q", resp.Header.Values("Server-Timing"))
+	}
+
+	// 记录只属于一个请求，下一个请求从空的记录开始
+	io.WriteString(conn, "GET /custom HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, _ := readResponse(t, reader); resp.Header.Get("Server-Timing") != "total;dur=1" || len(resp.Header.Values("Server-Timing")) != 1 {
+		t.Fatalf("custom Server-Timing = %q", resp.Header.Values("Server-Timing"))
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// WebSocket关闭帧中的状态码，见 RFC 6455 7.4.1
+const (
+	WebSocketCloseNormalClosure    = 1000 // 正常关闭
+	WebSocketCloseGoingAway        = 1001 // 服务器关闭或者浏览器离开页面
+	WebSocketCloseProtocolError    = 1002 // 协议错误
+	WebSocketCloseUnsupportedData  = 1003 // 收到了无法处理的数据类型
+	WebSocketCloseNoStatusReceived = 1005 // 关闭帧中没有状态码，不能在关闭帧中发送
+	WebSocketCloseAbnormalClosure  = 1006 // 没有收到关闭帧连接就断开了，不能在关闭帧中发送
+	WebSocketCloseInvalidPayload   = 1007 // 消息的数据与类型不一致，例如文本消息不是合法的UTF-8
+	WebSocketClosePolicyViolation  = 1008 // 消息违反了策略
+	WebSocketCloseMessageTooBig    = 1009 // 消息太大
+	WebSocketCloseInternalError    = 1011 // 服务器遇到了意外的错误
+)
+
+// maxCloseReasonLen 是关闭原因的最大字节数，控制帧的有效载荷不能超过125个字节，其中2个字节是状态码
+const maxCloseReasonLen = 123
+
+var errInvalidCloseCode = errors.New("invalid websocket close code")
+
+// CloseError 是读取时收到对方的关闭帧返回的错误，包含关闭帧中的状态码和原因；
+// 关闭帧为空时 Code 是 WebSocketCloseNoStatusReceived（1005）。
+// errors.Is(err, io.EOF) 对它返回 true，所以只关心连接是否关闭的代码不需要区分它
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return "websocket closed: " + strconv.Itoa(e.Code)
+	}
+	return "websocket closed: " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+// Is 使 errors.Is(err, io.EOF) 对关闭错误返回 true
+func (e *CloseError) Is(target error) bool {
+	return target == io.EOF
+}
+
+// parseClosePayload 解析关闭帧的有效载荷，状态码之后的字节是原因
+func parseClosePayload(payload []byte) *CloseError {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: WebSocketCloseNoStatusReceived}
+	case len(payload) == 1: // 不完整的状态码
+		return &CloseError{Code: WebSocketCloseProtocolError}
+	}
+	return &CloseError{Code: int(binary.BigEndian.Uint16(payload)), Reason: string(payload[2:])}
+}
+
+// closePayload 构造关闭帧的有效载荷，1005、1006 等只在本地使用的状态码不能发送
+func closePayload(code int, reason string) ([]byte, error) {
+	if code < 1000 || code >= 5000 || code == WebSocketCloseNoStatusReceived || code == WebSocketCloseAbnormalClosure || code == 1015 {
+		return nil, errInvalidCloseCode
+	}
+	if len(reason) > maxCloseReasonLen { // 截断时不能切开一个UTF-8字符
+		n := maxCloseReasonLen
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...), nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// readCloseCode 从客户端一侧读取一个关闭帧，返回其中的状态码
+func readCloseCode(t *testing.T, client io.Reader) int {
+	t.Helper()
+	op, payload := readServerFrame(t, client)
+	if op != WebSocketFrameOpCodeClose || len(payload) < 2 {
+		t.Fatalf("frame = %d %q, want a close frame", op, payload)
+	}
+	return int(payload[0])<<8 | int(payload[1])
+}
+
+func TestCloseWebSocketWaitsForPeer(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	closed := make(chan error, 1)
+	go func() { closed <- c.CloseWebSocket() }()
+
+	if code := readCloseCode(t, client); code != WebSocketCloseNormalClosure {
+		t.Fatalf("close code = %d, want %d", code, WebSocketCloseNormalClosure)
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("CloseWebSocket() returned %v before the peer replied", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 对方在回复关闭帧之前发送的数据帧被丢弃
+	client.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("in flight")))
+	client.Write(BuildFrame(true, WebSocketFrameOpCodeClose, true, []byte{0x03, 0xe8}))
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("CloseWebSocket() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CloseWebSocket() did not return after the peer's close frame")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	if c.IsWebSocket() {
+		t.Fatal("IsWebSocket() = true after closing")
+	}
+}
+
+func TestCloseWebSocketNoWait(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	closed := make(chan error, 1)
+	go func() { closed <- c.CloseWebSocketNoWait() }()
+
+	if code := readCloseCode(t, client); code != WebSocketCloseNormalClosure {
+		t.Fatalf("close code = %d, want %d", code, WebSocketCloseNormalClosure)
+	}
+	// 不等待对方回复关闭帧，底层连接立即被关闭
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("CloseWebSocketNoWait() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CloseWebSocketNoWait() waited for the peer")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	if err := c.CloseWebSocketNoWait(); err != ErrNotWebSocket {
+		t.Fatalf("second close = %v, want ErrNotWebSocket", err)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	stdcontext "context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReadWebSocketMessageContext 与 ReadWebSocketMessage 相同，但在 ctx 被取消或者到达截止时间时立即返回 ctx.Err()，
+// 可以用 context.WithTimeout 限制等待一个消息的时间，或者在 select 之外和其他工作一起取消读取。
+// 在下一个消息的第一个字节到达之前取消时连接不受影响，之后可以继续读取；在读到一半时取消，
+// 帧的边界已经无法确定，连接会被关闭
+func (c *Conn) ReadWebSocketMessageContext(ctx stdcontext.Context) (int, []byte, error) {
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return 0, nil, ErrNotWebSocket
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	c.readMu.Lock() // 对读取加锁，写入不受影响
+	defer c.readMu.Unlock()
+	if ctx.Done() == nil { // 永远不会被取消的上下文
+		return c.readMessage(c.readFrame)
+	}
+
+	rc := &readCanceler{conn: c.Conn}
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			rc.cancel()
+		case <-done:
+		}
+	}()
+
+	midFrame, midMessage := false, false // 是否读了一个帧的一部分，是否读了一个分片消息的一部分
+	opCode, payload, err := c.readMessage(func() (bool, bool, int, []byte, error) {
+		if !rc.setDeadline(c.frameDeadline()) {
+			return false, false, 0, nil, ctx.Err()
+		}
+		reader := c.bufReader()
+		if _, err := reader.Peek(1); err != nil { // 等待帧的第一个字节时被取消，还没有消耗任何字节
+			return false, false, 0, nil, err
+		}
+		midFrame = true
+		fin, rsv1, op, data, err := c.readFrameFrom(reader)
+		if err == nil {
+			midFrame = false
+			if !isControl(op) { // 数据帧，分片之间的控制帧不影响消息的边界
+				midMessage = !fin
+			}
+		}
+		return fin, rsv1, op, data, err
+	})
+
+	close(done)
+	<-exited
+	if rc.canceled {
+		if err == nil || (!midFrame && !midMessage) { // 连接停在帧的边界上，清除 cancel 设置的截止时间
+			c.Conn.SetReadDeadline(time.Time{})
+		} else { // 读到一半被取消，之后的字节无法再按帧读取
+			c.Conn.Close()
+		}
+		if err != nil {
+			return 0, nil, ctx.Err()
+		}
+	}
+	return opCode, payload, err
+}
+
+// readCanceler 在上下文被取消时把读取截止时间设置为过去的时刻，使阻塞的读取立即返回
+type readCanceler struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	canceled bool
+}
+
+// cancel 中断正在进行和之后的读取
+func (rc *readCanceler) cancel() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.canceled = true
+	rc.conn.SetReadDeadline(time.Unix(1, 0))
+}
+
+// setDeadline 在还没有被取消时设置读取截止时间，已经被取消时返回 false，不会覆盖 cancel 设置的截止时间
+func (rc *readCanceler) setDeadline(deadline time.Time) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.canceled {
+		return false
+	}
+	rc.conn.SetReadDeadline(deadline)
+	return true
+}
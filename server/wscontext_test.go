@@ -0,0 +1,80 @@
+package server
+
+import (
+	stdcontext "context"
+	"testing"
+	"time"
+)
+
+func TestReadWebSocketMessageContextArrives(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	go client.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("hi")))
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 5*time.Second)
+	defer cancel()
+	if op, payload, err := c.ReadWebSocketMessageContext(ctx); err != nil || op != WebSocketFrameOpCodeText || string(payload) != "hi" {
+		t.Fatalf("ReadWebSocketMessageContext() = %d %q, %v", op, payload, err)
+	}
+}
+
+func TestReadWebSocketMessageContextCancel(t *testing.T) {
+	for name, newContext := range map[string]func() (stdcontext.Context, stdcontext.CancelFunc, error){
+		"cancel": func() (stdcontext.Context, stdcontext.CancelFunc, error) {
+			ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+			time.AfterFunc(30*time.Millisecond, cancel)
+			return ctx, cancel, stdcontext.Canceled
+		},
+		"timeout": func() (stdcontext.Context, stdcontext.CancelFunc, error) {
+			ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 30*time.Millisecond)
+			return ctx, cancel, stdcontext.DeadlineExceeded
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, client := newWebSocketConn(t)
+			ctx, cancel, want := newContext()
+			defer cancel()
+			start := time.Now()
+			if _, _, err := c.ReadWebSocketMessageContext(ctx); err != want {
+				t.Fatalf("ReadWebSocketMessageContext() = %v, want %v", err, want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("returned after %v", elapsed)
+			}
+
+			// 在消息到达之前取消，连接不受影响，之后可以继续读取
+			go client.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("later")))
+			if _, payload, err := c.ReadWebSocketMessageContext(stdcontext.Background()); err != nil || string(payload) != "later" {
+				t.Fatalf("read after cancel = %q, %v", payload, err)
+			}
+		})
+	}
+
+	// 已经被取消的上下文不会读取
+	c, _ := newWebSocketConn(t)
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	cancel()
+	if _, _, err := c.ReadWebSocketMessageContext(ctx); err != stdcontext.Canceled {
+		t.Fatalf("canceled context: %v", err)
+	}
+}
+
+func TestReadWebSocketMessageContextCancelMidFrame(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	frame := BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("truncated"))
+	go client.Write(frame[:4]) // 只发送帧的开头
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.ReadWebSocketMessageContext(ctx); err != stdcontext.DeadlineExceeded {
+		t.Fatalf("ReadWebSocketMessageContext() = %v, want %v", err, stdcontext.DeadlineExceeded)
+	}
+	// 帧的边界已经无法确定，连接被关闭
+	if _, err := client.Write(frame[4:]); err == nil {
+		t.Fatal("connection still open after cancelling mid-frame")
+	}
+}
+
+func TestReadWebSocketMessageContextNotWebSocket(t *testing.T) {
+	c := &Conn{connLocks: &connLocks{}}
+	if _, _, err := c.ReadWebSocketMessageContext(stdcontext.Background()); err != ErrNotWebSocket {
+		t.Fatalf("ReadWebSocketMessageContext() = %v, want %v", err, ErrNotWebSocket)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// SetReadDeadline 设置之后读取WebSocket帧的截止时间，到达截止时间时正在进行和之后的读取返回一个超时错误（net.Error 的 Timeout 为 true），
+// 可以交给 WebSocketHandleError 处理。它和 Server.WebSocketIdleTimeout 同时生效，以较早的为准；零值表示取消截止时间
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wsReadDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(c.frameDeadline())
+}
+
+// SetWriteDeadline 设置之后写入的截止时间，对方停止读取时写入会在截止时间返回超时错误，而不是一直阻塞；零值表示取消截止时间
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// frameDeadline 返回读取下一个帧的截止时间：SetReadDeadline 设置的时刻和从现在开始的空闲超时中较早的一个，都没有设置时返回零值
+func (c *Conn) frameDeadline() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	deadline := c.wsReadDeadline
+	if c.wsIdleTimeout > 0 {
+		if idle := time.Now().Add(c.wsIdleTimeout); deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	return deadline
+}
+
+// StartWebSocketKeepAlive 在后台每隔 interval 发送一个ping帧，并把这个连接的空闲超时设置为 interval+timeout：
+// 活着的对方会回复pong帧，每收到一个帧读取的截止时间都会延后；对方在 timeout 内没有回复时，处理器中正在进行的读取返回超时错误，
+// 通过 WebSocketHandleError 关闭连接。pong帧只有在读取时才会被处理，所以处理器需要一直读取这个连接（例如 ReadWebSocketMessage 的循环）。
+// 返回的 stop 函数停止发送ping，写入ping失败（例如连接已经关闭）时也会自动停止
+func (c *Conn) StartWebSocketKeepAlive(interval, timeout time.Duration) (stop func()) {
+	c.mu.Lock()
+	c.wsIdleTimeout = interval + timeout
+	c.mu.Unlock()
+	c.Conn.SetReadDeadline(c.frameDeadline()) // 正在进行的读取也使用新的超时
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.WriteWebSocketMessage(WebSocketFrameOpCodePing, nil); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DefaultWebSocketCompressionMinSize 是 Server.WebSocketCompressionMinSize 为0时压缩的最小消息长度
+const DefaultWebSocketCompressionMinSize = 128
+
+// webSocketFrameRSV1Bit 是 RSV1 位，permessage-deflate 用它标记被压缩的消息
+const webSocketFrameRSV1Bit = 0x40
+
+// maxDeflateWindow 是 DEFLATE 的滑动窗口大小，对方保留压缩状态时，解压下一个消息需要之前最多这么多字节的输出
+const maxDeflateWindow = 32 << 10
+
+// deflateTail 是压缩后每个消息末尾被去掉的同步标记，解压时补回；之后的空的最终块让解压器在消息结束时返回 io.EOF
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var errInvalidCompressedData = protocolError("invalid compressed message")
+
+// flateWriters 复用压缩器，服务器不保留压缩状态，压缩器在消息之间可以被任意连接使用
+var flateWriters = sync.Pool{New: func() interface{} {
+	zw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return zw
+}}
+
+// permessageDeflate 是一个连接上协商出的 permessage-deflate 扩展
+type permessageDeflate struct {
+	minSize         int  // 压缩的最小消息长度
+	clientNoContext bool // 客户端同意每个消息单独压缩（client_no_context_takeover）
+
+	// history 是之前的消息解压后的最后 maxDeflateWindow 个字节，客户端保留压缩状态时作为解压下一个消息的字典，由 readMu 保护
+	history []byte
+}
+
+// negotiateDeflate 按照客户端的顺序选择第一个可以接受的 permessage-deflate 提议，没有时返回 nil。
+// 服务器的压缩器总是使用最大的窗口，所以不接受要求 server_max_window_bits 小于15的提议；客户端使用的窗口大小不影响解压
+func negotiateDeflate(offers []string, minSize int) *permessageDeflate {
+	for _, value := range offers {
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			d := &permessageDeflate{minSize: minSize}
+			if d.accept(params[1:]) {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// accept 检查一个提议的参数，记录客户端的选择，有未知、重复或者无法满足的参数时返回 false
+func (d *permessageDeflate) accept(params []string) bool {
+	seen := make(map[string]bool)
+	for _, param := range params {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(param), "=")
+		name, value = strings.TrimSpace(name), strings.Trim(strings.TrimSpace(value), `"`)
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+		switch name {
+		case "server_no_context_takeover":
+			if hasValue {
+				return false
+			}
+		case "client_no_context_takeover":
+			if hasValue {
+				return false
+			}
+			d.clientNoContext = true
+		case "server_max_window_bits":
+			if value != "15" {
+				return false
+			}
+		case "client_max_window_bits": // 值可以省略，解压器支持任意大小的窗口
+			if hasValue && !validWindowBits(value) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validWindowBits 判断窗口大小参数是否是8到15之间的数字
+func validWindowBits(value string) bool {
+	switch value {
+	case "8", "9", "10", "11", "12", "13", "14", "15":
+		return true
+	}
+	return false
+}
+
+// responseExtension 返回握手响应中 Sec-WebSocket-Extensions 的值
+func (d *permessageDeflate) responseExtension() string {
+	ext := "permessage-deflate; server_no_context_takeover"
+	if d.clientNoContext {
+		ext += "; client_no_context_takeover"
+	}
+	return ext
+}
+
+// compress 在协商了 permessage-deflate 时压缩一个数据消息，返回要发送的有效载荷和是否被压缩；
+// 控制帧、太短的消息和压缩后没有变小的消息原样发送
+func (c *Conn) compress(opCode int, payload []byte) ([]byte, bool) {
+	if c.deflate == nil || isControl(opCode) || len(payload) < c.deflate.minSize {
+		return payload, false
+	}
+	var buf bytes.Buffer
+	zw := flateWriters.Get().(*flate.Writer)
+	zw.Reset(&buf)
+	zw.Write(payload)
+	err := zw.Flush()
+	flateWriters.Put(zw)
+	compressed := bytes.TrimSuffix(buf.Bytes(), deflateTail[:4]) // 同步标记由接收方补回
+	if err != nil || len(compressed) >= len(payload) {
+		return payload, false
+	}
+	return compressed, true
+}
+
+// inflate 解压一个完整的压缩消息
+func (d *permessageDeflate) inflate(payload []byte) ([]byte, error) {
+	data, err := io.ReadAll(d.reader(bytes.NewReader(payload)))
+	if err != nil {
+		return nil, errInvalidCompressedData
+	}
+	return data, nil
+}
+
+// reader 返回一个流式解压压缩消息的读取器，r 读取消息的所有分片（不包含末尾的同步标记）
+func (d *permessageDeflate) reader(r io.Reader) io.Reader {
+	return &inflateReader{d: d, zr: flate.NewReaderDict(io.MultiReader(r, bytes.NewReader(deflateTail)), d.history)}
+}
+
+// inflateReader 解压一个消息，客户端保留压缩状态时把解压的输出记录到 history 中
+type inflateReader struct {
+	d  *permessageDeflate
+	zr io.ReadCloser
+}
+
+func (ir *inflateReader) Read(p []byte) (int, error) {
+	n, err := ir.zr.Read(p)
+	if !ir.d.clientNoContext && n > 0 {
+		ir.d.remember(p[:n])
+	}
+	if err != nil && err != io.EOF {
+		if _, ok := err.(flate.CorruptInputError); ok {
+			err = errInvalidCompressedData
+		}
+	}
+	return n, err
+}
+
+// remember 把解压的输出追加到 history 中，只保留最后 maxDeflateWindow 个字节
+func (d *permessageDeflate) remember(p []byte) {
+	d.history = append(d.history, p...)
+	if extra := len(d.history) - maxDeflateWindow; extra > 0 {
+		d.history = append(d.history[:0], d.history[extra:]...)
+	}
+}
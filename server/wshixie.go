@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 旧的 draft-hixie-thewebsocketprotocol-76 握手和帧格式，只在 Server.WebSocketLegacyHixie 为 true 时使用。
+// 这个版本只有以 0x00 开始、以 0xFF 结束的UTF-8文本帧和 0xFF 0x00 关闭帧，没有二进制消息、ping/pong 和分片
+
+var (
+	// errHixieUnsupported 表示 Hixie-76 连接不支持这个操作，例如二进制消息和分片
+	errHixieUnsupported = errors.New("operation not supported by hixie-76 websocket")
+
+	// errHixieKey 表示 Sec-WebSocket-Key1 或 Sec-WebSocket-Key2 不是合法的密钥
+	errHixieKey = errors.New("invalid hixie-76 websocket key")
+)
+
+// hixieHandshake 判断请求是否是一个 Hixie-76 握手：没有 Sec-WebSocket-Version，带有 Sec-WebSocket-Key1 和 Sec-WebSocket-Key2
+func hixieHandshake(c *Conn) bool {
+	return c.legacyHixie && c.Message.Header("Sec-WebSocket-Version") == "" &&
+		c.Message.Header("Sec-WebSocket-Key1") != "" && c.Message.Header("Sec-WebSocket-Key2") != ""
+}
+
+// upgradeHixie 完成 Hixie-76 握手：读取请求头之后的8字节 key3，回复 101 和由两个密钥与 key3 计算出的16字节 MD5 应答，调用者需要持有 c.mu
+func (c *Conn) upgradeHixie(protocols []string) error {
+	key1, ok1 := hixieKeyNumber(c.Message.Header("Sec-WebSocket-Key1"))
+	key2, ok2 := hixieKeyNumber(c.Message.Header("Sec-WebSocket-Key2"))
+	if !ok1 || !ok2 {
+		return c.rejectUpgrade(errHixieKey)
+	}
+
+	if !c.wsHandshakeDeadline.IsZero() {
+		c.Conn.SetDeadline(c.wsHandshakeDeadline)
+		defer c.Conn.SetDeadline(time.Time{})
+	}
+
+	// key3 是请求头之后的8个字节，客户端不发送 Content-Length，它们还留在缓冲读取器中
+	var key3 [8]byte
+	if _, err := io.ReadFull(c.bufReader(), key3[:]); err != nil {
+		return err
+	}
+
+	if c.tracker != nil && !c.tracker.upgrade(c.tracker.s.MaxWebSocketConns) {
+		return c.rejectUpgrade(errTooManyWebSockets)
+	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	scheme := "ws://"
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		scheme = "wss://"
+	}
+	target := c.Message.Path()
+	if fields := strings.Fields(c.Message.StartLine); len(fields) == 3 {
+		target = fields[1]
+	}
+
+	protocol := selectSubprotocol(c.Message.HeaderValues("Sec-WebSocket-Protocol"), protocols)
+	response := "HTTP/1.1 101 WebSocket Protocol Handshake\r\nUpgrade: WebSocket\r\nConnection: Upgrade\r\n"
+	if origin := c.Message.Header("Origin"); origin != "" {
+		response += "Sec-WebSocket-Origin: " + origin + "\r\n"
+	}
+	response += "Sec-WebSocket-Location: " + scheme + c.Message.Header("Host") + target + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	challenge := hixieChallenge(key1, key2, key3)
+	response += "\r\n" + string(challenge[:])
+
+	if _, err := c.Conn.Write([]byte(response)); err != nil {
+		return err
+	}
+	if c.response != nil {
+		c.response.mu.Lock()
+		c.response.status = 101
+		c.response.mu.Unlock()
+	}
+
+	c.Data["websocket"] = true
+	c.hixie = true
+	if protocol != "" {
+		c.Data["ws_protocol"] = protocol
+	}
+	return nil
+}
+
+// hixieKeyNumber 计算密钥对应的数字：密钥中所有数字组成的整数除以其中空格的数量，没有空格、不能整除或者超出32位时返回 false
+func hixieKeyNumber(key string) (uint32, bool) {
+	var digits strings.Builder
+	spaces := 0
+	for _, r := range key {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ':
+			spaces++
+		}
+	}
+	n, err := strconv.ParseUint(digits.String(), 10, 64)
+	if err != nil || spaces == 0 || n%uint64(spaces) != 0 || n/uint64(spaces) > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(n / uint64(spaces)), true
+}
+
+// hixieChallenge 返回握手应答：两个密钥数字的大端表示和 key3 连接之后的 MD5
+func hixieChallenge(key1, key2 uint32, key3 [8]byte) [16]byte {
+	var b [16]byte
+	binary.BigEndian.PutUint32(b[0:4], key1)
+	binary.BigEndian.PutUint32(b[4:8], key2)
+	copy(b[8:], key3[:])
+	return md5.Sum(b[:])
+}
+
+// readHixieFrame 读取一个 Hixie-76 帧，以 readWebSocketFrame 的形式返回：文本帧返回 WebSocketFrameOpCodeText，
+// 0xFF 0x00 返回 WebSocketFrameOpCodeClose；其他带长度的帧没有定义，被丢弃
+func readHixieFrame(reader *bufio.Reader) (bool, bool, int, []byte, error) {
+	for {
+		frameType, err := reader.ReadByte()
+		if err != nil {
+			return false, false, 0, nil, err
+		}
+
+		if frameType&0x80 == 0 { // 以 0xFF 结束的文本帧
+			data, err := reader.ReadBytes(0xFF)
+			if err != nil {
+				return false, false, 0, nil, err
+			}
+			if frameType != 0x00 { // 未定义的文本帧类型
+				continue
+			}
+			return true, false, WebSocketFrameOpCodeText, data[:len(data)-1], nil
+		}
+
+		var length uint64 // 以每字节7位的大端形式编码的长度
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return false, false, 0, nil, err
+			}
+			if length > math.MaxUint64>>7 {
+				return false, false, 0, nil, errInvalidFrame
+			}
+			length = length<<7 | uint64(b&0x7F)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		if frameType == 0xFF && length == 0 { // 关闭帧
+			return true, false, WebSocketFrameOpCodeClose, nil, nil
+		}
+		if length > math.MaxInt32 {
+			return false, false, 0, nil, errInvalidFrame
+		}
+		if _, err := reader.Discard(int(length)); err != nil {
+			return false, false, 0, nil, err
+		}
+	}
+}
+
+// hixieFrame 构造一个 Hixie-76 帧：文本消息写成 0x00 数据 0xFF，关闭帧写成 0xFF 0x00（不带状态码），
+// ping 和 pong 在这个版本中没有对应的帧，返回 nil，二进制消息返回错误
+func hixieFrame(opCode int, payload []byte) ([]byte, error) {
+	switch opCode {
+	case WebSocketFrameOpCodeText:
+		frame := make([]byte, 0, len(payload)+2)
+		frame = append(frame, 0x00)
+		frame = append(frame, payload...)
+		return append(frame, 0xFF), nil
+	case WebSocketFrameOpCodeClose:
+		return []byte{0xFF, 0x00}, nil
+	case WebSocketFrameOpCodePing, WebSocketFrameOpCodePong:
+		return nil, nil
+	}
+	return nil, errHixieUnsupported
+}
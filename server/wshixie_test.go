@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// hixieRequest 是 draft-hixie-thewebsocketprotocol-76 第1.3节中的握手示例，最后8个字节是 key3
+const hixieRequest = "GET /demo HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Key2: 12998 5 Y3 1  .P00\r\n" +
+	"Sec-WebSocket-Protocol: sample\r\n" +
+	"Upgrade: WebSocket\r\n" +
+	"Sec-WebSocket-Key1: 4 @1  46546xW%0l 1 5\r\n" +
+	"Origin: http://example.com\r\n" +
+	"\r\n" +
+	"^n:ds[4U"
+
+func TestHixieKeyNumber(t *testing.T) {
+	for key, want := range map[string]uint32{
+		"4 @1  46546xW%0l 1 5": 829309203, // 4146546015 / 5 个空格
+		"12998 5 Y3 1  .P00":   259970620, // 1299853100 / 5 个空格
+	} {
+		if n, ok := hixieKeyNumber(key); !ok || n != want {
+			t.Errorf("hixieKeyNumber(%q) = %d, %v, want %d", key, n, ok, want)
+		}
+	}
+	for _, key := range []string{"12345", "1 2 3x"} { // 没有空格；数字不能被空格数整除
+		if _, ok := hixieKeyNumber(key); ok {
+			t.Errorf("hixieKeyNumber(%q) accepted an invalid key", key)
+		}
+	}
+}
+
+func TestHixieHandshake(t *testing.T) {
+	errs := make(chan error, 1)
+	s := &Server{WebSocketLegacyHixie: true, Handler: handlerFunc(func(c *Conn) {
+		if err := c.UpgradeToWebSocketWithProtocols("sample"); err != nil {
+			errs <- err
+			return
+		}
+		op, payload, err := c.ReadWebSocketMessage()
+		if err != nil {
+			errs <- err
+			return
+		}
+		c.WriteWebSocketMessage(op, payload)
+	})}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, hixieRequest)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"Upgrade":                "WebSocket",
+		"Sec-WebSocket-Origin":   "http://example.com",
+		"Sec-WebSocket-Location": "ws://example.com/demo",
+		"Sec-WebSocket-Protocol": "sample",
+	} {
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if resp.StatusCode != 101 {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	// 响应头之后是16字节的 MD5 应答，示例中给出的值
+	challenge := make([]byte, 16)
+	if _, err := io.ReadFull(reader, challenge); err != nil || string(challenge) != "8jKS'y:G*Co,Wxa-" {
+		t.Fatalf("challenge = %q, %v", challenge, err)
+	}
+
+	// Hixie-76 的文本帧以 0x00 开始、以 0xFF 结束
+	conn.Write([]byte("\x00hello\xff"))
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(reader, frame); err != nil || string(frame) != "\x00hello\xff" {
+		t.Fatalf("echo = %q, %v", frame, err)
+	}
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestHixieHandshakeDisabled(t *testing.T) {
+	errs := make(chan error, 1)
+	conn := dial(t, startServer(t, &Server{Handler: echoWebSocket(errs)}))
+	io.WriteString(conn, hixieRequest)
+	if resp, _ := readResponse(t, bufio.NewReader(conn)); resp.StatusCode == 101 {
+		t.Fatal("Hixie-76 handshake accepted without WebSocketLegacyHixie")
+	}
+	if err := <-errs; err != errUnsupportedProtocol {
+		t.Fatalf("UpgradeToWebSocket() = %v, want %v", err, errUnsupportedProtocol)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import "sync"
+
+// Hub 管理一组WebSocket连接，用于向所有连接广播消息，例如聊天室
+type Hub struct {
+	// OnBroadcastError 在 Broadcast 向一个连接写入失败、把它移出 Hub 之前被调用，可以用来记录日志或者清理和这个连接关联的状态。
+	// 调用时不持有 Hub 的锁，回调中可以再调用 Hub 的方法；为 nil 时失败的连接只是被移出 Hub
+	OnBroadcastError func(conn *Conn, err error)
+
+	mu    sync.RWMutex
+	conns map[*Conn]struct{}
+}
+
+// NewHub 创建一个空的 Hub
+func NewHub() *Hub {
+	return &Hub{conns: make(map[*Conn]struct{})}
+}
+
+// Register 将一个已经升级的连接加入 Hub，处理器应该在连接结束时调用 Unregister，例如
+//
+//	hub.Register(c)
+//	defer hub.Unregister(c)
+func (h *Hub) Register(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[c] = struct{}{}
+}
+
+// Unregister 将连接移出 Hub，连接不在 Hub 中时什么也不做
+func (h *Hub) Unregister(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c)
+}
+
+// Len 返回 Hub 中的连接数
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// Broadcast 向 Hub 中的每个连接发送一个消息，依次写入，不持有 Hub 的锁，写入期间可以注册和移除连接。
+// 写入失败的连接会先交给 OnBroadcastError，再被移出 Hub；返回成功写入的连接数
+func (h *Hub) Broadcast(opCode int, payload []byte) int {
+	h.mu.RLock()
+	conns := make([]*Conn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+
+	sent := 0
+	for _, c := range conns {
+		if err := c.WriteWebSocketMessage(opCode, payload); err != nil {
+			if h.OnBroadcastError != nil {
+				h.OnBroadcastError(c, err)
+			}
+			h.Unregister(c)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
@@ -0,0 +1,53 @@
+package server
+
+import "testing"
+
+func TestHubBroadcastDropsFailedConn(t *testing.T) {
+	hub := NewHub()
+	received := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		c, client := newWebSocketConn(t)
+		hub.Register(c)
+		go func() {
+			_, payload := readServerFrame(t, client)
+			received <- string(payload)
+		}()
+	}
+	broken, client := newWebSocketConn(t)
+	client.Close() // 对方已经断开，写入会失败
+	hub.Register(broken)
+
+	var failed []*Conn
+	hub.OnBroadcastError = func(conn *Conn, err error) {
+		if err == nil {
+			t.Error("OnBroadcastError called with a nil error")
+		}
+		failed = append(failed, conn)
+		hub.Len() // 回调中可以调用 Hub 的方法，不会死锁
+	}
+
+	if sent := hub.Broadcast(WebSocketFrameOpCodeText, []byte("news")); sent != 2 {
+		t.Fatalf("Broadcast() = %d, want 2", sent)
+	}
+	for i := 0; i < 2; i++ {
+		if payload := <-received; payload != "news" {
+			t.Fatalf("received %q", payload)
+		}
+	}
+	if len(failed) != 1 || failed[0] != broken {
+		t.Fatalf("OnBroadcastError called for %v, want only the broken conn", failed)
+	}
+	if n := hub.Len(); n != 2 {
+		t.Fatalf("Len() = %d after a failed write, want 2", n)
+	}
+}
+
+func TestHubBroadcastWithoutHook(t *testing.T) {
+	hub := NewHub()
+	broken, client := newWebSocketConn(t)
+	client.Close()
+	hub.Register(broken)
+	if sent := hub.Broadcast(WebSocketFrameOpCodeText, []byte("news")); sent != 0 || hub.Len() != 0 {
+		t.Fatalf("Broadcast() = %d, Len() = %d, want the failed conn removed", sent, hub.Len())
+	}
+}
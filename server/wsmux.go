@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// WSHandlerFunc 处理一个WebSocket消息，payload 是完整的原始JSON消息，处理器可以将它解码为自己的结构体
+type WSHandlerFunc func(c *Conn, payload []byte)
+
+// WSMux 根据JSON消息中的 "type" 字段将WebSocket消息分发给不同的处理器，类似于HTTP的路由
+type WSMux struct {
+	mu       sync.RWMutex
+	handlers map[string]WSHandlerFunc
+	fallback WSHandlerFunc
+}
+
+// NewWSMux 创建一个空的 WSMux
+func NewWSMux() *WSMux {
+	return &WSMux{
+		handlers: make(map[string]WSHandlerFunc),
+	}
+}
+
+// Handle 注册处理 msgType 类型消息的处理器
+func (m *WSMux) Handle(msgType string, handler WSHandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[msgType] = handler
+}
+
+// HandleDefault 注册处理未知类型消息的默认处理器
+func (m *WSMux) HandleDefault(handler WSHandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallback = handler
+}
+
+var errUnknownMessageType = errors.New("unknown websocket message type")
+
+// Dispatch 解析消息的 "type" 字段并调用对应的处理器，没有匹配的处理器时调用默认处理器
+// 消息不是合法的JSON对象时返回错误，没有匹配的处理器也没有默认处理器时返回 errUnknownMessageType
+func (m *WSMux) Dispatch(c *Conn, message []byte) error {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	handler, ok := m.handlers[envelope.Type]
+	if !ok {
+		handler = m.fallback
+	}
+	m.mu.RUnlock()
+
+	if handler == nil {
+		return errUnknownMessageType
+	}
+	handler(c, message)
+	return nil
+}
+
+// ServeWebSocket 循环读取连接上的文本消息并分发，直到读取出错，返回这个错误
+// 无法解析或没有处理器的消息会被忽略
+func (m *WSMux) ServeWebSocket(c *Conn) error {
+	for {
+		opCode, payload, err := c.ReadWebSocketMessage()
+		if err != nil {
+			return err
+		}
+		if opCode != WebSocketFrameOpCodeText {
+			continue
+		}
+		m.Dispatch(c, payload)
+	}
+}
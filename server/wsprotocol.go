@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrWebSocketProtocol 表示对方发送的帧违反了 WebSocket 协议（RFC 6455），例如设置了保留位、使用了保留的操作码、
+// 客户端的帧没有掩码、控制帧过长或者被分片，以及延续帧出现在错误的位置。读取方法返回的这类错误满足 errors.Is(err, ErrWebSocketProtocol)，
+// 之后连接中的字节已经无法按帧读取，WebSocketHandleError 会以 1002（WebSocketCloseProtocolError）关闭连接
+var ErrWebSocketProtocol = errors.New("websocket protocol error")
+
+// ErrWebSocketInvalidUTF8 表示对方发送的文本消息不是合法的UTF-8，WebSocketHandleError 会以 1007（WebSocketCloseInvalidPayload）关闭连接
+var ErrWebSocketInvalidUTF8 = errors.New("websocket text message is not valid UTF-8")
+
+// protocolError 是一个具体的协议错误，errors.Is 把它匹配到 ErrWebSocketProtocol
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "websocket protocol error: " + string(e)
+}
+
+// Is 使 errors.Is(err, ErrWebSocketProtocol) 对协议错误返回 true
+func (e protocolError) Is(target error) bool {
+	return target == ErrWebSocketProtocol
+}
+
+var (
+	errReservedBits    = protocolError("reserved bits set")
+	errReservedOpCode  = protocolError("reserved opcode")
+	errControlTooLong  = protocolError("control frame payload too long")
+	errControlFragment = protocolError("fragmented control frame")
+	errUnmaskedFrame   = protocolError("unmasked client frame")
+)
+
+// maxControlPayloadLen 是控制帧有效载荷的最大字节数
+const maxControlPayloadLen = 125
+
+// validOpCode 判断操作码是否是已经定义的操作码，0x3-0x7 和 0xB-0xF 是保留的
+func validOpCode(op int) bool {
+	return op <= WebSocketFrameOpCodeBinary || (op >= WebSocketFrameOpCodeClose && op <= WebSocketFrameOpCodePong)
+}
+
+// validUTF8Prefix 检查 b 是否是合法的UTF-8，允许末尾有一个被截断的字符（它的剩余部分在下一个分片中）：
+// 返回完整字符的字节数，以及是否没有遇到非法的字节
+func validUTF8Prefix(b []byte) (int, bool) {
+	n := 0
+	for n < len(b) {
+		if b[n] < utf8.RuneSelf {
+			n++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[n:])
+		if r == utf8.RuneError && size == 1 {
+			return n, !utf8.FullRune(b[n:]) // 末尾不完整的字符还可能是合法的
+		}
+		n += size
+	}
+	return n, true
+}
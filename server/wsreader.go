@@ -0,0 +1,139 @@
+package server
+
+import "io"
+
+// WebSocketMessageReader 读取下一个数据消息的第一个帧，返回消息的操作码和一个按分片流式读取有效载荷的读取器
+// 与 ReadWebSocketMessage 不同，它不会把所有分片重组到内存中，适合处理或转发很大的消息。
+// 分片之间到达的控制帧由读取器透明地处理：ping 帧自动回复 pong，pong 帧被忽略，关闭帧使读取器返回 io.ErrUnexpectedEOF。
+// 读取锁从调用开始一直持有到读取器返回 io.EOF 或错误为止，其他协程的 ReadWebSocketMessage 会等待这个消息被读完，
+// 所以一定要把读取器读到结束。被 permessage-deflate 压缩的消息在读取时流式解压。
+func (c *Conn) WebSocketMessageReader() (opCode int, r io.Reader, err error) {
+	if !c.webSocketOpen() { // 如果不是一个WebSocket连接，返回错误
+		return 0, nil, ErrNotWebSocket
+	}
+
+	c.readMu.Lock() // 对读取加锁，写入不受影响；返回读取器时由它负责解锁
+	mr := &messageReader{c: c}
+	for {
+		fin, rsv1, op, payload, err := c.readFrame()
+		if err != nil {
+			c.readMu.Unlock()
+			return 0, nil, err
+		}
+		handled, err := mr.handleControl(op, payload)
+		if err != nil {
+			c.readMu.Unlock()
+			if err == io.ErrUnexpectedEOF { // 在消息开始之前收到关闭帧，和 ReadWebSocketMessage 一样返回 *CloseError
+				return op, nil, parseClosePayload(payload)
+			}
+			return 0, nil, err
+		}
+		if handled {
+			continue
+		}
+		if op == 0 { // 消息不能以延续帧开始
+			c.readMu.Unlock()
+			return 0, nil, errInvalidFrame
+		}
+		mr.payload, mr.fin = payload, fin
+		var r io.Reader = mr
+		if rsv1 {
+			r = c.deflate.reader(mr)
+		}
+		return op, &lockedMessageReader{c: c, r: r, text: op == WebSocketFrameOpCodeText}, nil
+	}
+}
+
+// lockedMessageReader 是 WebSocketMessageReader 返回的读取器，持有连接的读取锁，第一次返回错误（包括 io.EOF）时释放它
+type lockedMessageReader struct {
+	c   *Conn
+	r   io.Reader // 读取有效载荷的读取器，压缩的消息在这里解压
+	err error     // 已经返回过的错误，之后的读取都返回它
+
+	// 文本消息在读取时逐段检查UTF-8，和 ReadWebSocketMessage 一样，不合法时返回 ErrWebSocketInvalidUTF8
+	text    bool
+	partial []byte // 上一次读取末尾被截断的字符，和这一次读取的开头一起检查
+}
+
+func (lr *lockedMessageReader) Read(p []byte) (int, error) {
+	if lr.err != nil {
+		return 0, lr.err
+	}
+	n, err := lr.r.Read(p)
+	if lr.text && (n > 0 || err == io.EOF) {
+		n, err = lr.checkUTF8(p[:n], err)
+	}
+	if err != nil {
+		lr.err = err
+		lr.c.readMu.Unlock()
+	}
+	return n, err
+}
+
+// checkUTF8 检查读取到的 b 和之前被截断的字符是否是合法的UTF-8，不合法时只返回其中合法部分的长度；
+// 消息结束（err 为 io.EOF）时不能留下不完整的字符
+func (lr *lockedMessageReader) checkUTF8(b []byte, err error) (int, error) {
+	data := append(lr.partial, b...)
+	valid, ok := validUTF8Prefix(data)
+	if !ok || (err == io.EOF && valid < len(data)) {
+		if valid < len(lr.partial) {
+			return 0, ErrWebSocketInvalidUTF8
+		}
+		return valid - len(lr.partial), ErrWebSocketInvalidUTF8
+	}
+	lr.partial = append(lr.partial[:0], data[valid:]...)
+	return len(b), err
+}
+
+// messageReader 逐个分片读取一个WebSocket消息的有效载荷
+type messageReader struct {
+	c       *Conn
+	payload []byte // 当前分片中还没有被读取的有效载荷
+	fin     bool   // 当前分片是否是最后一个分片
+}
+
+func (mr *messageReader) Read(p []byte) (int, error) {
+	for len(mr.payload) == 0 {
+		if mr.fin {
+			return 0, io.EOF
+		}
+		fin, rsv1, op, payload, err := mr.c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		handled, err := mr.handleControl(op, payload)
+		if err != nil {
+			return 0, err
+		}
+		if handled {
+			continue
+		}
+		if op != 0 { // 分片之间只能出现延续帧（操作码为0）
+			return 0, errInvalidFrame
+		}
+		if rsv1 { // 只有第一个分片可以设置 RSV1
+			return 0, errReservedBits
+		}
+		mr.payload, mr.fin = payload, fin
+	}
+
+	n := copy(p, mr.payload)
+	mr.payload = mr.payload[n:]
+	return n, nil
+}
+
+// handleControl 处理控制帧，返回这个帧是否是已经处理过的控制帧
+func (mr *messageReader) handleControl(op int, payload []byte) (bool, error) {
+	switch op {
+	case WebSocketFrameOpCodePing: // 回复一个携带相同有效载荷的pong帧
+		if err := mr.c.WriteWebSocketMessage(WebSocketFrameOpCodePong, payload); err != nil {
+			return true, err
+		}
+		return true, nil
+	case WebSocketFrameOpCodePong:
+		return true, nil
+	case WebSocketFrameOpCodeClose: // 对方在消息结束之前关闭了连接
+		return true, io.ErrUnexpectedEOF
+	}
+	return false, nil
+}
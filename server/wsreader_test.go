@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newWebSocketConn 返回一个已经升级的 Conn 和连接的另一端（客户端），用于直接测试帧的读写
+func newWebSocketConn(t testing.TB) (*Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	c := &Conn{Conn: server, Data: map[string]interface{}{"websocket": true}, reader: bufio.NewReader(server), connLocks: &connLocks{}}
+	return c, client
+}
+
+func TestWebSocketMessageReaderHoldsReadLock(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	go func() {
+		client.Write(BuildFrame(false, WebSocketFrameOpCodeText, true, []byte("hel")))
+		client.Write(BuildFrame(true, 0, true, []byte("lo")))
+		client.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("next")))
+	}()
+
+	op, r, err := c.WebSocketMessageReader()
+	if err != nil || op != WebSocketFrameOpCodeText {
+		t.Fatalf("WebSocketMessageReader() = %d, %v", op, err)
+	}
+
+	type result struct {
+		payload []byte
+		err     error
+	}
+	next := make(chan result, 1)
+	go func() {
+		_, payload, err := c.ReadWebSocketMessage()
+		next <- result{payload, err}
+	}()
+	select {
+	case res := <-next:
+		t.Fatalf("ReadWebSocketMessage returned %q, %v while a streamed message was unfinished", res.payload, res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("streamed message = %q, %v", data, err)
+	}
+	if res := <-next; res.err != nil || string(res.payload) != "next" {
+		t.Fatalf("next message = %q, %v", res.payload, res.err)
+	}
+}
+
+func TestWebSocketMessageReaderClosed(t *testing.T) {
+	c, _ := newWebSocketConn(t)
+	c.Data["websocket"] = false
+	if _, _, err := c.WebSocketMessageReader(); err != ErrNotWebSocket {
+		t.Fatalf("err = %v, want ErrNotWebSocket", err)
+	}
+}
+
+// readServerFrame 从客户端一侧读取服务器发送的一个没有掩码的帧，返回操作码和有效载荷
+func readServerFrame(t testing.TB, client io.Reader) (int, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(client, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(head[1] & WebSocketFramePayloadLenMask)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(client, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(client, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(head[0] & WebSocketFrameOpCodeMask), payload
+}
+
+func TestPingEchoesPayload(t *testing.T) {
+	for name, read := range map[string]func(c *Conn) ([]byte, error){
+		"ReadWebSocketMessage": func(c *Conn) ([]byte, error) {
+			_, payload, err := c.ReadWebSocketMessage()
+			return payload, err
+		},
+		"WebSocketMessageReader": func(c *Conn) ([]byte, error) {
+			_, r, err := c.WebSocketMessageReader()
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(r)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, client := newWebSocketConn(t)
+			done := make(chan []byte, 1)
+			go func() {
+				payload, err := read(c)
+				if err != nil {
+					t.Error(err)
+				}
+				done <- payload
+			}()
+			client.Write(BuildFrame(true, WebSocketFrameOpCodePing, true, []byte("beat")))
+			if op, payload := readServerFrame(t, client); op != WebSocketFrameOpCodePong || string(payload) != "beat" {
+				t.Fatalf("reply = %d %q, want pong \"beat\"", op, payload)
+			}
+			client.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("hi")))
+			if payload := <-done; string(payload) != "hi" {
+				t.Fatalf("message = %q", payload)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessageReaderInvalidUTF8(t *testing.T) {
+	for name, fragments := range map[string][][]byte{
+		"invalid byte":         {[]byte("ok"), []byte("\xff")},
+		"invalid continuation": {[]byte("caf\xc3"), []byte("(")},
+		"truncated at the end": {[]byte("caf"), []byte("\xc3")},
+		"surrogate half":       {[]byte("\xed\xa0"), []byte("\x80")},
+	} {
+		fragments := fragments
+		t.Run(name, func(t *testing.T) {
+			c, client := newWebSocketConn(t)
+			go func() {
+				for i, fragment := range fragments {
+					op := WebSocketFrameOpCodeText
+					if i > 0 {
+						op = 0
+					}
+					client.Write(BuildFrame(i == len(fragments)-1, op, true, fragment))
+				}
+			}()
+			_, r, err := c.WebSocketMessageReader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); err != ErrWebSocketInvalidUTF8 {
+				t.Fatalf("err = %v, want ErrWebSocketInvalidUTF8", err)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessageReaderSplitRune(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	go func() {
+		client.Write(BuildFrame(false, WebSocketFrameOpCodeText, true, []byte("caf\xc3")))
+		client.Write(BuildFrame(true, 0, true, []byte("\xa9!")))
+	}()
+	_, r, err := c.WebSocketMessageReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "café!" {
+		t.Fatalf("message = %q, %v", data, err)
+	}
+}
+
+func TestReadWebSocketMessageInvalidUTF8(t *testing.T) {
+	for name, tt := range map[string]struct {
+		fragments [][]byte
+		payload   string
+		err       error
+	}{
+		"split rune":           {[][]byte{[]byte("caf\xc3"), []byte("\xa9!")}, "café!", nil},
+		"invalid byte":         {[][]byte{[]byte("ok"), []byte("\xff")}, "", ErrWebSocketInvalidUTF8},
+		"invalid continuation": {[][]byte{[]byte("caf\xc3"), []byte("(")}, "", ErrWebSocketInvalidUTF8},
+		"truncated at the end": {[][]byte{[]byte("caf"), []byte("\xc3")}, "", ErrWebSocketInvalidUTF8},
+		"single frame":         {[][]byte{[]byte("\xc0\xaf")}, "", ErrWebSocketInvalidUTF8},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			c, client := newWebSocketConn(t)
+			go func() {
+				for i, fragment := range tt.fragments {
+					op := WebSocketFrameOpCodeText
+					if i > 0 {
+						op = 0
+					}
+					client.Write(BuildFrame(i == len(tt.fragments)-1, op, true, fragment))
+				}
+			}()
+			_, payload, err := c.ReadWebSocketMessage()
+			if err != tt.err || string(payload) != tt.payload {
+				t.Fatalf("ReadWebSocketMessage() = %q, %v, want %q, %v", payload, err, tt.payload, tt.err)
+			}
+		})
+	}
+
+	// 二进制消息不检查UTF-8
+	c, client := newWebSocketConn(t)
+	go client.Write(BuildFrame(true, WebSocketFrameOpCodeBinary, true, []byte("\xff\xfe")))
+	if _, payload, err := c.ReadWebSocketMessage(); err != nil || string(payload) != "\xff\xfe" {
+		t.Fatalf("binary message = %q, %v", payload, err)
+	}
+}
+
+func TestWebSocketHandleErrorInvalidUTF8(t *testing.T) {
+	c, client := newWebSocketConn(t)
+	go func() {
+		_, _, err := c.ReadWebSocketMessage()
+		c.WebSocketHandleError(err)
+	}()
+	client.Write(BuildFrame(true, WebSocketFrameOpCodeText, true, []byte("\xff")))
+
+	op, payload := readServerFrame(t, client)
+	if op != WebSocketFrameOpCodeClose || len(payload) < 2 {
+		t.Fatalf("reply = %d %q, want a close frame", op, payload)
+	}
+	if code := int(payload[0])<<8 | int(payload[1]); code != WebSocketCloseInvalidPayload {
+		t.Fatalf("close code = %d, want %d", code, WebSocketCloseInvalidPayload)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF { // 发送关闭帧后不等待对方的回复，直接关闭连接
+		t.Fatalf("connection not closed: %v", err)
+	}
+}
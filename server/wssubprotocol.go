@@ -0,0 +1,26 @@
+package server
+
+import "strings"
+
+// WebSocketProtocol 返回 UpgradeToWebSocketWithProtocols 协商出的子协议，没有协商出子协议时返回空字符串
+func (c *Conn) WebSocketProtocol() string {
+	value, _ := c.Get("ws_protocol")
+	protocol, _ := value.(string)
+	return protocol
+}
+
+// selectSubprotocol 按照客户端的顺序返回第一个服务器也支持的子协议，子协议的名称区分大小写；
+// offered 是 Sec-WebSocket-Protocol 头部的所有值，每个值都可以是逗号分隔的列表
+func selectSubprotocol(offered []string, supported []string) string {
+	for _, value := range offered {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			for _, s := range supported {
+				if protocol != "" && protocol == s {
+					return protocol
+				}
+			}
+		}
+	}
+	return ""
+}